@@ -0,0 +1,835 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// aofFileType is the role of a file listed in the manifest of the append only
+// file, written as a single letter like redis does.
+type aofFileType byte
+
+const (
+	// aofBaseFile holds a dump of the keyspace, the starting point of the replay
+	aofBaseFile aofFileType = 'b'
+	// aofHistoryFile was replaced by a rewrite and is waiting to be deleted
+	aofHistoryFile aofFileType = 'h'
+	// aofIncrFile holds the write commands that followed the base
+	aofIncrFile aofFileType = 'i'
+)
+
+type aofFile struct {
+	name string
+	seq  int64
+	kind aofFileType
+}
+
+// aofManifest lists the files making the append only file: a base, then the
+// incremental files replayed over it in order.
+type aofManifest struct {
+	base    *aofFile
+	incrs   []aofFile
+	history []aofFile
+	// baseSeq and incrSeq are the last sequence numbers given to a base and to
+	// an incremental file
+	baseSeq int64
+	incrSeq int64
+}
+
+// parseAOFManifest parses a manifest made of lines such as
+// "file appendonly.aof.1.base.rdb seq 1 type b". Unknown keys are ignored for
+// compatibility with newer versions, like redis does.
+func parseAOFManifest(r io.Reader) (*aofManifest, error) {
+	m := &aofManifest{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid AOF manifest file format at line %d", line)
+		}
+		var f aofFile
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				f.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil || seq <= 0 {
+					return nil, fmt.Errorf("invalid AOF file sequence at line %d", line)
+				}
+				f.seq = seq
+			case "type":
+				if len(fields[i+1]) != 1 {
+					return nil, fmt.Errorf("unknown AOF file type at line %d", line)
+				}
+				f.kind = aofFileType(fields[i+1][0])
+			}
+		}
+		if f.name == "" || f.seq == 0 || f.kind == 0 {
+			return nil, fmt.Errorf("incomplete AOF file information at line %d", line)
+		}
+		switch f.kind {
+		case aofBaseFile:
+			if m.base != nil {
+				return nil, fmt.Errorf("duplicate AOF base file at line %d", line)
+			}
+			m.base = &f
+			m.baseSeq = f.seq
+		case aofHistoryFile:
+			m.history = append(m.history, f)
+		case aofIncrFile:
+			if f.seq <= m.incrSeq {
+				return nil, fmt.Errorf("non monotonic AOF incremental file sequence at line %d", line)
+			}
+			m.incrs = append(m.incrs, f)
+			m.incrSeq = f.seq
+		default:
+			return nil, fmt.Errorf("unknown AOF file type at line %d", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *aofManifest) clone() *aofManifest {
+	copied := *m
+	if m.base != nil {
+		base := *m.base
+		copied.base = &base
+	}
+	copied.incrs = slices.Clone(m.incrs)
+	copied.history = slices.Clone(m.history)
+	return &copied
+}
+
+// String formats the manifest the way parseAOFManifest reads it.
+func (m *aofManifest) String() string {
+	var b strings.Builder
+	files := []aofFile{}
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+	files = append(files, m.history...)
+	files = append(files, m.incrs...)
+	for _, f := range files {
+		fmt.Fprintf(&b, "file %s seq %d type %c\n", f.name, f.seq, f.kind)
+	}
+	return b.String()
+}
+
+// aofState is the append only file being written. Commands are appended to the
+// last incremental file of the manifest.
+type aofState struct {
+	mu       sync.Mutex
+	manifest *aofManifest
+	// file is nil until the append only file is loaded, so that replayed
+	// commands are not logged again
+	file *os.File
+	// buf holds the commands a failed write left behind, retried on the next
+	// write and every second
+	buf []byte
+	// held holds the commands run on shards already copied by the snapshot of a
+	// starting rewrite, they go to the incremental file opened once it is done
+	held    []byte
+	holding bool
+	// fileSize is the size of the incremental file being written, size the
+	// one of all the files and baseSize the one they had after the last
+	// rewrite or on startup, from which automatic rewrites measure the growth
+	fileSize int64
+	size     int64
+	baseSize int64
+	// unsynced is set once writes were made since the last fsync
+	unsynced bool
+	writeErr error
+	fsyncErr error
+
+	rewriteRunning  bool
+	rewriteStart    time.Time
+	lastRewriteOK   bool
+	rewrites        int64
+	rewriteFailures int64
+	// lastRewriteDuration is -1 until a rewrite completes
+	lastRewriteDuration time.Duration
+}
+
+func newAOFState() *aofState {
+	return &aofState{lastRewriteOK: true, lastRewriteDuration: -1}
+}
+
+func (s *server) aofDir() string {
+	return filepath.Join(s.Config.Dir, s.Config.AppendDirname)
+}
+
+func (s *server) aofPath(name string) string {
+	return filepath.Join(s.aofDir(), name)
+}
+
+func (s *server) aofManifestName() string {
+	return s.Config.AppendFilename + ".manifest"
+}
+
+// aofBaseName is the name of a base, an RDB file with aof-use-rdb-preamble and
+// commands otherwise.
+func (s *server) aofBaseName(seq int64) string {
+	if s.Config.AofUseRdbPreamble {
+		return fmt.Sprintf("%s.%d.base.rdb", s.Config.AppendFilename, seq)
+	}
+	return fmt.Sprintf("%s.%d.base.aof", s.Config.AppendFilename, seq)
+}
+
+func (s *server) aofIncrName(seq int64) string {
+	return fmt.Sprintf("%s.%d.incr.aof", s.Config.AppendFilename, seq)
+}
+
+// loadData fills the store from disk on startup. The append only file, when
+// enabled, is authoritative. Without one yet, the RDB file is loaded and
+// becomes the base of a new append only file.
+func (s *server) loadData() error {
+	if s.Config.AppendOnly {
+		if strings.ContainsAny(s.Config.AppendFilename, "/\\ \t") {
+			return fmt.Errorf("appendfilename %q must be a file name without spaces", s.Config.AppendFilename)
+		}
+		loaded, err := s.loadAppendOnlyFile()
+		if err != nil {
+			return err
+		}
+		if !loaded {
+			if err := s.loadRDB(); err != nil {
+				return err
+			}
+		}
+		// the writes replayed are already on disk
+		s.rdb.dirty.Store(0)
+		if err := s.openAppendOnlyFile(); err != nil {
+			return err
+		}
+		// files replaced by a rewrite interrupted before removing them
+		s.deleteAOFHistory()
+		return nil
+	}
+	// a rewrite with the append only file off replaces the existing one
+	m, err := s.readAOFManifest()
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+	s.aof.manifest = m
+	return s.loadRDB()
+}
+
+func (s *server) loadRDB() error {
+	if s.Config.DbFilename == "" || s.Config.Dir == "" {
+		return nil
+	}
+	fullPath := s.rdbFilename()
+	if !fileExists(fullPath) {
+		return nil
+	}
+	items, err := ReadRedisDBFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("cannot parse dump file %v", err)
+	}
+	s.InMemoryStore.Load(items)
+	return nil
+}
+
+// readAOFManifest reads the manifest of the append only file, nil when there is
+// none.
+func (s *server) readAOFManifest() (*aofManifest, error) {
+	data, err := os.ReadFile(s.aofPath(s.aofManifestName()))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read AOF manifest: %v", err)
+	}
+	m, err := parseAOFManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse AOF manifest %s: %v", s.aofManifestName(), err)
+	}
+	return m, nil
+}
+
+// loadAppendOnlyFile replays the files of the manifest, it reports false when
+// there is no append only file yet.
+func (s *server) loadAppendOnlyFile() (bool, error) {
+	m, err := s.readAOFManifest()
+	if m == nil || err != nil {
+		return false, err
+	}
+	files := []aofFile{}
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+	files = append(files, m.incrs...)
+
+	start := time.Now()
+	// the logged commands ran on keys that were alive at the time, deadlines
+	// elapsed since apply once everything is replayed. Keys and fields that
+	// expired while the file was written were logged as DEL and HDEL.
+	s.InMemoryStore.expiryPaused.Store(true)
+	defer s.InMemoryStore.expiryPaused.Store(false)
+	a := s.aof
+	for i, f := range files {
+		size, err := s.loadAOFFile(f, i == len(files)-1)
+		if err != nil {
+			return false, err
+		}
+		a.size += size
+	}
+	a.baseSize = a.size
+	a.manifest = m
+	log.Printf("DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
+	return true, nil
+}
+
+// loadAOFFile loads a file of the append only file and returns its size. A
+// file may start with an RDB preamble, followed by commands. Only the last
+// file may end with a truncated command, which is cut off when
+// aof-load-truncated is set.
+func (s *server) loadAOFFile(f aofFile, last bool) (int64, error) {
+	path := s.aofPath(f.name)
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not open append only file: %v", err)
+	}
+	defer file.Close()
+
+	// the RDB reader and the commands share the buffer, so the commands start
+	// right where the preamble ends
+	br := bufio.NewReaderSize(file, readerBufferSize)
+	var preamble int64
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		items, err := newRDBReader(br).load()
+		if err != nil {
+			return 0, fmt.Errorf("could not load RDB preamble of append only file %s: %w", f.name, err)
+		}
+		s.InMemoryStore.Load(items)
+		pos, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		preamble = pos - int64(br.Buffered())
+	}
+
+	valid, err := s.replayAOF(br)
+	valid += preamble
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		if !last || !s.Config.AofLoadTruncated {
+			return 0, fmt.Errorf("unexpected end of file reading the append only file %s", f.name)
+		}
+		log.Printf("warning: short read while loading the append only file %s, truncating it to %d bytes", f.name, valid)
+		if err := os.Truncate(path, valid); err != nil {
+			return 0, fmt.Errorf("could not truncate append only file %s: %v", f.name, err)
+		}
+		log.Printf("AOF %s loaded anyway because aof-load-truncated is enabled", f.name)
+	case err != nil:
+		return 0, fmt.Errorf("bad file format reading the append only file %s: %v", f.name, err)
+	}
+	return valid, nil
+}
+
+// replayAOF runs the commands of an append only file and returns the length of
+// the commands read in full.
+func (s *server) replayAOF(r io.Reader) (int64, error) {
+	rr := NewRequestReader(r)
+	var valid int64
+	for {
+		first, err := rr.r.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		if first[0] != '*' {
+			return valid, fmt.Errorf("expected '*', got '%c'", first[0])
+		}
+		req, err := rr.ReadRequest()
+		if err != nil {
+			return valid, err
+		}
+		if _, err := lookupCommand(req); err != nil {
+			return valid, fmt.Errorf("unknown command '%s'", req.Command)
+		}
+		// commands are logged once they succeeded, replaying them has the same
+		// effect and errors can't happen
+		s.parseResponses(req)
+		valid += int64(req.nBytes)
+	}
+}
+
+// openAppendOnlyFile prepares the append only file for the writes to come.
+// Without a manifest yet, the keyspace loaded so far is dumped to the base of
+// a new one.
+func (s *server) openAppendOnlyFile() error {
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return fmt.Errorf("could not create append only directory: %v", err)
+	}
+	a := s.aof
+	m := a.manifest
+	if m == nil {
+		m = &aofManifest{}
+		m.baseSeq++
+		name := s.aofBaseName(m.baseSeq)
+		size, err := s.writeAOFBase(name, s.InMemoryStore.snapshot())
+		if err != nil {
+			return err
+		}
+		m.base = &aofFile{name: name, seq: m.baseSeq, kind: aofBaseFile}
+		a.size, a.baseSize = size, size
+	}
+	if len(m.incrs) == 0 {
+		m.incrSeq++
+		m.incrs = append(m.incrs, aofFile{name: s.aofIncrName(m.incrSeq), seq: m.incrSeq, kind: aofIncrFile})
+	}
+	incr := m.incrs[len(m.incrs)-1]
+	file, err := os.OpenFile(s.aofPath(incr.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open append only file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := s.writeAOFManifest(m); err != nil {
+		file.Close()
+		return err
+	}
+	a.mu.Lock()
+	a.manifest, a.file, a.fileSize = m, file, info.Size()
+	a.mu.Unlock()
+	return nil
+}
+
+// writeAOFBase dumps items to the base file name and returns its size. Bases
+// named .rdb are RDB files, the others commands.
+func (s *server) writeAOFBase(name string, items map[string]*Resource) (int64, error) {
+	path := s.aofPath(name)
+	err := replaceFile(path, "AOF base", func(w io.Writer) error {
+		if strings.HasSuffix(name, ".rdb") {
+			return writeRDB(w, items, true)
+		}
+		return writeAOFCommands(w, items)
+	})
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *server) writeAOFManifest(m *aofManifest) error {
+	return replaceFile(s.aofPath(s.aofManifestName()), "AOF manifest", func(w io.Writer) error {
+		_, err := io.WriteString(w, m.String())
+		return err
+	})
+}
+
+// feedAOF appends the write command of req to the append only file. It runs
+// with the keys of the command locked, so the writes to a key are logged in
+// the order they were applied.
+func (s *server) feedAOF(req *Request, keys []string, replies []resp.Value) {
+	if !s.Config.AppendOnly {
+		return
+	}
+	argv := propagatedCommand(req, replies, time.Now())
+	if argv == nil {
+		return
+	}
+	s.appendAOF(keys, argv)
+}
+
+// feedAOFReclaimed logs the deletion of an expired key as a DEL, or of expired
+// fields of a hash as an HDEL. Replaying the file pauses expiry, so without
+// them the values would still be alive for the commands logged afterwards.
+func (s *server) feedAOFReclaimed(key string, fields []string) {
+	if !s.Config.AppendOnly {
+		return
+	}
+	if fields == nil {
+		s.appendAOF([]string{key}, []string{"DEL", key})
+		return
+	}
+	s.appendAOF([]string{key}, append([]string{"HDEL", key}, fields...))
+}
+
+// appendAOF appends a command on keys, which are locked, to the append only
+// file.
+func (s *server) appendAOF(keys []string, argv []string) {
+	a := s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		// replaying the file
+		return
+	}
+	if a.holding && s.InMemoryStore.copied(keys) {
+		a.held = append(a.held, resp.Encode(resp.BulkStrings(argv))...)
+		return
+	}
+	a.buf = append(a.buf, resp.Encode(resp.BulkStrings(argv))...)
+	a.flushLocked(s.Config.AppendFsync == AppendFsyncAlways)
+}
+
+// flushLocked writes the buffered commands, then syncs the file if sync is set.
+// Must be called with mu held.
+func (a *aofState) flushLocked(sync bool) {
+	if len(a.buf) > 0 {
+		n, err := a.file.Write(a.buf)
+		if err != nil {
+			// cut the partial command off so the file ends on a complete one,
+			// the whole buffer is written again later
+			if n > 0 {
+				if terr := a.file.Truncate(a.fileSize); terr != nil {
+					a.fileSize += int64(n)
+					a.size += int64(n)
+					a.buf = a.buf[n:]
+				}
+			}
+			if a.writeErr == nil {
+				log.Printf("error writing to the append only file: %v", err)
+			}
+			a.writeErr = err
+			return
+		}
+		a.fileSize += int64(n)
+		a.size += int64(n)
+		a.buf = a.buf[:0]
+		a.unsynced = true
+		if a.writeErr != nil {
+			log.Printf("append only file write error resolved")
+			a.writeErr = nil
+		}
+	}
+	if sync && a.unsynced {
+		a.syncLocked()
+	}
+}
+
+// syncLocked flushes the file to disk. Must be called with mu held.
+func (a *aofState) syncLocked() {
+	if err := a.file.Sync(); err != nil {
+		if a.fsyncErr == nil {
+			log.Printf("error syncing the append only file: %v", err)
+		}
+		a.fsyncErr = err
+		return
+	}
+	a.unsynced = false
+	a.fsyncErr = nil
+}
+
+// aofError returns the error of the last failed write or fsync of the append
+// only file, nil once a later one succeeded.
+func (s *server) aofError() error {
+	a := s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.writeErr != nil {
+		return a.writeErr
+	}
+	return a.fsyncErr
+}
+
+// aofLoop writes again the commands a failed write left behind and, with
+// appendfsync everysec, flushes the file to disk every second. It also starts
+// the automatic rewrites.
+func (s *server) aofLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		a := s.aof
+		a.mu.Lock()
+		a.flushLocked(s.Config.AppendFsync == AppendFsyncEverysec)
+		a.mu.Unlock()
+		s.checkAOFRewrite(now)
+	}
+}
+
+// propagatedCommand returns the command logged for req given its replies, nil
+// when there is nothing to log. Commands whose effect depends on when or how
+// they run are logged as commands having the effect they had: relative
+// expirations become absolute, random pops name the members popped, generated
+// stream IDs are spelled out and blocking pops become the pop they made.
+func propagatedCommand(req *Request, replies []resp.Value, now time.Time) []string {
+	argv := append([]string{string(req.Command)}, req.Args...)
+	var result resp.Value
+	if len(replies) > 0 {
+		result = replies[0]
+	}
+	switch strings.ToUpper(argv[0]) {
+	case "EXPIRE", "PEXPIRE":
+		argv[2] = absoluteDeadline(argv[0], argv[2], now)
+		argv[0] = "PEXPIREAT"
+	case "HEXPIRE", "HPEXPIRE":
+		argv[2] = absoluteDeadline(argv[0][1:], argv[2], now)
+		argv[0] = "HPEXPIREAT"
+	case "SETEX", "PSETEX":
+		deadline := absoluteDeadline(argv[0][:len(argv[0])-2], argv[2], now)
+		return []string{"SET", argv[1], argv[3], "PXAT", deadline}
+	case "SET", "GETEX":
+		first := 3
+		if strings.EqualFold(argv[0], "GETEX") {
+			first = 2
+		}
+		for i := first; i < len(argv)-1; i++ {
+			switch strings.ToUpper(argv[i]) {
+			case "EX", "PX":
+				argv[i+1] = absoluteDeadline(argv[i], argv[i+1], now)
+				argv[i] = "PXAT"
+				i++
+			case "EXAT", "PXAT":
+				i++
+			}
+		}
+	case "SPOP":
+		var members []string
+		switch {
+		case result.Null:
+		case result.Type == resp.TypeBulkString:
+			members = []string{result.Str}
+		case result.Type == resp.TypeArray:
+			for _, v := range result.Array {
+				members = append(members, v.Str)
+			}
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		return append([]string{"SREM", argv[1]}, members...)
+	case "XADD":
+		if result.Type != resp.TypeBulkString || result.Null {
+			return nil
+		}
+		argv[xaddIDIndex(argv)] = result.Str
+	case "BLPOP", "BRPOP", "BZPOPMIN", "BZPOPMAX":
+		if result.Type != resp.TypeArray || result.Null {
+			return nil
+		}
+		return []string{strings.ToUpper(argv[0][1:]), result.Array[0].Str}
+	case "BLMOVE", "BRPOPLPUSH":
+		if result.Type != resp.TypeBulkString || result.Null {
+			return nil
+		}
+		if len(argv) == 6 {
+			return append([]string{"LMOVE"}, argv[1:5]...)
+		}
+		return []string{"RPOPLPUSH", argv[1], argv[2]}
+	case "BLMPOP":
+		if result.Type != resp.TypeArray || result.Null {
+			return nil
+		}
+		numkeys, _ := strconv.Atoi(argv[2])
+		key, popped := result.Array[0].Str, len(result.Array[1].Array)
+		return []string{"LMPOP", "1", key, argv[3+numkeys], "COUNT", strconv.Itoa(popped)}
+	case "XREADGROUP":
+		// replaying never blocks
+		argv = withoutBlock(argv)
+		if req.block != nil && result.Type == resp.TypeArray && !result.Null {
+			// served once blocked, by the only stream named in the reply
+			return servedReadGroup(argv, result.Array[0].Array[0].Str)
+		}
+	case "XCLAIM":
+		return propagatedClaim(argv, claimedIDs(result), now)
+	case "XAUTOCLAIM":
+		if result.Type != resp.TypeArray || len(result.Array) < 3 {
+			return nil
+		}
+		// claiming the deleted entries again acks them
+		ids := append(claimedIDs(result.Array[1]), claimedIDs(result.Array[2])...)
+		justID := len(argv) > 6 && slices.ContainsFunc(argv[6:], func(arg string) bool {
+			return strings.EqualFold(arg, "JUSTID")
+		})
+		claim := []string{"XCLAIM", argv[1], argv[2], argv[3], "0", "TIME", strconv.FormatInt(now.UnixMilli(), 10)}
+		if justID {
+			claim = append(claim, "JUSTID")
+		}
+		return propagatedClaim(claim, ids, now)
+	}
+	return argv
+}
+
+// propagatedClaim turns a valid XCLAIM, or the XCLAIM an XAUTOCLAIM converts
+// to, into one claiming ids no matter how long they were idle, which depends on
+// when it runs. Claims that don't change the delivery count keep JUSTID, the
+// delivery time is made absolute and ids that are no longer pending are forced.
+func propagatedClaim(argv []string, ids []string, now time.Time) []string {
+	// the options follow the IDs, which follow the min idle time
+	i := 5
+	for i < len(argv) {
+		if _, err := parseStreamID(argv[i], 0); err != nil {
+			break
+		}
+		i++
+	}
+	out := append(append(slices.Clone(argv[:4]), "0"), ids...)
+	deliveryTime := now.UnixMilli()
+	lastID := false
+	for ; i < len(argv); i++ {
+		switch strings.ToUpper(argv[i]) {
+		case "IDLE":
+			n, _ := strconv.ParseInt(argv[i+1], 10, 64)
+			deliveryTime = now.UnixMilli() - n
+			i++
+		case "TIME":
+			n, _ := strconv.ParseInt(argv[i+1], 10, 64)
+			deliveryTime = min(n, now.UnixMilli())
+			i++
+		case "RETRYCOUNT", "LASTID":
+			lastID = lastID || strings.EqualFold(argv[i], "LASTID")
+			out = append(out, argv[i:i+2]...)
+			i++
+		case "JUSTID":
+			out = append(out, "JUSTID")
+		}
+	}
+	if len(ids) == 0 && !lastID {
+		return nil
+	}
+	return append(out, "TIME", strconv.FormatInt(deliveryTime, 10), "FORCE")
+}
+
+// claimedIDs returns the IDs of the entries in a claim reply, given in full or
+// with JUSTID.
+func claimedIDs(v resp.Value) []string {
+	var ids []string
+	for _, e := range v.Array {
+		if e.Type == resp.TypeArray {
+			ids = append(ids, e.Array[0].Str)
+		} else {
+			ids = append(ids, e.Str)
+		}
+	}
+	return ids
+}
+
+// withoutBlock drops the BLOCK option of a valid XREADGROUP.
+func withoutBlock(argv []string) []string {
+	// the group and consumer names follow GROUP and may look like options
+	for i := 4; i < len(argv)-1 && !strings.EqualFold(argv[i], "STREAMS"); i++ {
+		if strings.EqualFold(argv[i], "BLOCK") {
+			return append(slices.Clone(argv[:i]), argv[i+2:]...)
+		}
+	}
+	return argv
+}
+
+// absoluteDeadline converts the relative expire arg of the command or option
+// name, in seconds unless name starts with P, to unix milliseconds. The
+// argument was validated by the command.
+func absoluteDeadline(name, arg string, now time.Time) string {
+	n, _ := strconv.ParseInt(arg, 10, 64)
+	if !strings.HasPrefix(strings.ToUpper(name), "P") {
+		n *= 1000
+	}
+	return strconv.FormatInt(now.UnixMilli()+n, 10)
+}
+
+// xaddIDIndex returns the index of the ID argument of a valid XADD command.
+func xaddIDIndex(argv []string) int {
+	i := 2
+	for i < len(argv) {
+		switch strings.ToUpper(argv[i]) {
+		case "NOMKSTREAM":
+			i++
+		case "MAXLEN", "MINID":
+			i++
+			if argv[i] == "=" || argv[i] == "~" {
+				i++
+			}
+			i++
+		case "LIMIT":
+			i += 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// servedReadGroup narrows a blocked XREADGROUP down to the stream it was served
+// from.
+func servedReadGroup(argv []string, key string) []string {
+	// the group and consumer names follow GROUP and may look like options
+	i := 4
+	for i < len(argv) && !strings.EqualFold(argv[i], "STREAMS") {
+		i++
+	}
+	out := append([]string{}, argv[:i+1]...)
+	streams := argv[i+1:]
+	n := len(streams) / 2
+	for j := 0; j < n; j++ {
+		if streams[j] == key {
+			return append(out, key, streams[n+j])
+		}
+	}
+	return argv
+}
+
+func (s *server) aofInfo() []string {
+	a := s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := "ok"
+	if a.writeErr != nil || a.fsyncErr != nil {
+		status = "err"
+	}
+	rewriteStatus := "ok"
+	if !a.lastRewriteOK {
+		rewriteStatus = "err"
+	}
+	enabled, inProgress, current := 0, 0, int64(-1)
+	if s.Config.AppendOnly {
+		enabled = 1
+	}
+	if a.rewriteRunning {
+		inProgress, current = 1, int64(time.Since(a.rewriteStart).Seconds())
+	}
+	last := int64(-1)
+	if a.lastRewriteDuration >= 0 {
+		last = int64(a.lastRewriteDuration.Seconds())
+	}
+	lines := []string{
+		fmt.Sprintf("aof_enabled:%d", enabled),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", inProgress),
+		fmt.Sprintf("aof_last_rewrite_time_sec:%d", last),
+		fmt.Sprintf("aof_current_rewrite_time_sec:%d", current),
+		"aof_last_bgrewrite_status:" + rewriteStatus,
+		fmt.Sprintf("aof_rewrites:%d", a.rewrites),
+		fmt.Sprintf("aof_rewrites_consecutive_failures:%d", a.rewriteFailures),
+		"aof_last_write_status:" + status,
+	}
+	if s.Config.AppendOnly {
+		lines = append(lines,
+			fmt.Sprintf("aof_current_size:%d", a.size),
+			fmt.Sprintf("aof_base_size:%d", a.baseSize),
+			fmt.Sprintf("aof_buffer_length:%d", len(a.buf)),
+		)
+	}
+	return lines
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// TestAOFLogsExpiredKeys checks that keys and hash fields reclaimed because
+// their ttl elapsed stay deleted when the append only file is replayed, as the
+// commands logged after them build on the deletion.
+func TestAOFLogsExpiredKeys(t *testing.T) {
+	config := testConfig(t)
+	config.AppendOnly = true
+	config.AppendFsync = AppendFsyncAlways
+	s := startTestServer(t, config)
+
+	mustDo(t, s, "SET", "lazy", "5", "PX", "50")
+	mustDo(t, s, "SET", "active", "5", "PX", "50")
+	mustDo(t, s, "HSET", "h", "a", "5", "b", "1")
+	mustDo(t, s, "HPEXPIRE", "h", "50", "FIELDS", "1", "a")
+	time.Sleep(100 * time.Millisecond)
+
+	// active expiry deletes the key first, lazy expiry when it is accessed
+	s.InMemoryStore.activeExpireCycle(time.Second)
+	for _, key := range []string{"lazy", "active"} {
+		if got := mustDo(t, s, "INCR", key); got.Int != 1 {
+			t.Errorf("INCR %s = %d, want 1", key, got.Int)
+		}
+	}
+	if got := mustDo(t, s, "HINCRBY", "h", "a", "1"); got.Int != 1 {
+		t.Errorf("HINCRBY h a = %d, want 1", got.Int)
+	}
+
+	s = startTestServer(t, config)
+	for _, key := range []string{"lazy", "active"} {
+		if got := mustDo(t, s, "GET", key); got.Str != "1" {
+			t.Errorf("GET %s after restart = %q, want 1", key, got.Str)
+		}
+		if got := mustDo(t, s, "TTL", key); got.Int != -1 {
+			t.Errorf("TTL %s after restart = %d, want -1", key, got.Int)
+		}
+	}
+	if got := mustDo(t, s, "HGET", "h", "a"); got.Str != "1" {
+		t.Errorf("HGET h a after restart = %q, want 1", got.Str)
+	}
+	if got := mustDo(t, s, "HTTL", "h", "FIELDS", "1", "a"); got.Array[0].Int != -1 {
+		t.Errorf("HTTL h a after restart = %d, want -1", got.Array[0].Int)
+	}
+}
+
+// TestAOFStreamClaims checks that the commands reading from consumer groups
+// replay the same way, however long after they ran.
+func TestAOFStreamClaims(t *testing.T) {
+	config := testConfig(t)
+	config.AppendOnly = true
+	config.AppendFsync = AppendFsyncAlways
+	s := startTestServer(t, config)
+
+	mustDo(t, s, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	mustDo(t, s, "XGROUP", "CREATE", "idle", "g", "$", "MKSTREAM")
+	c := newClient(nil)
+	served := make(chan resp.Value)
+	go func() {
+		replies, err := s.parseResponses(&Request{
+			Command: "XREADGROUP",
+			Args:    []string{"GROUP", "g", "c1", "BLOCK", "0", "STREAMS", "idle", "s", ">", ">"},
+			client:  c,
+		})
+		if err != nil {
+			t.Error(err)
+			replies = []resp.Value{resp.NullArray()}
+		}
+		served <- replies[0]
+	}()
+	// the entry is added once the reader is blocked
+	time.Sleep(50 * time.Millisecond)
+	id := mustDo(t, s, "XADD", "s", "*", "f", "v").Str
+	if got := <-served; got.Null {
+		t.Fatal("blocked XREADGROUP not served")
+	}
+
+	// c1 read the entry long enough ago for c2 to claim it, which is no longer
+	// true when the file is replayed
+	time.Sleep(100 * time.Millisecond)
+	if got := mustDo(t, s, "XCLAIM", "s", "g", "c2", "50", id, "JUSTID"); len(got.Array) != 1 {
+		t.Fatalf("XCLAIM claimed %v", got.Array)
+	}
+	mustDo(t, s, "XADD", "s", "*", "f", "v")
+	mustDo(t, s, "XREADGROUP", "GROUP", "g", "c1", "STREAMS", "s", ">")
+	time.Sleep(100 * time.Millisecond)
+	if got := mustDo(t, s, "XAUTOCLAIM", "s", "g", "c3", "50", "0"); len(got.Array[1].Array) != 2 {
+		t.Fatalf("XAUTOCLAIM claimed %v", got.Array[1].Array)
+	}
+	want := mustDo(t, s, "XPENDING", "s", "g", "-", "+", "10")
+
+	incr := s.aof.manifest.incrs[len(s.aof.manifest.incrs)-1]
+	logged, err := os.ReadFile(s.aofPath(incr.name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the blocked read is logged once served, without BLOCK nor the idle key
+	if n := strings.Count(string(logged), "XREADGROUP"); n != 2 {
+		t.Errorf("XREADGROUP logged %d times, want 2", n)
+	}
+	if strings.Contains(string(logged), "BLOCK") || strings.Count(string(logged), "\r\nidle\r\n") != 1 {
+		t.Errorf("blocked XREADGROUP logged as it was called:\n%s", logged)
+	}
+
+	s = startTestServer(t, config)
+	got := mustDo(t, s, "XPENDING", "s", "g", "-", "+", "10")
+	if len(got.Array) != len(want.Array) {
+		t.Fatalf("XPENDING after restart = %v, want %v", got.Array, want.Array)
+	}
+	for i, pe := range got.Array {
+		// the idle time is the only field that may differ
+		owner, count := pe.Array[1].Str, pe.Array[3].Int
+		if owner != want.Array[i].Array[1].Str || count != want.Array[i].Array[3].Int {
+			t.Errorf("pending entry %d after restart: %s delivered %d times, want %s %d times",
+				i, owner, count, want.Array[i].Array[1].Str, want.Array[i].Array[3].Int)
+		}
+	}
+}
+
+// TestAOFRewriteUnderLoad rewrites the append only file while clients write,
+// the keys copied to the new base and the writes logged after them must add
+// up to the same keyspace once replayed.
+func TestAOFRewriteUnderLoad(t *testing.T) {
+	const clients = 4
+	config := testConfig(t)
+	config.AppendOnly = true
+	config.AppendFsync = AppendFsyncNo
+	s := startTestServer(t, config)
+	// keys to copy, so that writes run while the shards are copied
+	for i := 0; i < 50000; i++ {
+		mustDo(t, s, "SET", "filler:"+strconv.Itoa(i), "v")
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for c := 0; c <= clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				value := strconv.Itoa(i)
+				if c == clients {
+					// writes to several shards wait for the copy to complete
+					mustDo(t, s, "MSET", "m:a", value, "m:b", value, "m:c", value)
+					continue
+				}
+				mustDo(t, s, "INCR", "counter:"+strconv.Itoa(i%50))
+				mustDo(t, s, "RPUSH", "list:"+strconv.Itoa(c), value)
+			}
+		}(c)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.startAOFRewrite(); err != nil {
+			t.Fatal(err)
+		}
+		for running := true; running; {
+			time.Sleep(time.Millisecond)
+			s.aof.mu.Lock()
+			running = s.aof.rewriteRunning
+			s.aof.mu.Unlock()
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	keys := []string{"m:a", "m:b", "m:c"}
+	for i := 0; i < 50; i++ {
+		keys = append(keys, "counter:"+strconv.Itoa(i))
+	}
+	want := mustDo(t, s, append([]string{"MGET"}, keys...)...)
+	lists := make([]int64, clients)
+	for c := range lists {
+		lists[c] = mustDo(t, s, "LLEN", "list:"+strconv.Itoa(c)).Int
+	}
+
+	s = startTestServer(t, config)
+	got := mustDo(t, s, append([]string{"MGET"}, keys...)...)
+	for i, key := range keys {
+		if got.Array[i].Str != want.Array[i].Str {
+			t.Errorf("%s after restart = %q, want %q", key, got.Array[i].Str, want.Array[i].Str)
+		}
+	}
+	for c, want := range lists {
+		if got := mustDo(t, s, "LLEN", "list:"+strconv.Itoa(c)); got.Int != want {
+			t.Errorf("LLEN list:%d after restart = %d, want %d", c, got.Int, want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+const (
+	// aofRewriteItemsPerCmd is how many elements the commands of a rewritten
+	// append only file add at once, like in redis
+	aofRewriteItemsPerCmd = 64
+	// aofRewriteRetryDelay is how long automatic rewrites wait after a failed one
+	aofRewriteRetryDelay = time.Minute
+)
+
+var errRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:    "BGREWRITEAOF",
+			group:   "server",
+			summary: "Asynchronously rewrites the append-only file to disk.",
+			arity:   1,
+			flags:   flagAdmin | flagNoScript,
+			handler: (*server).handleBgrewriteaof,
+		},
+	)
+}
+
+func (s *server) handleBgrewriteaof(req *Request) ([]resp.Value, error) {
+	if err := s.startAOFRewrite(); err != nil {
+		if errors.Is(err, errRewriteInProgress) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("ERR Can't rewrite append only file in background: %v", err)
+	}
+	return reply(resp.SimpleString("Background append only file rewriting started"))
+}
+
+// startAOFRewrite compacts the append only file in the background: the
+// keyspace is dumped to a new base, which replaces the base and the
+// incremental files written so far. Writes made meanwhile go to a new
+// incremental file, opened once the keyspace is copied. The writes to shards
+// already copied are held until then, the copy doesn't have them.
+func (s *server) startAOFRewrite() error {
+	a := s.aof
+	a.mu.Lock()
+	if a.rewriteRunning {
+		a.mu.Unlock()
+		return errRewriteInProgress
+	}
+	a.rewriteRunning = true
+	a.rewriteStart = time.Now()
+	a.mu.Unlock()
+
+	kept, err := 0, error(nil)
+	a.mu.Lock()
+	a.holding = true
+	a.mu.Unlock()
+	items := s.InMemoryStore.snapshotWith(func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		kept, err = s.openAOFIncrLocked()
+		// the commands held follow the copy, they go to the new incremental
+		// file unless it couldn't be opened
+		a.holding = false
+		a.buf = append(a.buf, a.held...)
+		a.held = nil
+		if a.file != nil {
+			a.flushLocked(s.Config.AppendFsync == AppendFsyncAlways)
+		}
+	})
+	if err != nil {
+		log.Printf("background append only file rewrite failed: %v", err)
+		s.finishAOFRewrite(false)
+		return err
+	}
+	go func() {
+		err := s.rewriteAOF(items, kept)
+		if err != nil {
+			log.Printf("background append only file rewrite failed: %v", err)
+		} else {
+			log.Printf("background append only file rewrite finished successfully")
+		}
+		if err == nil {
+			s.deleteAOFHistory()
+		}
+		s.finishAOFRewrite(err == nil)
+	}()
+	return nil
+}
+
+func (s *server) finishAOFRewrite(ok bool) {
+	a := s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriteRunning = false
+	a.lastRewriteOK = ok
+	a.lastRewriteDuration = time.Since(a.rewriteStart)
+	if ok {
+		a.rewrites++
+		a.rewriteFailures = 0
+	} else {
+		a.rewriteFailures++
+	}
+}
+
+// openAOFIncrLocked switches the writes to a new incremental file at the start
+// of a rewrite, and returns the index in the manifest of the first incremental
+// file the new base won't hold. Without an append only file being written,
+// the new base will hold everything. Must be called with mu held.
+func (s *server) openAOFIncrLocked() (int, error) {
+	a := s.aof
+	if a.file == nil {
+		if a.manifest == nil {
+			return 0, nil
+		}
+		return len(a.manifest.incrs), nil
+	}
+	a.flushLocked(true)
+	if len(a.buf) > 0 || a.fsyncErr != nil {
+		// the commands left in the buffer precede the copy of the keyspace, they
+		// can't go to the new incremental file
+		return 0, fmt.Errorf("append only file not written: %v", errors.Join(a.writeErr, a.fsyncErr))
+	}
+	m := a.manifest.clone()
+	m.incrSeq++
+	incr := aofFile{name: s.aofIncrName(m.incrSeq), seq: m.incrSeq, kind: aofIncrFile}
+	m.incrs = append(m.incrs, incr)
+	file, err := os.OpenFile(s.aofPath(incr.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("could not open append only file: %v", err)
+	}
+	if err := s.writeAOFManifest(m); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return 0, err
+	}
+	a.file.Close()
+	a.manifest, a.file, a.fileSize = m, file, 0
+	return len(m.incrs) - 1, nil
+}
+
+// rewriteAOF writes items to a new base, then makes it the base of the
+// manifest in place of the previous base and of the incremental files before
+// kept, which become history.
+func (s *server) rewriteAOF(items map[string]*Resource, kept int) error {
+	a := s.aof
+	a.mu.Lock()
+	seq := int64(1)
+	if a.manifest != nil {
+		seq = a.manifest.baseSeq + 1
+	}
+	a.mu.Unlock()
+
+	name := s.aofBaseName(seq)
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return fmt.Errorf("could not create append only directory: %v", err)
+	}
+	size, err := s.writeAOFBase(name, items)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := &aofManifest{}
+	if a.manifest != nil {
+		m = a.manifest.clone()
+	}
+	if m.base != nil {
+		m.history = append(m.history, aofFile{name: m.base.name, seq: m.base.seq, kind: aofHistoryFile})
+	}
+	for _, f := range m.incrs[:kept] {
+		m.history = append(m.history, aofFile{name: f.name, seq: f.seq, kind: aofHistoryFile})
+	}
+	m.incrs = m.incrs[kept:]
+	m.base = &aofFile{name: name, seq: seq, kind: aofBaseFile}
+	m.baseSeq = seq
+	if err := s.writeAOFManifest(m); err != nil {
+		os.Remove(s.aofPath(name))
+		return err
+	}
+	a.manifest = m
+	if a.file != nil {
+		a.size = size + a.fileSize
+		a.baseSize = a.size
+	}
+	return nil
+}
+
+// deleteAOFHistory removes the files replaced by a rewrite, then drops them from
+// the manifest.
+func (s *server) deleteAOFHistory() {
+	a := s.aof
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.manifest == nil || len(a.manifest.history) == 0 {
+		return
+	}
+	for _, f := range a.manifest.history {
+		if err := os.Remove(s.aofPath(f.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("warning: could not remove append only file %s: %v", f.name, err)
+		}
+	}
+	m := a.manifest.clone()
+	m.history = nil
+	if err := s.writeAOFManifest(m); err != nil {
+		log.Printf("warning: could not update AOF manifest: %v", err)
+		return
+	}
+	a.manifest = m
+}
+
+// checkAOFRewrite starts a rewrite once the append only file grew by
+// auto-aof-rewrite-percentage percent since the last one, and is at least
+// auto-aof-rewrite-min-size bytes. After a failed rewrite, the next attempt
+// waits for aofRewriteRetryDelay.
+func (s *server) checkAOFRewrite(now time.Time) {
+	if s.Config.AutoAofRewritePercentage <= 0 {
+		return
+	}
+	a := s.aof
+	a.mu.Lock()
+	running, failed := a.rewriteRunning, a.rewriteFailures > 0
+	start, size, base := a.rewriteStart, a.size, max(a.baseSize, 1)
+	a.mu.Unlock()
+	if running || (failed && now.Sub(start) <= aofRewriteRetryDelay) || size < s.Config.AutoAofRewriteMinSize {
+		return
+	}
+	growth := (size*100)/base - 100
+	if growth >= int64(s.Config.AutoAofRewritePercentage) {
+		log.Printf("starting automatic rewriting of AOF on %d%% growth", growth)
+		if err := s.startAOFRewrite(); err != nil && !errors.Is(err, errRewriteInProgress) {
+			log.Printf("automatic append only file rewrite failed: %v", err)
+		}
+	}
+}
+
+// writeAOFCommands writes items as the commands creating them, the base of an
+// append only file without an RDB preamble.
+func writeAOFCommands(w io.Writer, items map[string]*Resource) error {
+	rw := resp.NewWriter(w)
+	// errors of the buffered writer stick, Flush reports them
+	emit := func(argv ...string) {
+		rw.WriteValue(resp.BulkStrings(argv))
+	}
+	for key, res := range items {
+		if !rewriteObject(emit, key, res.value) {
+			continue
+		}
+		if res.expired != nil {
+			emit("PEXPIREAT", key, strconv.FormatInt(res.expired.UnixMilli(), 10))
+		}
+	}
+	return rw.Flush()
+}
+
+// rewriteObject emits the commands creating the value of key, it reports false
+// when there is nothing to create.
+func rewriteObject(emit func(argv ...string), key string, value interface{}) bool {
+	// batch emits the command adding items, aofRewriteItemsPerCmd at a time,
+	// each item being width arguments
+	batch := func(command string, items []string, width int) {
+		step := aofRewriteItemsPerCmd * width
+		for i := 0; i < len(items); i += step {
+			chunk := items[i:min(i+step, len(items))]
+			emit(append([]string{command, key}, chunk...)...)
+		}
+	}
+	switch v := value.(type) {
+	case []byte:
+		emit("SET", key, string(v))
+	case int64:
+		emit("SET", key, strconv.FormatInt(v, 10))
+	case *deque:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = v.At(i)
+		}
+		batch("RPUSH", items, 1)
+	case *set:
+		batch("SADD", v.Members(), 1)
+	case *zset:
+		var items []string
+		for _, e := range v.RangeByRank(0, v.Len()-1, false) {
+			items = append(items, formatScore(e.score), e.member)
+		}
+		batch("ZADD", items, 2)
+	case *hash:
+		var items, volatile []string
+		v.forEach(func(field, value string) bool {
+			items = append(items, field, value)
+			if _, ok := v.Expiry(field); ok {
+				volatile = append(volatile, field)
+			}
+			return true
+		})
+		if len(items) == 0 {
+			// every field expired since the snapshot
+			return false
+		}
+		batch("HMSET", items, 2)
+		for _, field := range volatile {
+			deadline, _ := v.Expiry(field)
+			emit("HPEXPIREAT", key, strconv.FormatInt(deadline.UnixMilli(), 10), "FIELDS", "1", field)
+		}
+	case *stream:
+		rewriteStream(emit, key, v)
+	}
+	return true
+}
+
+// rewriteStream emits the entries of a stream, then its metadata and its
+// consumer groups along with their pending entries.
+func rewriteStream(emit func(argv ...string), key string, st *stream) {
+	entries := st.Range(streamID{}, maxStreamID, 0, false)
+	for _, e := range entries {
+		emit(append([]string{"XADD", key, e.id.String()}, e.fields...)...)
+	}
+	if len(entries) == 0 {
+		// an empty stream is created by adding an entry trimmed right away
+		id := st.lastID
+		if id.isZero() {
+			id = streamID{0, 1}
+		}
+		emit("XADD", key, "MAXLEN", "0", id.String(), "x", "y")
+	}
+	// the last ID may be past the last entry after deletions
+	emit("XSETID", key, st.lastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(st.entriesAdded, 10),
+		"MAXDELETEDID", st.maxDeletedID.String())
+
+	for _, g := range st.sortedGroups() {
+		emit("XGROUP", "CREATE", key, g.name, g.lastID.String(), "ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10))
+		for _, id := range sortedIDs(g.pending) {
+			pe := g.pending[id]
+			emit("XCLAIM", key, g.name, pe.consumer.name, "0", id.String(),
+				"TIME", strconv.FormatInt(pe.deliveryTime.UnixMilli(), 10),
+				"RETRYCOUNT", strconv.FormatInt(pe.deliveryCount, 10),
+				"JUSTID", "FORCE")
+		}
+		// consumers without pending entries are created explicitly
+		for _, c := range g.consumers {
+			if len(c.pending) == 0 {
+				emit("XGROUP", "CREATECONSUMER", key, g.name, c.name)
+			}
+		}
+	}
+}
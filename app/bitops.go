@@ -0,0 +1,642 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// maxBitOffset is the largest bit offset of a string, bounded by the maximum
+// string size
+const maxBitOffset = maxStringSize*8 - 1
+
+var (
+	errBitOffset       = errors.New("ERR bit offset is not an integer or out of range")
+	errBitValue        = errors.New("ERR bit is not an integer or out of range")
+	errBitPosBit       = errors.New("ERR The bit argument must be 1 or 0.")
+	errBitOpNot        = errors.New("ERR BITOP NOT must be called with a single source key.")
+	errBitfieldType    = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	errBitfieldRO      = errors.New("ERR BITFIELD_RO only supports the GET subcommand")
+	errBitfieldOverflw = errors.New("ERR Invalid OVERFLOW type specified")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "SETBIT",
+			group:    "bitmap",
+			summary:  "Sets or clears the bit at offset of the string value. Creates the key if it doesn't exist.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSetBit,
+		},
+		&commandSpec{
+			name:     "GETBIT",
+			group:    "bitmap",
+			summary:  "Returns a bit value by offset.",
+			arity:    3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGetBit,
+		},
+		&commandSpec{
+			name:     "BITCOUNT",
+			group:    "bitmap",
+			summary:  "Counts the number of set bits (population counting) in a string.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleBitCount,
+		},
+		&commandSpec{
+			name:     "BITPOS",
+			group:    "bitmap",
+			summary:  "Finds the first set (1) or clear (0) bit in a string.",
+			arity:    -3,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleBitPos,
+		},
+		&commandSpec{
+			name:     "BITOP",
+			group:    "bitmap",
+			summary:  "Performs bitwise operations on multiple strings, and stores the result.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 2, lastKey: -1, keyStep: 1,
+			handler: (*server).handleBitOp,
+		},
+		&commandSpec{
+			name:     "BITFIELD",
+			group:    "bitmap",
+			summary:  "Performs arbitrary bitfield integer operations on strings.",
+			arity:    -2,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleBitfield,
+		},
+		&commandSpec{
+			name:     "BITFIELD_RO",
+			group:    "bitmap",
+			summary:  "Performs arbitrary read-only bitfield integer operations on strings.",
+			arity:    -2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleBitfield,
+		},
+	)
+}
+
+// readBytes returns the bytes of a string resource without converting integer
+// encoded values in place, so that it can be used with the key read locked.
+// The result must not be modified.
+func readBytes(res *Resource) []byte {
+	switch v := res.value.(type) {
+	case []byte:
+		return v
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	default:
+		return nil
+	}
+}
+
+// bitmapForWrite returns the bytes of the string at key grown to hold at least
+// size bytes, creating the key when missing. Growing pads with zero bytes.
+func (s *server) bitmapForWrite(key string, size int64, now time.Time) ([]byte, error) {
+	res, exists, err := s.lookupString(key, now)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if exists {
+		b, _ = stringBytes(res)
+	}
+	if int64(len(b)) < size {
+		b = append(b, make([]byte, size-int64(len(b)))...)
+	}
+	if exists {
+		res.value = b
+	} else {
+		s.InMemoryStore.set(key, &Resource{value: b})
+	}
+	return b, nil
+}
+
+func parseBitOffset(arg string) (int64, error) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+func getBit(b []byte, offset int64) int64 {
+	i := offset >> 3
+	if i >= int64(len(b)) {
+		return 0
+	}
+	return int64(b[i]>>(7-uint(offset&7))) & 1
+}
+
+func setBit(b []byte, offset int64, on bool) {
+	mask := byte(1) << (7 - uint(offset&7))
+	if on {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+}
+
+func (s *server) handleSetBit(req *Request) ([]resp.Value, error) {
+	offset, err := parseBitOffset(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	var on bool
+	switch req.Args[2] {
+	case "0":
+	case "1":
+		on = true
+	default:
+		return nil, errBitValue
+	}
+	b, err := s.bitmapForWrite(req.Args[0], offset>>3+1, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	old := getBit(b, offset)
+	setBit(b, offset, on)
+	s.addDirty(1)
+	return reply(resp.Integer(old))
+}
+
+func (s *server) handleGetBit(req *Request) ([]resp.Value, error) {
+	offset, err := parseBitOffset(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, exists, err := s.lookupString(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(getBit(readBytes(res), offset)))
+}
+
+// bitRange parses the optional start end [BYTE|BIT] arguments of BITCOUNT and
+// BITPOS into a range of bits of a string of n bytes. Negative indexes count
+// from the end, ok is false when the range is empty.
+func bitRange(args []string, n int64) (from, to int64, ok bool, err error) {
+	if len(args) == 0 {
+		return 0, n*8 - 1, n > 0, nil
+	}
+	start, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, false, errNotInteger
+	}
+	end := int64(math.MaxInt64)
+	if len(args) > 1 {
+		if end, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			return 0, 0, false, errNotInteger
+		}
+	}
+	isBit := false
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			isBit = true
+		default:
+			return 0, 0, false, errSyntax
+		}
+	}
+	if len(args) > 3 {
+		return 0, 0, false, errSyntax
+	}
+	total := n
+	if isBit {
+		total = n * 8
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if total == 0 || start > end {
+		return 0, 0, false, nil
+	}
+	if isBit {
+		return start, end, true, nil
+	}
+	return start * 8, end*8 + 7, true, nil
+}
+
+// countBits counts the bits set between the bit offsets from and to inclusive.
+func countBits(b []byte, from, to int64) int64 {
+	var count int64
+	for i := from; i <= to; {
+		if i&7 == 0 && i+7 <= to {
+			// whole byte
+			count += int64(bits.OnesCount8(b[i>>3]))
+			i += 8
+			continue
+		}
+		count += getBit(b, i)
+		i++
+	}
+	return count
+}
+
+func (s *server) handleBitCount(req *Request) ([]resp.Value, error) {
+	if len(req.Args) == 2 {
+		return nil, errSyntax
+	}
+	res, exists, err := s.lookupString(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if exists {
+		b = readBytes(res)
+	}
+	from, to, ok, err := bitRange(req.Args[1:], int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(countBits(b, from, to)))
+}
+
+func (s *server) handleBitPos(req *Request) ([]resp.Value, error) {
+	var bit byte
+	switch req.Args[1] {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return nil, errBitPosBit
+	}
+	res, exists, err := s.lookupString(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if bit == 1 {
+			return reply(resp.Integer(-1))
+		}
+		return reply(resp.Integer(0))
+	}
+	b := readBytes(res)
+	rangeArgs := req.Args[2:]
+	from, to, ok, err := bitRange(rangeArgs, int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return reply(resp.Integer(-1))
+	}
+	// bytes holding none of the bits looked for are skipped whole
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := from; i <= to; {
+		if i&7 == 0 && i+7 <= to && b[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if byte(getBit(b, i)) == bit {
+			return reply(resp.Integer(i))
+		}
+		i++
+	}
+	if bit == 0 && len(rangeArgs) < 2 {
+		// without an explicit end the string is considered padded with zeros
+		return reply(resp.Integer(to + 1))
+	}
+	return reply(resp.Integer(-1))
+}
+
+func (s *server) handleBitOp(req *Request) ([]resp.Value, error) {
+	op := strings.ToUpper(req.Args[0])
+	dst, keys := req.Args[1], req.Args[2:]
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(keys) != 1 {
+			return nil, errBitOpNot
+		}
+	default:
+		return nil, errSyntax
+	}
+	now := time.Now()
+	sources := make([][]byte, len(keys))
+	size := 0
+	for i, key := range keys {
+		res, exists, err := s.lookupString(key, now)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			sources[i] = readBytes(res)
+			size = max(size, len(sources[i]))
+		}
+	}
+	result := make([]byte, size)
+	for i := range result {
+		// missing bytes of shorter strings count as zeros
+		at := func(src []byte) byte {
+			if i < len(src) {
+				return src[i]
+			}
+			return 0
+		}
+		v := at(sources[0])
+		for _, src := range sources[1:] {
+			switch op {
+			case "AND":
+				v &= at(src)
+			case "OR":
+				v |= at(src)
+			case "XOR":
+				v ^= at(src)
+			}
+		}
+		if op == "NOT" {
+			v = ^v
+		}
+		result[i] = v
+	}
+	if s.InMemoryStore.delete(dst) || size > 0 {
+		s.addDirty(1)
+	}
+	if size > 0 {
+		s.InMemoryStore.set(dst, &Resource{value: result})
+	}
+	return reply(resp.Integer(int64(size)))
+}
+
+type bitfieldOverflow int
+
+const (
+	overflowWrap bitfieldOverflow = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldOp is one of the GET, SET and INCRBY operations of BITFIELD.
+type bitfieldOp struct {
+	op       string
+	signed   bool
+	bits     uint
+	offset   int64
+	value    int64
+	overflow bitfieldOverflow
+}
+
+// parseBitfieldType parses an encoding such as i8 or u16.
+func parseBitfieldType(arg string) (bool, uint, error) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u' && arg[0] != 'I' && arg[0] != 'U') {
+		return false, 0, errBitfieldType
+	}
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	n, err := strconv.ParseUint(arg[1:], 10, 8)
+	if err != nil || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return false, 0, errBitfieldType
+	}
+	return signed, uint(n), nil
+}
+
+// parseBitfieldOffset parses a bit offset, offsets prefixed with # are in units
+// of the field size.
+func parseBitfieldOffset(arg string, bits uint) (int64, error) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errBitOffset
+	}
+	if multiply {
+		if offset > maxBitOffset/int64(bits) {
+			return 0, errBitOffset
+		}
+		offset *= int64(bits)
+	}
+	if offset > maxBitOffset-int64(bits)+1 {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+func parseBitfield(args []string, readonly bool) ([]bitfieldOp, error) {
+	ops := []bitfieldOp{}
+	overflow := overflowWrap
+	for i := 0; i < len(args); i++ {
+		op := strings.ToUpper(args[i])
+		remaining := len(args) - i - 1
+		switch {
+		case op == "GET" && remaining >= 2:
+		case (op == "SET" || op == "INCRBY") && remaining >= 3:
+			if readonly {
+				return nil, errBitfieldRO
+			}
+		case op == "OVERFLOW" && remaining >= 1:
+			if readonly {
+				return nil, errBitfieldRO
+			}
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, errBitfieldOverflw
+			}
+			i++
+			continue
+		default:
+			if readonly {
+				return nil, errBitfieldRO
+			}
+			return nil, errSyntax
+		}
+		signed, bits, err := parseBitfieldType(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := parseBitfieldOffset(args[i+2], bits)
+		if err != nil {
+			return nil, err
+		}
+		fop := bitfieldOp{op: op, signed: signed, bits: bits, offset: offset, overflow: overflow}
+		i += 2
+		if op != "GET" {
+			if fop.value, err = strconv.ParseInt(args[i+1], 10, 64); err != nil {
+				return nil, errNotInteger
+			}
+			i++
+		}
+		ops = append(ops, fop)
+	}
+	return ops, nil
+}
+
+// getBitfield reads the bits-wide unsigned integer at offset, most significant
+// bit first.
+func getBitfield(b []byte, offset int64, bits uint) uint64 {
+	var v uint64
+	for i := int64(0); i < int64(bits); i++ {
+		v = v<<1 | uint64(getBit(b, offset+i))
+	}
+	return v
+}
+
+func setBitfield(b []byte, offset int64, bits uint, v uint64) {
+	for i := int64(0); i < int64(bits); i++ {
+		setBit(b, offset+i, v>>(uint64(bits)-1-uint64(i))&1 == 1)
+	}
+}
+
+// signExtend interprets the low bits of v as a two's complement integer.
+func signExtend(v uint64, bits uint) int64 {
+	if bits < 64 {
+		v &= 1<<bits - 1
+		if v&(1<<(bits-1)) != 0 {
+			v |= math.MaxUint64 << bits
+		}
+	}
+	return int64(v)
+}
+
+// addUnsigned adds incr to the bits-wide unsigned value, reporting whether the
+// result overflows and the value to store according to the overflow mode.
+func addUnsigned(value uint64, incr int64, bits uint, mode bitfieldOverflow) (uint64, bool) {
+	maxValue := uint64(1)<<bits - 1
+	wrapped := (value + uint64(incr)) & maxValue
+	switch {
+	case value > maxValue || (incr > 0 && uint64(incr) > maxValue-value):
+		if mode == overflowSat {
+			return maxValue, true
+		}
+		return wrapped, true
+	case incr < 0 && uint64(-incr) > value:
+		if mode == overflowSat {
+			return 0, true
+		}
+		return wrapped, true
+	}
+	return wrapped, false
+}
+
+// addSigned is addUnsigned for two's complement fields.
+func addSigned(value, incr int64, bits uint, mode bitfieldOverflow) (int64, bool) {
+	maxValue := int64(math.MaxInt64)
+	if bits < 64 {
+		maxValue = int64(1)<<(bits-1) - 1
+	}
+	minValue := -maxValue - 1
+	wrapped := signExtend(uint64(value)+uint64(incr), bits)
+	// with 64 bits the bounds can only be crossed moving away from zero
+	switch {
+	case value > maxValue || (incr > 0 && (bits < 64 || value >= 0) && incr > maxValue-value):
+		if mode == overflowSat {
+			return maxValue, true
+		}
+		return wrapped, true
+	case value < minValue || (incr < 0 && (bits < 64 || value < 0) && incr < minValue-value):
+		if mode == overflowSat {
+			return minValue, true
+		}
+		return wrapped, true
+	}
+	return wrapped, false
+}
+
+// handleBitfield serves BITFIELD and BITFIELD_RO. Every operation replies in
+// order, SET with the previous value, INCRBY with the new one, or nil when it
+// overflows in FAIL mode.
+func (s *server) handleBitfield(req *Request) ([]resp.Value, error) {
+	readonly := strings.EqualFold(string(req.Command), "BITFIELD_RO")
+	ops, err := parseBitfield(req.Args[1:], readonly)
+	if err != nil {
+		return nil, err
+	}
+	key := req.Args[0]
+	now := time.Now()
+	var b []byte
+	var size int64
+	for _, op := range ops {
+		if op.op != "GET" {
+			size = max(size, (op.offset+int64(op.bits)+7)/8)
+		}
+	}
+	if size > 0 {
+		if b, err = s.bitmapForWrite(key, size, now); err != nil {
+			return nil, err
+		}
+	} else {
+		res, exists, err := s.lookupString(key, now)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			b = readBytes(res)
+		}
+	}
+	values := make([]resp.Value, 0, len(ops))
+	for _, op := range ops {
+		old := getBitfield(b, op.offset, op.bits)
+		if op.op == "GET" {
+			if op.signed {
+				values = append(values, resp.Integer(signExtend(old, op.bits)))
+			} else {
+				values = append(values, resp.Integer(int64(old)))
+			}
+			continue
+		}
+		var stored uint64
+		var overflow bool
+		var result int64
+		switch {
+		case op.signed && op.op == "SET":
+			var v int64
+			v, overflow = addSigned(op.value, 0, op.bits, op.overflow)
+			stored, result = uint64(v), signExtend(old, op.bits)
+		case op.signed:
+			var v int64
+			v, overflow = addSigned(signExtend(old, op.bits), op.value, op.bits, op.overflow)
+			stored, result = uint64(v), v
+		case op.op == "SET":
+			stored, overflow = addUnsigned(uint64(op.value), 0, op.bits, op.overflow)
+			result = int64(old)
+		default:
+			stored, overflow = addUnsigned(old, op.value, op.bits, op.overflow)
+			result = int64(stored)
+		}
+		if overflow && op.overflow == overflowFail {
+			values = append(values, resp.NullBulk())
+			continue
+		}
+		setBitfield(b, op.offset, op.bits, stored)
+		s.addDirty(1)
+		values = append(values, resp.Integer(result))
+	}
+	return reply(resp.Array(values...))
+}
@@ -0,0 +1,265 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var (
+	errTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("ERR timeout is negative")
+	errTimeoutRange    = errors.New("ERR timeout is out of range")
+)
+
+// blockingState tracks the clients blocked on keys. Clients waiting on the same
+// key are served in the order they blocked.
+type blockingState struct {
+	mu      sync.Mutex
+	waiting map[string][]*blockedClient
+
+	// ready holds the keys that received data while clients were blocked, it has
+	// its own lock since it is filled by handlers which may run while mu is held
+	readyMu sync.Mutex
+	ready   map[string]struct{}
+
+	blocked atomic.Int64
+}
+
+func newBlockingState() *blockingState {
+	return &blockingState{
+		waiting: map[string][]*blockedClient{},
+		ready:   map[string]struct{}{},
+	}
+}
+
+// blockedClient is a blocking command waiting for one of its keys to receive data.
+type blockedClient struct {
+	keys []string
+	// valueType is the type of value the client waits for
+	valueType string
+	// extraKeys are the other keys serve writes to, locked along with the key
+	// being served
+	extraKeys []string
+	// serve runs the command against a key holding data, ok is false when the
+	// key has nothing to serve after all
+	serve        func(key string) (value resp.Value, ok bool, err error)
+	timeout      time.Duration
+	timeoutReply resp.Value
+	// deletedErr, when set, is the error the client gets once the key is
+	// deleted or overwritten by another type, instead of waiting for it to
+	// come back
+	deletedErr error
+
+	// req is the blocking command, logged to the append only file once served
+	req    *Request
+	result chan blockResult
+	// done is set once the client is served or gave up, guarded by blockingState.mu
+	done bool
+}
+
+type blockResult struct {
+	value resp.Value
+	err   error
+}
+
+// parseTimeout parses the timeout of blocking commands, in seconds with an
+// optional fractional part. Zero blocks forever.
+func parseTimeout(arg string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, errTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, errTimeoutNegative
+	}
+	if secs > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, errTimeoutRange
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// block registers the client of req as waiting on bc.keys. It must be called
+// with the keys locked, so that no push can slip in between the failed attempt
+// to serve the command and the registration. The dispatcher waits for the
+// result once the locks are released.
+func (s *server) block(req *Request, bc *blockedClient) ([]resp.Value, error) {
+	if req.client == nil {
+		// internal requests never block
+		return reply(bc.timeoutReply)
+	}
+	bc.req = req
+	bc.result = make(chan blockResult, 1)
+	b := s.blocking
+	b.mu.Lock()
+	for _, key := range bc.keys {
+		b.waiting[key] = append(b.waiting[key], bc)
+	}
+	b.mu.Unlock()
+	b.blocked.Add(1)
+	req.block = bc
+	return nil, nil
+}
+
+// waitBlocked waits until the blocked client of req is served, times out or
+// disconnects.
+func (s *server) waitBlocked(req *Request) ([]resp.Value, error) {
+	bc := req.block
+	var timeout <-chan time.Time
+	if bc.timeout > 0 {
+		timer := time.NewTimer(bc.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case result := <-bc.result:
+		return []resp.Value{result.value}, result.err
+	case <-timeout:
+	case <-req.client.closed:
+	}
+	if !s.blocking.release(bc) {
+		// served while giving up, the result is already on its way
+		result := <-bc.result
+		return []resp.Value{result.value}, result.err
+	}
+	return reply(bc.timeoutReply)
+}
+
+// releaseLocked removes bc from the queues of its keys, it reports false when bc
+// was already released. Must be called with mu held.
+func (b *blockingState) releaseLocked(bc *blockedClient) bool {
+	if bc.done {
+		return false
+	}
+	bc.done = true
+	for _, key := range bc.keys {
+		queue := b.waiting[key]
+		for i, waiting := range queue {
+			if waiting == bc {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(b.waiting, key)
+		} else {
+			b.waiting[key] = queue
+		}
+	}
+	b.blocked.Add(-1)
+	return true
+}
+
+func (b *blockingState) release(bc *blockedClient) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.releaseLocked(bc)
+}
+
+// signalKeyReady records that key received data. Called by the handlers adding
+// elements, with the key locked.
+func (s *server) signalKeyReady(key string) {
+	b := s.blocking
+	if b.blocked.Load() == 0 {
+		return
+	}
+	b.readyMu.Lock()
+	b.ready[key] = struct{}{}
+	b.readyMu.Unlock()
+}
+
+// signalDeletedKeys records as ready the keys no longer holding the type their
+// clients wait for, when those clients give up in that case. Called by the
+// dispatcher after write commands, with the keys locked.
+func (s *server) signalDeletedKeys(keys []string) {
+	b := s.blocking
+	if b.blocked.Load() == 0 {
+		return
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		for _, bc := range b.waiting[key] {
+			if bc.deletedErr == nil {
+				continue
+			}
+			if res, ok := s.InMemoryStore.lookup(key, now); !ok || typeName(res) != bc.valueType {
+				s.signalKeyReady(key)
+				break
+			}
+		}
+	}
+}
+
+// serveReadyKeys hands the data of the keys signaled ready to the clients
+// blocked on them. It runs after every write command, once its locks are
+// released. Serving a client can make more keys ready.
+func (s *server) serveReadyKeys() {
+	b := s.blocking
+	for {
+		b.readyMu.Lock()
+		key, found := "", false
+		for k := range b.ready {
+			key, found = k, true
+			delete(b.ready, k)
+			break
+		}
+		b.readyMu.Unlock()
+		if !found {
+			return
+		}
+		s.serveKey(key)
+	}
+}
+
+// serveKey serves the clients blocked on key, first come first served. Clients
+// waiting for another type than the one the key holds, or for data the key
+// doesn't have, such as stream entries past a given ID, stay blocked unless
+// they fail on a deleted key.
+func (s *server) serveKey(key string) {
+	b := s.blocking
+	b.mu.Lock()
+	queue := slices.Clone(b.waiting[key])
+	b.mu.Unlock()
+	for _, bc := range queue {
+		keys := append([]string{key}, bc.extraKeys...)
+		unlock := s.InMemoryStore.lockKeys(keys, true)
+		now := time.Now()
+		s.InMemoryStore.reclaimExpired(keys, now)
+		res, exists := s.InMemoryStore.lookup(key, now)
+		if !exists || typeName(res) != bc.valueType {
+			if bc.deletedErr != nil && b.release(bc) {
+				bc.result <- blockResult{err: bc.deletedErr}
+			}
+			unlock()
+			continue
+		}
+		b.mu.Lock()
+		if bc.done {
+			// the client gave up while the keys were being locked
+			b.mu.Unlock()
+			unlock()
+			continue
+		}
+		value, ok, err := bc.serve(key)
+		if ok || err != nil {
+			b.releaseLocked(bc)
+		}
+		b.mu.Unlock()
+		if ok && err == nil {
+			s.feedAOF(bc.req, keys, []resp.Value{value})
+		}
+		unlock()
+		if !ok && err == nil {
+			continue
+		}
+		bc.result <- blockResult{value, err}
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+const (
+	// requestQueueSize is how many parsed requests wait to be executed, the
+	// responses of those already waiting are written together
+	requestQueueSize = 128
+	// maxQueryBufferSize bounds the bytes of the requests read ahead of the one
+	// being executed, like client-query-buffer-limit of redis
+	maxQueryBufferSize = 1024 * 1024 * 1024
+)
+
+var nextClientID atomic.Int64
+
+// client is the state of a connection. Requests are parsed by a dedicated
+// goroutine so that a disconnect is noticed even while a command is blocked.
+type client struct {
+	id       int64
+	conn     net.Conn
+	requests chan parsedRequest
+	// closed is closed once the connection fails, even while requests read
+	// before wait to be executed
+	closed chan struct{}
+}
+
+type parsedRequest struct {
+	req *Request
+	err error
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{
+		id:       nextClientID.Add(1),
+		conn:     conn,
+		requests: make(chan parsedRequest, requestQueueSize),
+		closed:   make(chan struct{}),
+	}
+}
+
+// readRequests parses requests until the connection fails, the error ending the
+// stream is delivered as the last request. The connection keeps being read
+// while the requests wait, so that a client pipelining behind a blocked command
+// is still noticed when it disconnects.
+func (c *client) readRequests() {
+	parsed := make(chan parsedRequest)
+	go c.parseRequests(parsed)
+	defer close(c.requests)
+	var queue []parsedRequest
+	queued := 0
+	for parsed != nil || len(queue) > 0 {
+		// requests are only delivered when some wait
+		var deliver chan parsedRequest
+		var next parsedRequest
+		if len(queue) > 0 {
+			deliver, next = c.requests, queue[0]
+		}
+		select {
+		case p, ok := <-parsed:
+			if !ok {
+				parsed = nil
+				continue
+			}
+			queue = append(queue, p)
+			if p.req != nil {
+				queued += p.req.nBytes
+			}
+			if queued > maxQueryBufferSize {
+				log.Printf("warning: closing client %d that reached max query buffer length", c.id)
+				c.conn.Close()
+			}
+		case deliver <- next:
+			queue[0] = parsedRequest{}
+			queue = queue[1:]
+			if next.req != nil {
+				queued -= next.req.nBytes
+			}
+		}
+	}
+}
+
+// parseRequests sends the requests read from the connection to parsed until it
+// fails, then closes closed.
+func (c *client) parseRequests(parsed chan<- parsedRequest) {
+	defer close(parsed)
+	reader := NewRequestReader(c.conn)
+	for {
+		req, err := reader.ReadRequest()
+		if err != nil {
+			close(c.closed)
+			parsed <- parsedRequest{err: err}
+			return
+		}
+		req.client = c
+		parsed <- parsedRequest{req: req}
+	}
+}
@@ -0,0 +1,437 @@
+package app
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+type commandFlag uint
+
+const (
+	flagWrite commandFlag = 1 << iota
+	flagReadonly
+	flagAdmin
+	flagBlocking
+	flagPubsub
+	flagFast
+	flagDenyOOM
+	flagNoScript
+	flagLoading
+	flagStale
+	// flagExclusive makes a command that doesn't change the data as far as
+	// clients can tell take exclusive locks anyway, because it updates some
+	// internal state of the values it reads. It isn't reported by COMMAND.
+	flagExclusive
+)
+
+var commandFlagNames = []struct {
+	flag commandFlag
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadonly, "readonly"},
+	{flagDenyOOM, "denyoom"},
+	{flagAdmin, "admin"},
+	{flagPubsub, "pubsub"},
+	{flagNoScript, "noscript"},
+	{flagBlocking, "blocking"},
+	{flagLoading, "loading"},
+	{flagStale, "stale"},
+	{flagFast, "fast"},
+}
+
+type commandHandler func(s *server, req *Request) ([]resp.Value, error)
+
+// commandSpec describes a command the server understands. Arity follows the redis
+// convention: a positive value is the exact number of arguments including the
+// command name, a negative one is the minimum. Key positions are indexes in the
+// full argument vector (the command name is at 0), a negative lastKey counts from
+// the end.
+type commandSpec struct {
+	name     string
+	group    string
+	summary  string
+	arity    int
+	flags    commandFlag
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// keysFunc extracts the keys of commands whose key positions depend on the
+	// other arguments (e.g. a numkeys argument)
+	keysFunc    func(args []string) []string
+	handler     commandHandler
+	subcommands map[string]*commandSpec
+	parent      *commandSpec
+}
+
+var commandTable = map[string]*commandSpec{}
+
+func registerCommands(specs ...*commandSpec) {
+	for _, spec := range specs {
+		commandTable[spec.name] = spec
+		for _, sub := range spec.subcommands {
+			sub.parent = spec
+			if sub.group == "" {
+				sub.group = spec.group
+			}
+		}
+	}
+}
+
+// subcommands indexes specs by their upper-case name for use in a container command.
+func subcommands(specs ...*commandSpec) map[string]*commandSpec {
+	table := make(map[string]*commandSpec, len(specs))
+	for _, spec := range specs {
+		table[spec.name] = spec
+	}
+	return table
+}
+
+func (c *commandSpec) has(flag commandFlag) bool {
+	return c.flags&flag != 0
+}
+
+// fullName is the lower-case name redis reports, subcommands are "parent|sub".
+func (c *commandSpec) fullName() string {
+	if c.parent != nil {
+		return strings.ToLower(c.parent.name + "|" + c.name)
+	}
+	return strings.ToLower(c.name)
+}
+
+func (c *commandSpec) checkArity(argc int) bool {
+	return (c.arity > 0 && argc == c.arity) || (c.arity < 0 && argc >= -c.arity)
+}
+
+// keys returns the key arguments of a request, args excludes the command name.
+func (c *commandSpec) keys(args []string) []string {
+	if c.keysFunc != nil {
+		return c.keysFunc(args)
+	}
+	if c.firstKey == 0 {
+		return nil
+	}
+	argv := len(args) + 1
+	last := c.lastKey
+	if last < 0 {
+		last = argv + last
+	}
+	keys := []string{}
+	for i := c.firstKey; i <= last && i < argv; i += max(c.keyStep, 1) {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// lookupCommand resolves the spec that handles req, descending into container
+// commands such as CONFIG, and validates the arity of the request. Command and
+// subcommand names are matched case-insensitively.
+func lookupCommand(req *Request) (*commandSpec, error) {
+	spec, ok := commandTable[strings.ToUpper(string(req.Command))]
+	if !ok {
+		return nil, errUnknownCommand(string(req.Command), req.Args)
+	}
+	argc := len(req.Args) + 1
+	if spec.subcommands != nil && len(req.Args) > 0 {
+		sub, ok := spec.subcommands[strings.ToUpper(req.Args[0])]
+		if !ok {
+			return nil, errUnknownSubcommand(spec.name, req.Args[0])
+		}
+		if !sub.checkArity(argc) {
+			return nil, errWrongArgs(sub.fullName())
+		}
+		return sub, nil
+	}
+	if !spec.checkArity(argc) || spec.handler == nil {
+		return nil, errWrongArgs(spec.fullName())
+	}
+	return spec, nil
+}
+
+func reply(v resp.Value) ([]resp.Value, error) {
+	return []resp.Value{v}, nil
+}
+
+func init() {
+	registerCommands(&commandSpec{
+		name:    "COMMAND",
+		group:   "server",
+		summary: "Returns detailed information about all commands.",
+		arity:   -1,
+		flags:   flagLoading | flagStale,
+		handler: (*server).handleCommand,
+		subcommands: subcommands(
+			&commandSpec{
+				name:    "COUNT",
+				summary: "Returns a count of commands.",
+				arity:   2,
+				flags:   flagLoading | flagStale,
+				handler: (*server).handleCommandCount,
+			},
+			&commandSpec{
+				name:    "INFO",
+				summary: "Returns information about one, multiple or all commands.",
+				arity:   -2,
+				flags:   flagLoading | flagStale,
+				handler: (*server).handleCommandInfo,
+			},
+			&commandSpec{
+				name:    "DOCS",
+				summary: "Returns documentary information about one, multiple or all commands.",
+				arity:   -2,
+				flags:   flagLoading | flagStale,
+				handler: (*server).handleCommandDocs,
+			},
+			&commandSpec{
+				name:    "LIST",
+				summary: "Returns a list of command names.",
+				arity:   2,
+				flags:   flagLoading | flagStale,
+				handler: (*server).handleCommandList,
+			},
+			&commandSpec{
+				name:    "GETKEYS",
+				summary: "Extracts the key names from an arbitrary command.",
+				arity:   -3,
+				flags:   flagLoading | flagStale,
+				handler: (*server).handleCommandGetKeys,
+			},
+		),
+	})
+}
+
+// sortedCommands returns the top level commands ordered by name so that replies
+// are deterministic.
+func sortedCommands() []*commandSpec {
+	specs := make([]*commandSpec, 0, len(commandTable))
+	for _, spec := range commandTable {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].name < specs[j].name
+	})
+	return specs
+}
+
+func sortedSubcommands(spec *commandSpec) []*commandSpec {
+	specs := make([]*commandSpec, 0, len(spec.subcommands))
+	for _, sub := range spec.subcommands {
+		specs = append(specs, sub)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].name < specs[j].name
+	})
+	return specs
+}
+
+// findCommand resolves "name" or "parent|sub" as accepted by COMMAND INFO/DOCS.
+func findCommand(name string) (*commandSpec, bool) {
+	parent, sub, isSub := strings.Cut(strings.ToUpper(name), "|")
+	spec, ok := commandTable[parent]
+	if !ok || !isSub {
+		return spec, ok
+	}
+	spec, ok = spec.subcommands[sub]
+	return spec, ok
+}
+
+func (s *server) handleCommand(req *Request) ([]resp.Value, error) {
+	specs := sortedCommands()
+	infos := make([]resp.Value, len(specs))
+	for i, spec := range specs {
+		infos[i] = commandInfo(spec)
+	}
+	return reply(resp.Array(infos...))
+}
+
+func (s *server) handleCommandCount(req *Request) ([]resp.Value, error) {
+	return reply(resp.Integer(int64(len(commandTable))))
+}
+
+func (s *server) handleCommandList(req *Request) ([]resp.Value, error) {
+	names := []string{}
+	for _, spec := range sortedCommands() {
+		names = append(names, spec.fullName())
+	}
+	return reply(resp.BulkStrings(names))
+}
+
+func (s *server) handleCommandInfo(req *Request) ([]resp.Value, error) {
+	if len(req.Args) == 1 {
+		return s.handleCommand(req)
+	}
+	infos := make([]resp.Value, 0, len(req.Args)-1)
+	for _, name := range req.Args[1:] {
+		spec, ok := findCommand(name)
+		if !ok {
+			infos = append(infos, resp.NullArray())
+			continue
+		}
+		infos = append(infos, commandInfo(spec))
+	}
+	return reply(resp.Array(infos...))
+}
+
+func (s *server) handleCommandDocs(req *Request) ([]resp.Value, error) {
+	specs := []*commandSpec{}
+	if len(req.Args) == 1 {
+		specs = sortedCommands()
+	}
+	for _, name := range req.Args[1:] {
+		// unknown commands are omitted from the reply
+		if spec, ok := findCommand(name); ok {
+			specs = append(specs, spec)
+		}
+	}
+	docs := make([]resp.Value, 0, 2*len(specs))
+	for _, spec := range specs {
+		docs = append(docs, resp.BulkString(spec.fullName()), commandDocs(spec))
+	}
+	return reply(resp.Array(docs...))
+}
+
+func (s *server) handleCommandGetKeys(req *Request) ([]resp.Value, error) {
+	target := &Request{Command: Command(req.Args[1]), Args: req.Args[2:]}
+	spec, err := lookupCommand(target)
+	if err != nil {
+		return nil, err
+	}
+	keys := spec.keys(target.Args)
+	if len(keys) == 0 {
+		return nil, errors.New("ERR The command has no key arguments")
+	}
+	return reply(resp.BulkStrings(keys))
+}
+
+// commandInfo builds the reply redis 7 returns for a command in COMMAND and
+// COMMAND INFO.
+func commandInfo(spec *commandSpec) resp.Value {
+	flags := []resp.Value{}
+	for _, f := range commandFlagNames {
+		if spec.has(f.flag) {
+			flags = append(flags, resp.SimpleString(f.name))
+		}
+	}
+	if spec.keysFunc != nil {
+		flags = append(flags, resp.SimpleString("movablekeys"))
+	}
+	subs := []resp.Value{}
+	for _, sub := range sortedSubcommands(spec) {
+		subs = append(subs, commandInfo(sub))
+	}
+	return resp.Array(
+		resp.BulkString(spec.fullName()),
+		resp.Integer(int64(spec.arity)),
+		resp.Array(flags...),
+		resp.Integer(int64(spec.firstKey)),
+		resp.Integer(int64(spec.lastKey)),
+		resp.Integer(int64(spec.keyStep)),
+		resp.Array(aclCategories(spec)...),
+		resp.Array(),
+		resp.Array(keySpecs(spec)...),
+		resp.Array(subs...),
+	)
+}
+
+func aclCategories(spec *commandSpec) []resp.Value {
+	categories := []string{}
+	switch {
+	case spec.group == "generic":
+		categories = append(categories, "@keyspace")
+	case spec.group != "":
+		categories = append(categories, "@"+spec.group)
+	}
+	if spec.has(flagWrite) {
+		categories = append(categories, "@write")
+	}
+	if spec.has(flagReadonly) {
+		categories = append(categories, "@read")
+	}
+	if spec.has(flagAdmin) {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if spec.has(flagPubsub) {
+		categories = append(categories, "@pubsub")
+	}
+	if spec.has(flagBlocking) {
+		categories = append(categories, "@blocking")
+	}
+	if spec.has(flagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	values := make([]resp.Value, len(categories))
+	for i, category := range categories {
+		values[i] = resp.SimpleString(category)
+	}
+	return values
+}
+
+// keySpecs describes the fixed key range of a command in the redis 7 key-spec
+// format used by cluster aware clients.
+func keySpecs(spec *commandSpec) []resp.Value {
+	if spec.firstKey == 0 {
+		return nil
+	}
+	access := "RO"
+	if spec.has(flagWrite) {
+		access = "RW"
+	}
+	lastKey := spec.lastKey
+	if lastKey >= 0 {
+		lastKey -= spec.firstKey
+	}
+	return []resp.Value{resp.Array(
+		resp.BulkString("flags"),
+		resp.Array(resp.SimpleString(access)),
+		resp.BulkString("begin_search"),
+		resp.Array(
+			resp.BulkString("type"), resp.BulkString("index"),
+			resp.BulkString("spec"), resp.Array(resp.BulkString("index"), resp.Integer(int64(spec.firstKey))),
+		),
+		resp.BulkString("find_keys"),
+		resp.Array(
+			resp.BulkString("type"), resp.BulkString("range"),
+			resp.BulkString("spec"), resp.Array(
+				resp.BulkString("lastkey"), resp.Integer(int64(lastKey)),
+				resp.BulkString("keystep"), resp.Integer(int64(max(spec.keyStep, 1))),
+				resp.BulkString("limit"), resp.Integer(0),
+			),
+		),
+	)}
+}
+
+func commandDocs(spec *commandSpec) resp.Value {
+	doc := []resp.Value{
+		resp.BulkString("summary"), resp.BulkString(spec.summary),
+		resp.BulkString("group"), resp.BulkString(spec.group),
+	}
+	if len(spec.subcommands) > 0 {
+		subs := []resp.Value{}
+		for _, sub := range sortedSubcommands(spec) {
+			subs = append(subs, resp.BulkString(sub.fullName()), commandDocs(sub))
+		}
+		doc = append(doc, resp.BulkString("subcommands"), resp.Array(subs...))
+	}
+	return resp.Array(doc...)
+}
+
+// numkeysKeys extracts the keys of commands taking them as a count followed by
+// the names, with the count at position numkeysAt of the arguments.
+func numkeysKeys(numkeysAt int) func(args []string) []string {
+	return func(args []string) []string {
+		if numkeysAt >= len(args) {
+			return nil
+		}
+		n, err := strconv.Atoi(args[numkeysAt])
+		if err != nil || n <= 0 || n > len(args)-numkeysAt-1 {
+			return nil
+		}
+		return args[numkeysAt+1 : numkeysAt+1+n]
+	}
+}
@@ -1,8 +1,159 @@
 package app
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 type Config struct {
 	Dir        string
 	DbFilename string
 	Port       string
 	ReplicaOf  *string
+	// Hz is how many times per second background tasks such as the active
+	// expiration cycle run
+	Hz int
+	// HashMaxListpackEntries and HashMaxListpackValue bound the hashes kept in
+	// the compact encoding, in number of fields and in bytes per field or value
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	// SetMaxIntsetEntries bounds the size of the sets of integers kept in the
+	// compact encoding
+	SetMaxIntsetEntries int
+	// StreamNodeMaxEntries is the number of entries of a stream grouped in a
+	// node
+	StreamNodeMaxEntries int
+	// HllSparseMaxBytes is the size past which HyperLogLogs switch from the
+	// sparse to the dense encoding
+	HllSparseMaxBytes int
+	// SaveRules trigger a background save once one of them sees enough
+	// changes in its period, there is no automatic save without rules
+	SaveRules []SaveRule
+	// StopWritesOnBgsaveError makes write commands fail while the last
+	// background save failed, so that a dump that can't be written gets noticed
+	StopWritesOnBgsaveError bool
+	// AppendOnly logs every write command to the append only file, which is
+	// loaded on startup instead of the RDB file
+	AppendOnly bool
+	// AppendFilename is the prefix of the names of the files making the
+	// append only file, kept in the AppendDirname directory of Dir
+	AppendFilename string
+	AppendDirname  string
+	// AppendFsync is how often the append only file is flushed to disk
+	AppendFsync AppendFsync
+	// AofLoadTruncated loads an append only file whose last command was cut
+	// short, as after a crash, dropping that command instead of failing
+	AofLoadTruncated bool
+	// AofUseRdbPreamble writes the bases of the append only file as RDB files,
+	// faster to write and load than commands
+	AofUseRdbPreamble bool
+	// AutoAofRewritePercentage triggers a rewrite of the append only file once
+	// it grew by that percentage since the last one, provided it is at least
+	// AutoAofRewriteMinSize bytes. Zero disables automatic rewrites.
+	AutoAofRewritePercentage int
+	AutoAofRewriteMinSize    int64
+}
+
+// AppendFsync is a policy for flushing the append only file to disk.
+type AppendFsync int
+
+const (
+	// AppendFsyncEverysec flushes the file once per second, at most a second
+	// of writes is lost on a crash
+	AppendFsyncEverysec AppendFsync = iota
+	// AppendFsyncAlways flushes the file before replying to each write
+	AppendFsyncAlways
+	// AppendFsyncNo leaves the flushes to the operating system
+	AppendFsyncNo
+)
+
+var appendFsyncNames = []string{"everysec", "always", "no"}
+
+// ParseAppendFsync parses the appendfsync parameter of redis.
+func ParseAppendFsync(s string) (AppendFsync, error) {
+	for i, name := range appendFsyncNames {
+		if strings.EqualFold(s, name) {
+			return AppendFsync(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid appendfsync %q, must be one of always, everysec or no", s)
+}
+
+func (f AppendFsync) String() string {
+	return appendFsyncNames[f]
+}
+
+// SaveRule asks for a background save when at least Changes writes happened
+// and Seconds seconds elapsed since the last save.
+type SaveRule struct {
+	Seconds int
+	Changes int
+}
+
+// ParseSaveRules parses the save parameter of redis, a list of seconds and
+// changes pairs such as "3600 1 300 100". An empty string means no rule.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters %q", s)
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save parameters %q", s)
+		}
+		changes, err := strconv.Atoi(fields[i+1])
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters %q", s)
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// formatSaveRules formats rules the way ParseSaveRules reads them.
+func formatSaveRules(rules []SaveRule) string {
+	parts := make([]string, 0, 2*len(rules))
+	for _, rule := range rules {
+		parts = append(parts, strconv.Itoa(rule.Seconds), strconv.Itoa(rule.Changes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseMemory parses a size in bytes with an optional unit such as "64mb",
+// where k, m and g are powers of 1000 and kb, mb and gb powers of 1024, like
+// in the configuration of redis.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(s)
+	mul := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, mul = strings.TrimSuffix(lower, unit.suffix), unit.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * mul, nil
+}
+
+// yesNo formats a boolean parameter the way redis reports it.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
 }
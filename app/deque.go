@@ -0,0 +1,119 @@
+package app
+
+const minDequeCapacity = 8
+
+// deque is the list value: a ring buffer growing and shrinking by powers of two,
+// so pushes and pops at both ends are amortized O(1) and indexing is O(1).
+type deque struct {
+	buf  []string
+	head int
+	size int
+}
+
+func newDeque() *deque {
+	return &deque{buf: make([]string, minDequeCapacity)}
+}
+
+func (d *deque) Len() int {
+	return d.size
+}
+
+func (d *deque) index(i int) int {
+	return (d.head + i) & (len(d.buf) - 1)
+}
+
+func (d *deque) resize(capacity int) {
+	buf := make([]string, capacity)
+	for i := 0; i < d.size; i++ {
+		buf[i] = d.buf[d.index(i)]
+	}
+	d.buf = buf
+	d.head = 0
+}
+
+func (d *deque) grow() {
+	if d.size == len(d.buf) {
+		d.resize(len(d.buf) * 2)
+	}
+}
+
+func (d *deque) shrink() {
+	if len(d.buf) > minDequeCapacity && d.size <= len(d.buf)/4 {
+		d.resize(len(d.buf) / 2)
+	}
+}
+
+func (d *deque) PushFront(v string) {
+	d.grow()
+	d.head = (d.head - 1) & (len(d.buf) - 1)
+	d.buf[d.head] = v
+	d.size++
+}
+
+func (d *deque) PushBack(v string) {
+	d.grow()
+	d.buf[d.index(d.size)] = v
+	d.size++
+}
+
+func (d *deque) PopFront() string {
+	v := d.buf[d.head]
+	d.buf[d.head] = ""
+	d.head = d.index(1)
+	d.size--
+	d.shrink()
+	return v
+}
+
+func (d *deque) PopBack() string {
+	i := d.index(d.size - 1)
+	v := d.buf[i]
+	d.buf[i] = ""
+	d.size--
+	d.shrink()
+	return v
+}
+
+func (d *deque) At(i int) string {
+	return d.buf[d.index(i)]
+}
+
+func (d *deque) Set(i int, v string) {
+	d.buf[d.index(i)] = v
+}
+
+// Range returns the elements from start to end inclusive.
+func (d *deque) Range(start, end int) []string {
+	items := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		items = append(items, d.At(i))
+	}
+	return items
+}
+
+// replace rebuilds the deque with the given elements, used by the operations
+// touching the middle of the list.
+func (d *deque) replace(items []string) {
+	capacity := minDequeCapacity
+	for capacity < len(items) {
+		capacity *= 2
+	}
+	d.buf = make([]string, capacity)
+	copy(d.buf, items)
+	d.head = 0
+	d.size = len(items)
+}
+
+// clone returns a copy of the deque sharing no storage with it.
+func (d *deque) clone() *deque {
+	c := &deque{buf: make([]string, len(d.buf)), head: d.head, size: d.size}
+	copy(c.buf, d.buf)
+	return c
+}
+
+// Insert puts v at position i, shifting the following elements.
+func (d *deque) Insert(i int, v string) {
+	items := d.Range(0, d.size-1)
+	items = append(items[:i], append([]string{v}, items[i:]...)...)
+	d.replace(items)
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by command handlers are sent to the client verbatim as RESP
+// errors, so their messages start with the redis error code.
+var (
+	errWrongType     = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger    = errors.New("ERR value is not an integer or out of range")
+	errSyntax        = errors.New("ERR syntax error")
+	errInvalidExpire = errors.New("ERR invalid expire time in 'set' command")
+)
+
+func errWrongArgs(command string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+}
+
+func errUnknownCommand(command string, args []string) error {
+	var builder strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&builder, "'%s' ", arg)
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", command, builder.String())
+}
+
+func errUnknownSubcommand(command string, subcommand string) error {
+	return fmt.Errorf("ERR unknown subcommand '%s'. Try %s HELP.", subcommand, strings.ToUpper(command))
+}
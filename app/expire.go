@@ -0,0 +1,162 @@
+package app
+
+import (
+	"time"
+)
+
+const (
+	defaultHz = 10
+	// keys with a ttl looked at per shard in one round of the active cycle
+	activeExpireSamples = 20
+	// another round is done on a shard while more than this share of the sample was expired
+	activeExpireAcceptablePerc = 25
+	// share of each cycle period the active cycle may spend reclaiming keys
+	activeExpireCpuPerc = 25
+)
+
+// reclaimExpired deletes the given keys if their ttl elapsed, along with the
+// expired fields of the hashes among them. The caller holds the write locks of
+// their shards.
+func (m *InMemoryStore) reclaimExpired(keys []string, now time.Time) {
+	for _, key := range keys {
+		res, ok := m.get(key)
+		if !ok {
+			continue
+		}
+		if m.expired(res, now) {
+			m.delete(key)
+			m.expiredKeys.Add(1)
+			m.reclaimed(key, nil)
+			continue
+		}
+		if h, isHash := res.value.(*hash); isHash && h.volatile() {
+			m.reclaimExpiredFields(key, h, now)
+		}
+	}
+}
+
+// reclaimExpiredFields deletes the expired fields of the hash stored at key, and
+// the key itself when no field is left.
+func (m *InMemoryStore) reclaimExpiredFields(key string, h *hash, now time.Time) int {
+	fields := h.reclaimExpired(now)
+	m.expiredFields.Add(int64(len(fields)))
+	if h.Len() == 0 {
+		m.delete(key)
+	} else if !h.volatile() {
+		delete(m.shardFor(key).volatileHashes, key)
+	}
+	if len(fields) > 0 {
+		m.reclaimed(key, fields)
+	}
+	return len(fields)
+}
+
+// reclaimed reports the deletion of an expired key, or of expired fields of
+// the hash stored at key, to onReclaim.
+func (m *InMemoryStore) reclaimed(key string, fields []string) {
+	if m.onReclaim != nil {
+		m.onReclaim(key, fields)
+	}
+}
+
+// expiredAmong returns the keys whose ttl elapsed or holding hashes with expired
+// fields, the caller holds at least the read locks of their shards.
+func (m *InMemoryStore) expiredAmong(keys []string, now time.Time) []string {
+	var stale []string
+	for _, key := range keys {
+		res, ok := m.get(key)
+		if !ok {
+			continue
+		}
+		if h, isHash := res.value.(*hash); m.expired(res, now) || (isHash && h.hasExpired(now)) {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
+
+// expireShard samples keys having a ttl in one shard and deletes the expired
+// ones, it returns how many keys were sampled and how many of them were deleted.
+func (m *InMemoryStore) expireShard(sh *shard, now time.Time) (int, int) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sampled, reclaimed := 0, 0
+	// map iteration starts at a random position, which gives a random sample
+	for key := range sh.volatile {
+		if sampled == activeExpireSamples {
+			break
+		}
+		sampled++
+		if res, ok := sh.items[key]; ok && m.expired(res, now) {
+			sh.removeKey(key)
+			m.reclaimed(key, nil)
+			reclaimed++
+		}
+	}
+	m.expiredKeys.Add(int64(reclaimed))
+	// hashes with expiring fields are sampled the same way, a hash counts as
+	// reclaimed when at least one of its fields was
+	hashes := 0
+	for key := range sh.volatileHashes {
+		if hashes == activeExpireSamples {
+			break
+		}
+		hashes++
+		sampled++
+		res, ok := sh.items[key]
+		if !ok {
+			delete(sh.volatileHashes, key)
+			continue
+		}
+		if h, isHash := res.value.(*hash); isHash && m.reclaimExpiredFields(key, h, now) > 0 {
+			reclaimed++
+		}
+	}
+	return sampled, reclaimed
+}
+
+// activeExpireCycle is the redis active expiration algorithm: every shard is
+// sampled and sampled again as long as a large share of its sample was expired,
+// until the time budget of the cycle is exhausted. Only one shard is locked at a
+// time so commands keep being served while the cycle runs.
+func (m *InMemoryStore) activeExpireCycle(budget time.Duration) {
+	start := time.Now()
+	for _, sh := range m.shards {
+		for {
+			now := time.Now()
+			if now.Sub(start) > budget {
+				return
+			}
+			sampled, reclaimed := m.expireShard(sh, now)
+			if sampled == 0 || reclaimed*100 <= sampled*activeExpireAcceptablePerc {
+				break
+			}
+		}
+	}
+}
+
+// activeExpireLoop runs the active expiration cycle hz times per second.
+func (s *server) activeExpireLoop() {
+	hz := s.Config.Hz
+	if hz <= 0 {
+		hz = defaultHz
+	}
+	period := time.Second / time.Duration(hz)
+	budget := period * activeExpireCpuPerc / 100
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.InMemoryStore.activeExpireCycle(budget)
+	}
+}
+
+// Volatile returns the number of keys having a ttl.
+func (m *InMemoryStore) Volatile() int {
+	n := 0
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		n += len(sh.volatile)
+		sh.mu.RUnlock()
+	}
+	return n
+}
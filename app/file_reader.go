@@ -1,14 +1,25 @@
 package app
 
 import (
-	"errors"
+	"bufio"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"log"
+	"math"
 	"os"
-
-	"github.com/Vergangenheit/rdb-go"
+	"strconv"
+	"time"
 )
 
+// rdbMaxVersion is the newest RDB format the loader understands, the one of
+// redis 7.4.
+const rdbMaxVersion = 12
+
+// ReadRedisDBFile loads the keys of the first database of an RDB file. Every
+// value type of redis is decoded, whatever its encoding. Keys of module types,
+// which can't be decoded without the module, and keys of other databases are
+// skipped with a warning. Keys and hash fields already expired are dropped.
 func ReadRedisDBFile(filename string) (map[string]*Resource, error) {
 	// Open the Redis RDB file
 	rdbFile, err := os.Open(filename)
@@ -17,30 +28,927 @@ func ReadRedisDBFile(filename string) (map[string]*Resource, error) {
 	}
 	defer rdbFile.Close()
 
-	// Parse the RDB file and extract key-value pairs
+	result, err := newRDBReader(rdbFile).load()
+	if err != nil {
+		return nil, fmt.Errorf("could not load RDB file %s: %w", filename, err)
+	}
+	return result, nil
+}
+
+type rdbReader struct {
+	r   *checksumReader
+	now time.Time
+}
+
+func newRDBReader(r io.Reader) *rdbReader {
+	return &rdbReader{r: &checksumReader{r: bufio.NewReader(r)}, now: time.Now()}
+}
+
+// checksumReader keeps the checksum of the bytes read so far.
+type checksumReader struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func (cr *checksumReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc = rdbChecksum(cr.crc, p[:n])
+	return n, err
+}
+
+func (cr *checksumReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc = rdbChecksum(cr.crc, []byte{b})
+	}
+	return b, err
+}
+
+func (rd *rdbReader) load() (map[string]*Resource, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(rd.r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("wrong signature %q", header[:5])
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > rdbMaxVersion {
+		return nil, fmt.Errorf("unsupported RDB version %q", header[5:])
+	}
+
 	result := make(map[string]*Resource)
+	db := uint64(0)
+	var expiry *time.Time
+	for {
+		opcode, err := rd.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case rdbOpEOF:
+			if version < 5 {
+				return result, nil
+			}
+			// a zero checksum means the dump was saved without one
+			crc := rd.r.crc
+			var b [8]byte
+			if _, err := io.ReadFull(rd.r, b[:]); err != nil {
+				return nil, err
+			}
+			if stored := binary.LittleEndian.Uint64(b[:]); stored != 0 && stored != crc {
+				return nil, fmt.Errorf("wrong checksum, expected %x got %x", stored, crc)
+			}
+			return result, nil
+		case rdbOpSelectDB:
+			if db, err = rd.readLen(); err != nil {
+				return nil, err
+			}
+			if db != 0 {
+				log.Printf("warning: skipping the keys of database %d, only database 0 is supported", db)
+			}
+		case rdbOpResizeDB:
+			if _, err := rd.readLen(); err != nil {
+				return nil, err
+			}
+			if _, err := rd.readLen(); err != nil {
+				return nil, err
+			}
+		case rdbOpAux:
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+		case rdbOpExpireTime:
+			var b [4]byte
+			if _, err := io.ReadFull(rd.r, b[:]); err != nil {
+				return nil, err
+			}
+			deadline := time.Unix(int64(binary.LittleEndian.Uint32(b[:])), 0)
+			expiry = &deadline
+		case rdbOpExpireTimeMs:
+			deadline, err := rd.readMillisecondTime()
+			if err != nil {
+				return nil, err
+			}
+			expiry = &deadline
+		case rdbOpIdle:
+			if _, err := rd.readLen(); err != nil {
+				return nil, err
+			}
+		case rdbOpFreq:
+			if _, err := rd.r.ReadByte(); err != nil {
+				return nil, err
+			}
+		case rdbOpSlotInfo:
+			for range 3 {
+				if _, err := rd.readLen(); err != nil {
+					return nil, err
+				}
+			}
+		case rdbOpModuleAux:
+			id, err := rd.readLen()
+			if err != nil {
+				return nil, err
+			}
+			// the moment the data was saved, before or after the keys
+			if _, err := rd.readLen(); err != nil {
+				return nil, err
+			}
+			if _, err := rd.readLen(); err != nil {
+				return nil, err
+			}
+			if err := rd.skipModuleValue(); err != nil {
+				return nil, err
+			}
+			log.Printf("warning: skipping the auxiliary data of module type %s, modules are not supported", moduleTypeName(id))
+		case rdbOpFunction2:
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+			log.Printf("warning: skipping a function library, functions are not supported")
+		case rdbOpFunctionPre:
+			return nil, fmt.Errorf("functions saved by a pre-release of redis 7.0 are not supported")
+		default:
+			key, err := rd.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := rd.readValue(opcode)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			res := &Resource{value: value, expired: expiry}
+			expiry = nil
+			switch {
+			case value == nil:
+				// a module value, already reported
+			case db != 0:
+			case expired(res, rd.now):
+			default:
+				result[string(key)] = res
+			}
+		}
+	}
+}
+
+// readLen reads a length prefixed integer.
+func (rd *rdbReader) readLen() (uint64, error) {
+	n, encoded, err := rd.readLenOrEncoding()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, errCorruptRDB
+	}
+	return n, nil
+}
+
+// readLenOrEncoding reads a length, or the special encoding of a string when
+// encoded is true.
+func (rd *rdbReader) readLenOrEncoding() (uint64, bool, error) {
+	b, err := rd.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := rd.r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		var buf [8]byte
+		switch b {
+		case 0x80:
+			if _, err := io.ReadFull(rd.r, buf[:4]); err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf[:4])), false, nil
+		case 0x81:
+			if _, err := io.ReadFull(rd.r, buf[:]); err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf[:]), false, nil
+		}
+		return 0, false, errCorruptRDB
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+// readString reads a string, which may be stored as an integer or compressed.
+func (rd *rdbReader) readString() ([]byte, error) {
+	n, encoded, err := rd.readLenOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return rd.readBytes(n)
+	}
+	switch n {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		b, err := rd.readBytes(1 << n)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, littleEndianInt(b), 10), nil
+	case rdbEncLZF:
+		clen, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		data, err := rd.readBytes(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(data, int(ulen))
+	}
+	return nil, errCorruptRDB
+}
 
-	parser := rdb.NewParser(rdbFile)
+func (rd *rdbReader) readBytes(n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, errCorruptRDB
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rd.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
 
+func (rd *rdbReader) readMillisecondTime() (time.Time, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(rd.r, b[:]); err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(binary.LittleEndian.Uint64(b[:]))), nil
+}
+
+// readStrings reads a length followed by as many strings.
+func (rd *rdbReader) readStrings(pairs bool) ([]string, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if pairs {
+		n *= 2
+	}
+	items := make([]string, 0, min(n, 1024))
+	for range n {
+		b, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, string(b))
+	}
+	return items, nil
+}
+
+// readEncoded reads a string holding a listpack, ziplist, zipmap or intset and
+// decodes it with decode.
+func readEncoded[T any](rd *rdbReader, decode func([]byte) (T, error)) (T, error) {
+	b, err := rd.readString()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decode(b)
+}
+
+// readValue reads a value of the given type and returns it in its in-memory
+// form, or nil for the values of modules.
+func (rd *rdbReader) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	case rdbTypeString:
+		b, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		return encodeString(string(b)), nil
+	case rdbTypeList:
+		items, err := rd.readStrings(false)
+		if err != nil {
+			return nil, err
+		}
+		return listFrom(items), nil
+	case rdbTypeListZiplist:
+		items, err := readEncoded(rd, ziplistEntries)
+		if err != nil {
+			return nil, err
+		}
+		return listFrom(items), nil
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		return rd.readQuicklist(valueType == rdbTypeListQuicklist2)
+	case rdbTypeSet:
+		members, err := rd.readStrings(false)
+		if err != nil {
+			return nil, err
+		}
+		st := newSet()
+		st.convert()
+		for _, member := range members {
+			st.Add(member)
+		}
+		return st, nil
+	case rdbTypeSetIntset:
+		ints, err := readEncoded(rd, intsetMembers)
+		if err != nil {
+			return nil, err
+		}
+		return &set{ints: ints}, nil
+	case rdbTypeSetListpack:
+		members, err := readEncoded(rd, listpackEntries)
+		if err != nil {
+			return nil, err
+		}
+		st := newSet()
+		for _, member := range members {
+			st.Add(member)
+		}
+		return st, nil
+	case rdbTypeZSet, rdbTypeZSet2:
+		return rd.readZSet(valueType == rdbTypeZSet2)
+	case rdbTypeZSetZiplist:
+		items, err := readEncoded(rd, ziplistEntries)
+		if err != nil {
+			return nil, err
+		}
+		return zsetFrom(items)
+	case rdbTypeZSetListpack:
+		items, err := readEncoded(rd, listpackEntries)
+		if err != nil {
+			return nil, err
+		}
+		return zsetFrom(items)
+	case rdbTypeHash:
+		items, err := rd.readStrings(true)
+		if err != nil {
+			return nil, err
+		}
+		return hashFrom(items, false)
+	case rdbTypeHashZipmap:
+		items, err := readEncoded(rd, zipmapEntries)
+		if err != nil {
+			return nil, err
+		}
+		return hashFrom(items, true)
+	case rdbTypeHashZiplist:
+		items, err := readEncoded(rd, ziplistEntries)
+		if err != nil {
+			return nil, err
+		}
+		return hashFrom(items, true)
+	case rdbTypeHashListpack:
+		items, err := readEncoded(rd, listpackEntries)
+		if err != nil {
+			return nil, err
+		}
+		return hashFrom(items, true)
+	case rdbTypeHashMetadata, rdbTypeHashMetadataPre:
+		return rd.readHashMetadata(valueType == rdbTypeHashMetadataPre)
+	case rdbTypeHashListpackEx, rdbTypeHashListpackExPre:
+		return rd.readHashListpackEx(valueType == rdbTypeHashListpackExPre)
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return rd.readStream(valueType)
+	case rdbTypeModule2:
+		id, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		if err := rd.skipModuleValue(); err != nil {
+			return nil, err
+		}
+		log.Printf("warning: skipping a key of module type %s, modules are not supported", moduleTypeName(id))
+		return nil, nil
+	case rdbTypeModule:
+		return nil, fmt.Errorf("values of modules saved by redis 4.0 release candidates are not supported")
+	}
+	return nil, fmt.Errorf("unknown value type %d", valueType)
+}
+
+// skipModuleValue skips a value serialized by a module, made of typed items up
+// to an EOF opcode.
+func (rd *rdbReader) skipModuleValue() error {
 	for {
-		data, err := parser.Next()
-		if errors.Is(err, io.EOF) {
-			break
+		op, err := rd.readLen()
+		if err != nil {
+			return err
 		}
+		switch op {
+		case rdbModuleOpEOF:
+			return nil
+		case rdbModuleOpSInt, rdbModuleOpUInt:
+			_, err = rd.readLen()
+		case rdbModuleOpFloat:
+			_, err = rd.readBytes(4)
+		case rdbModuleOpDouble:
+			_, err = rd.readBytes(8)
+		case rdbModuleOpString:
+			_, err = rd.readString()
+		default:
+			return errCorruptRDB
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
+func listFrom(items []string) *deque {
+	d := newDeque()
+	for _, item := range items {
+		d.PushBack(item)
+	}
+	return d
+}
+
+// readQuicklist reads a list made of ziplist nodes, or of listpack and plain
+// nodes when packed is true.
+func (rd *rdbReader) readQuicklist(packed bool) (*deque, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	d := newDeque()
+	for range n {
+		container := uint64(quicklistNodePacked)
+		if packed {
+			if container, err = rd.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		b, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		var items []string
+		switch {
+		case container == quicklistNodePlain:
+			items = []string{string(b)}
+		case packed:
+			items, err = listpackEntries(b)
+		default:
+			items, err = ziplistEntries(b)
+		}
 		if err != nil {
 			return nil, err
 		}
+		for _, item := range items {
+			d.PushBack(item)
+		}
+	}
+	return d, nil
+}
 
-		switch data := data.(type) {
-		case *rdb.StringData:
-			// add it to store
-			result[data.Key] = &Resource{
-				value:   data.Value,
-				expired: data.Expiry,
+// readZSet reads a sorted set with scores stored as binary doubles, or as
+// strings in the original format.
+func (rd *rdbReader) readZSet(binaryScores bool) (*zset, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	z := newZSet()
+	for range n {
+		member, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScores {
+			b, err := rd.readBytes(8)
+			if err != nil {
+				return nil, err
 			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else if score, err = rd.readStringScore(); err != nil {
+			return nil, err
 		}
+		z.Set(string(member), score)
 	}
+	return z, nil
+}
 
-	return result, nil
+func (rd *rdbReader) readStringScore() (float64, error) {
+	n, err := rd.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := rd.readBytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, errCorruptRDB
+	}
+	return score, nil
+}
+
+// zsetFrom builds a sorted set from alternating members and scores.
+func zsetFrom(items []string) (*zset, error) {
+	if len(items)%2 != 0 {
+		return nil, errCorruptRDB
+	}
+	z := newZSet()
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(items[i+1], 64)
+		if err != nil {
+			return nil, errCorruptRDB
+		}
+		z.Set(items[i], score)
+	}
+	return z, nil
+}
+
+// hashFrom builds a hash from alternating fields and values, keeping the
+// compact encoding when the dump used one.
+func hashFrom(items []string, compact bool) (*hash, error) {
+	if len(items)%2 != 0 {
+		return nil, errCorruptRDB
+	}
+	h := newHash()
+	if !compact {
+		h.convert()
+	}
+	for i := 0; i < len(items); i += 2 {
+		h.Set(items[i], items[i+1])
+	}
+	return h, nil
+}
+
+// readHashMetadata reads a hash having fields with a ttl. The ttls are stored
+// relative to the smallest one, plus one so that zero means no ttl, except by
+// the pre-releases of redis 7.4 which store absolute times.
+func (rd *rdbReader) readHashMetadata(preRelease bool) (interface{}, error) {
+	var minExpire int64
+	if !preRelease {
+		deadline, err := rd.readMillisecondTime()
+		if err != nil {
+			return nil, err
+		}
+		minExpire = deadline.UnixMilli()
+	}
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	h := newHash()
+	h.convert()
+	for range n {
+		ttl, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		field, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		h.Set(string(field), string(value))
+		switch {
+		case preRelease && ttl != 0:
+			h.SetExpiry(string(field), time.UnixMilli(int64(ttl)))
+		case ttl != 0:
+			h.SetExpiry(string(field), time.UnixMilli(int64(ttl)+minExpire-1))
+		}
+	}
+	return rd.liveHash(h), nil
+}
+
+// readHashListpackEx reads a small hash having fields with a ttl, a listpack
+// of field, value and absolute expire time triplets, zero meaning no ttl.
+func (rd *rdbReader) readHashListpackEx(preRelease bool) (interface{}, error) {
+	if !preRelease {
+		// the smallest ttl, only useful to redis to index the key
+		if _, err := rd.readMillisecondTime(); err != nil {
+			return nil, err
+		}
+	}
+	items, err := readEncoded(rd, listpackEntries)
+	if err != nil {
+		return nil, err
+	}
+	if len(items)%3 != 0 {
+		return nil, errCorruptRDB
+	}
+	h := newHash()
+	for i := 0; i < len(items); i += 3 {
+		h.Set(items[i], items[i+1])
+		ttl, err := strconv.ParseInt(items[i+2], 10, 64)
+		if err != nil {
+			return nil, errCorruptRDB
+		}
+		if ttl != 0 {
+			h.SetExpiry(items[i], time.UnixMilli(ttl))
+		}
+	}
+	return rd.liveHash(h), nil
+}
+
+// liveHash drops the expired fields of h, and h itself when none is left.
+func (rd *rdbReader) liveHash(h *hash) interface{} {
+	h.reclaimExpired(rd.now)
+	if h.Len() == 0 {
+		return nil
+	}
+	return h
+}
+
+func (rd *rdbReader) readStreamID() (streamID, error) {
+	ms, err := rd.readLen()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, err := rd.readLen()
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{ms, seq}, nil
+}
+
+// readRawStreamID reads an ID stored as two big endian integers, the way the
+// radix tree of redis keys its nodes and pending entries.
+func (rd *rdbReader) readRawStreamID() (streamID, error) {
+	b, err := rd.readBytes(16)
+	if err != nil {
+		return streamID{}, err
+	}
+	return rawStreamID(b)
+}
+
+func rawStreamID(b []byte) (streamID, error) {
+	if len(b) != 16 {
+		return streamID{}, errCorruptRDB
+	}
+	return streamID{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}, nil
+}
+
+// readStream reads a stream, its listpacks become the nodes of the stream.
+func (rd *rdbReader) readStream(valueType byte) (*stream, error) {
+	nodes, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	st := newStream()
+	for range nodes {
+		key, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		master, err := rawStreamID(key)
+		if err != nil {
+			return nil, err
+		}
+		items, err := readEncoded(rd, listpackEntries)
+		if err != nil {
+			return nil, err
+		}
+		node, err := streamNodeFrom(master, items)
+		if err != nil {
+			return nil, err
+		}
+		if len(node.entries) > 0 {
+			st.nodes = append(st.nodes, node)
+		}
+	}
+	length, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	st.length = int(length)
+	if st.lastID, err = rd.readStreamID(); err != nil {
+		return nil, err
+	}
+	if valueType >= rdbTypeStreamListpacks2 {
+		// the first ID is recomputed from the nodes
+		if _, err := rd.readStreamID(); err != nil {
+			return nil, err
+		}
+		if st.maxDeletedID, err = rd.readStreamID(); err != nil {
+			return nil, err
+		}
+		entriesAdded, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		st.entriesAdded = int64(entriesAdded)
+	} else {
+		st.entriesAdded = int64(length)
+	}
+
+	groups, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for range groups {
+		g, err := rd.readStreamGroup(st, valueType)
+		if err != nil {
+			return nil, err
+		}
+		st.groups[g.name] = g
+	}
+	return st, nil
+}
+
+// streamNodeFrom decodes the entries of a stream listpack. The listpack starts
+// with a master entry holding the count of live and deleted entries and the
+// fields of the first entry, then each entry holds its flags, its ID relative
+// to the master ID, its fields unless they are the master ones, its values and
+// the number of listpack items it spans.
+func streamNodeFrom(master streamID, items []string) (*streamNode, error) {
+	p := 0
+	next := func() (int64, error) {
+		if p >= len(items) {
+			return 0, errCorruptRDB
+		}
+		n, err := strconv.ParseInt(items[p], 10, 64)
+		if err != nil {
+			return 0, errCorruptRDB
+		}
+		p++
+		return n, nil
+	}
+	if len(items) == 0 {
+		return &streamNode{}, nil
+	}
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+	numFields, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if numFields < 0 || p+int(numFields) >= len(items) {
+		return nil, errCorruptRDB
+	}
+	masterFields := items[p : p+int(numFields)]
+	// skip the fields and the terminator of the master entry
+	p += int(numFields) + 1
+
+	node := &streamNode{}
+	for p < len(items) {
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			if p+len(masterFields) > len(items) {
+				return nil, errCorruptRDB
+			}
+			fields = make([]string, 0, 2*len(masterFields))
+			for i, field := range masterFields {
+				fields = append(fields, field, items[p+i])
+			}
+			p += len(masterFields)
+		} else {
+			n, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || p+2*int(n) > len(items) {
+				return nil, errCorruptRDB
+			}
+			fields = append([]string(nil), items[p:p+2*int(n)]...)
+			p += 2 * int(n)
+		}
+		// the number of items of the entry, used to walk backwards
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+		id := streamID{master.ms + uint64(msDiff), master.seq + uint64(seqDiff)}
+		deleted := flags&streamItemDeleted != 0
+		node.entries = append(node.entries, streamEntry{id: id, fields: fields, deleted: deleted})
+		if !deleted {
+			node.live++
+		}
+	}
+	if node.live == 0 {
+		node.entries = nil
+	}
+	return node, nil
+}
+
+func (rd *rdbReader) readStreamGroup(st *stream, valueType byte) (*streamGroup, error) {
+	name, err := rd.readString()
+	if err != nil {
+		return nil, err
+	}
+	lastID, err := rd.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	entriesRead := int64(-1)
+	if valueType >= rdbTypeStreamListpacks2 {
+		n, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		entriesRead = int64(n)
+	} else if lastID == st.lastID {
+		entriesRead = st.entriesAdded
+	}
+	g := newStreamGroup(string(name), lastID, entriesRead)
+
+	pending, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for range pending {
+		id, err := rd.readRawStreamID()
+		if err != nil {
+			return nil, err
+		}
+		deliveryTime, err := rd.readMillisecondTime()
+		if err != nil {
+			return nil, err
+		}
+		deliveryCount, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		g.pending[id] = &pendingEntry{deliveryTime: deliveryTime, deliveryCount: int64(deliveryCount)}
+	}
+
+	consumers, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for range consumers {
+		name, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		seenTime, err := rd.readMillisecondTime()
+		if err != nil {
+			return nil, err
+		}
+		activeTime := seenTime
+		if valueType >= rdbTypeStreamListpacks3 {
+			if activeTime, err = rd.readMillisecondTime(); err != nil {
+				return nil, err
+			}
+			// consumers that never read are stored as active at -1
+			if activeTime.UnixMilli() == -1 {
+				activeTime = time.Time{}
+			}
+		}
+		c := g.consumer(string(name), seenTime)
+		c.activeTime = activeTime
+		n, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		// the pending entries of the consumer point into the ones of the group
+		for range n {
+			id, err := rd.readRawStreamID()
+			if err != nil {
+				return nil, err
+			}
+			pe, ok := g.pending[id]
+			if !ok || pe.consumer != nil {
+				return nil, errCorruptRDB
+			}
+			pe.consumer = c
+			c.pending[id] = pe
+		}
+	}
+	for _, pe := range g.pending {
+		if pe.consumer == nil {
+			return nil, errCorruptRDB
+		}
+	}
+	return g, nil
 }
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The dumps of testdata/rdb were saved by real redis servers, from 2.x to 6.0.
+// They come from the redis-rdb-tools project, by way of the rdb-go module.
+
+func loadFixture(t *testing.T, name string) map[string]*Resource {
+	t.Helper()
+	items, err := ReadRedisDBFile(filepath.Join("testdata", "rdb", name+".rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+// checkKey fails when key is missing from items or when dumpValue of its value
+// differs from want.
+func checkKey(t *testing.T, items map[string]*Resource, key string, want interface{}) {
+	t.Helper()
+	res, ok := items[key]
+	if !ok {
+		t.Errorf("key %q missing", key)
+		return
+	}
+	if got := dumpValue(res.value); !reflect.DeepEqual(got, want) {
+		t.Errorf("key %q:\n got %v\nwant %v", key, got, want)
+	}
+}
+
+func TestReadRDBFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "rdb", "*.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures")
+	}
+	keys := map[string]int{
+		"big_values":                  4,
+		"bloom_filter":                0,
+		"dictionary":                  1,
+		"empty_database":              0,
+		"integer_keys":                6,
+		"keys_with_expiry":            0,
+		"multiple_databases":          1,
+		"non_ascii_values":            6,
+		"parser_filters":              43,
+		"rdb_version_5_with_checksum": 6,
+		"rdb_version_8_with_64b_length_and_scores": 2,
+		"redis_40_with_module":                     1,
+		"redis_50_with_streams":                    14,
+		"redis_60_with_module_aux":                 0,
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".rdb")
+		t.Run(name, func(t *testing.T) {
+			items := loadFixture(t, name)
+			if want, ok := keys[name]; ok && len(items) != want {
+				t.Errorf("loaded %d keys, want %d", len(items), want)
+			}
+			// whatever redis saved must survive being saved again
+			checkRoundTrip(t, items)
+		})
+	}
+}
+
+func TestReadRDBStrings(t *testing.T) {
+	items := loadFixture(t, "integer_keys")
+	for key, want := range map[string]string{
+		"125":        "Positive 8 bit integer",
+		"43947":      "Positive 16 bit integer",
+		"183358245":  "Positive 32 bit integer",
+		"-123":       "Negative 8 bit integer",
+		"-29477":     "Negative 16 bit integer",
+		"-183358245": "Negative 32 bit integer",
+	} {
+		checkKey(t, items, key, "string:"+want)
+	}
+
+	items = loadFixture(t, "easily_compressible_string_key")
+	checkKey(t, items, strings.Repeat("a", 200), "string:Key that redis should compress easily")
+
+	items = loadFixture(t, "non_ascii_values")
+	checkKey(t, items, "int_value", "int:123")
+	checkKey(t, items, "utf8", "string:בדיקה𐀏123עברית")
+	checkKey(t, items, "bin", "string:\x00$ ~0\x7f\xff\n\xaa\t\x80\rAb")
+
+	items = loadFixture(t, "rdb_version_5_with_checksum")
+	checkKey(t, items, "longerstring", "string:thisisalongerstring.idontknowwhatitmeans")
+
+	items = loadFixture(t, "big_values")
+	for _, key := range []string{"20bits", "40bits", "4095bits", "4097bits"} {
+		if res, ok := items[key]; !ok || len(res.value.([]byte)) == 0 {
+			t.Errorf("key %q missing", key)
+		}
+	}
+}
+
+func TestReadRDBLists(t *testing.T) {
+	checkKey(t, loadFixture(t, "ziplist_that_compresses_easily"), "ziplist_compresses_easily", []string{
+		strings.Repeat("a", 6), strings.Repeat("a", 12), strings.Repeat("a", 18),
+		strings.Repeat("a", 24), strings.Repeat("a", 30), strings.Repeat("a", 36),
+	})
+	checkKey(t, loadFixture(t, "ziplist_that_doesnt_compress"), "ziplist_doesnt_compress", []string{
+		"aj2410", "cc953a17a8e096e76a44169ad3f9ac87c5f8248a403274416179aa9fbd852344",
+	})
+	checkKey(t, loadFixture(t, "ziplist_with_integers"), "ziplist_with_integers", strings.Fields(
+		"0 1 2 3 4 5 6 7 8 9 10 11 12 -2 13 25 -61 63 16380 -16000 65535 -65523 4194304 9223372036854775807"))
+
+	var quicklist []string
+	for i := 0; i < 100; i++ {
+		quicklist = append(quicklist, "v"+strconv.Itoa(i))
+	}
+	checkKey(t, loadFixture(t, "quicklist"), "quicklist", quicklist)
+
+	if l := loadFixture(t, "linkedlist")["force_linkedlist"].value.(*deque); l.Len() != 1000 {
+		t.Errorf("force_linkedlist has %d items, want 1000", l.Len())
+	}
+}
+
+func TestReadRDBSets(t *testing.T) {
+	checkKey(t, loadFixture(t, "intset_16"), "intset_16",
+		map[string]interface{}{"intset": true, "members": []string{"32764", "32765", "32766"}})
+	checkKey(t, loadFixture(t, "intset_32"), "intset_32",
+		map[string]interface{}{"intset": true, "members": []string{"2147418108", "2147418109", "2147418110"}})
+	checkKey(t, loadFixture(t, "intset_64"), "intset_64",
+		map[string]interface{}{"intset": true, "members": []string{"9223090557583032316", "9223090557583032317", "9223090557583032318"}})
+	checkKey(t, loadFixture(t, "regular_set"), "regular_set",
+		map[string]interface{}{"intset": false, "members": []string{"alpha", "beta", "delta", "gamma", "kappa", "phi"}})
+}
+
+func TestReadRDBSortedSets(t *testing.T) {
+	checkKey(t, loadFixture(t, "sorted_set_as_ziplist"), "sorted_set_as_ziplist", []zsetEntry{
+		{"8b6ba6718a786daefa69438148361901", 1},
+		{"cb7a24bb7528f934b841b34c3a73e0c7", 2.37},
+		{"523af537946b79c4f8369ed39ba78605", 3.423},
+	})
+	if z := loadFixture(t, "regular_sorted_set")["force_sorted_set"].value.(*zset); z.Len() != 500 {
+		t.Errorf("force_sorted_set has %d members, want 500", z.Len())
+	}
+	items := loadFixture(t, "rdb_version_8_with_64b_length_and_scores")
+	z := items["bigset"].value.(*zset)
+	if score, ok := z.Score("key000000003055"); !ok || score != 1.618 {
+		t.Errorf("bigset score = %v %v, want 1.618", score, ok)
+	}
+}
+
+func TestReadRDBHashes(t *testing.T) {
+	noTTLs := map[string]int64{}
+	checkKey(t, loadFixture(t, "zipmap_that_compresses_easily"), "zipmap_compresses_easily", map[string]interface{}{
+		"compact": true, "fields": map[string]string{"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"}, "ttls": noTTLs,
+	})
+	checkKey(t, loadFixture(t, "zipmap_that_doesnt_compress"), "zimap_doesnt_compress", map[string]interface{}{
+		"compact": true, "fields": map[string]string{"MKD1G6": "2", "YNNXK": "F7TI"}, "ttls": noTTLs,
+	})
+	checkKey(t, loadFixture(t, "hash_as_ziplist"), "zipmap_compresses_easily", map[string]interface{}{
+		"compact": true, "fields": map[string]string{"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"}, "ttls": noTTLs,
+	})
+	if h := loadFixture(t, "dictionary")["force_dictionary"].value.(*hash); h.compact() || h.Len() != 1000 {
+		t.Errorf("force_dictionary has %d fields, compact %v, want 1000 in a dict", h.Len(), h.compact())
+	}
+}
+
+func TestReadRDBStreams(t *testing.T) {
+	items := loadFixture(t, "redis_50_with_streams")
+	st, ok := items["mystream"].value.(*stream)
+	if !ok {
+		t.Fatal("mystream is not a stream")
+	}
+	got := dumpStream(st)
+	want := map[string]interface{}{
+		"entries": []string{
+			"1528176919539-0 message apple",
+			"1528199037311-0 sensor-id 1234 temperature 19.8",
+			"1528199075689-0 sensor-id 12345 temperature 19.9",
+			"1528199178069-0 sensor-id 123456 temperature 19.10",
+		},
+		"length":       4,
+		"lastID":       "1528199178069-0",
+		"firstID":      "1528176919539-0",
+		"maxDeletedID": "0-0",
+		"entriesAdded": int64(4),
+	}
+	for field, value := range want {
+		if !reflect.DeepEqual(got[field], value) {
+			t.Errorf("%s = %v, want %v", field, got[field], value)
+		}
+	}
+	if len(st.groups) == 0 {
+		t.Error("consumer groups missing")
+	}
+	checkKey(t, items, "list_zipped", strings.Fields("1 2 3 a b c 100000 6000000000"))
+}
+
+func TestReadRDBSkipsOtherDatabases(t *testing.T) {
+	items := loadFixture(t, "multiple_databases")
+	checkKey(t, items, "key_in_zeroth_database", "string:zero")
+	if _, ok := items["key_in_second_database"]; ok {
+		t.Error("key of database 2 loaded")
+	}
+}
+
+func TestReadRDBDropsExpiredKeys(t *testing.T) {
+	if items := loadFixture(t, "keys_with_expiry"); len(items) != 0 {
+		t.Errorf("loaded %d expired keys", len(items))
+	}
+}
@@ -0,0 +1,485 @@
+package app
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rdbVersion is the format written, the one of redis 7.2. Dumps holding
+	// hashes with field ttls are written in the format of redis 7.4 instead,
+	// the first one able to store them.
+	rdbVersion          = 11
+	rdbVersionFieldTTLs = 12
+
+	// rdbRedisVersion is the version of redis the dumps claim to come from
+	rdbRedisVersion = "7.2.0"
+
+	// listpacks of quicklist nodes are cut once they reach this size, the
+	// default list-max-listpack-size of redis
+	rdbListpackNodeSize = 8 << 10
+)
+
+// WriteRedisDBFile writes items to an RDB file. The dump is written to a
+// temporary file in the same directory, which then replaces filename, so an
+// existing dump is never left half written.
+func WriteRedisDBFile(filename string, items map[string]*Resource) error {
+	return replaceFile(filename, "RDB", func(w io.Writer) error {
+		return writeRDB(w, items, false)
+	})
+}
+
+// replaceFile writes filename through write, into a temporary file renamed
+// over filename once complete and synced. kind names the file in errors.
+func replaceFile(filename, kind string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf("temp-%d-*%s", os.Getpid(), filepath.Ext(filename)))
+	if err != nil {
+		return fmt.Errorf("could not create temporary %s file: %v", kind, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write %s file: %v", kind, err)
+	}
+	// temporary files are private, dumps get the usual permissions
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not chmod %s file: %v", kind, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync %s file: %v", kind, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close %s file: %v", kind, err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("could not rename %s file: %v", kind, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes the creations and renames of files in dir durable.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// writeRDB serializes items in the RDB format, aux fields first, then the keys
+// of database 0, then the checksum. aofBase marks the dump as the base of an
+// append only file.
+func writeRDB(w io.Writer, items map[string]*Resource, aofBase bool) error {
+	wr := newRDBWriter(w)
+	version := rdbVersion
+	expires := 0
+	for _, res := range items {
+		if h, ok := res.value.(*hash); ok && h.volatile() {
+			version = rdbVersionFieldTTLs
+		}
+		if res.expired != nil {
+			expires++
+		}
+	}
+	wr.write([]byte(fmt.Sprintf("REDIS%04d", version)))
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	wr.writeAux("redis-ver", rdbRedisVersion)
+	wr.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	wr.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	wr.writeAux("used-mem", strconv.FormatUint(mem.Alloc, 10))
+	if aofBase {
+		wr.writeAux("aof-base", "1")
+	} else {
+		wr.writeAux("aof-base", "0")
+	}
+
+	if len(items) > 0 {
+		wr.writeByte(rdbOpSelectDB)
+		wr.writeLen(0)
+		wr.writeByte(rdbOpResizeDB)
+		wr.writeLen(uint64(len(items)))
+		wr.writeLen(uint64(expires))
+	}
+	for key, res := range items {
+		if h, ok := res.value.(*hash); ok && h.Len() == 0 {
+			// every field expired since the snapshot
+			continue
+		}
+		if res.expired != nil {
+			wr.writeByte(rdbOpExpireTimeMs)
+			wr.writeUint64(uint64(res.expired.UnixMilli()))
+		}
+		wr.writeValue(key, res.value)
+		if wr.err != nil {
+			return wr.err
+		}
+	}
+	wr.writeByte(rdbOpEOF)
+	// the checksum covers everything before it
+	crc := wr.crc
+	wr.writeUint64(crc)
+	if wr.err != nil {
+		return wr.err
+	}
+	return wr.w.Flush()
+}
+
+// rdbWriter encodes the RDB format, keeping the checksum of what it wrote.
+// The first error is kept and makes later writes no-ops.
+type rdbWriter struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func newRDBWriter(w io.Writer) *rdbWriter {
+	return &rdbWriter{w: bufio.NewWriter(w)}
+}
+
+func (wr *rdbWriter) write(p []byte) {
+	if wr.err != nil {
+		return
+	}
+	wr.crc = rdbChecksum(wr.crc, p)
+	_, wr.err = wr.w.Write(p)
+}
+
+func (wr *rdbWriter) writeByte(b byte) {
+	wr.write([]byte{b})
+}
+
+func (wr *rdbWriter) writeUint64(n uint64) {
+	wr.write(binary.LittleEndian.AppendUint64(nil, n))
+}
+
+func (wr *rdbWriter) writeMillisecondTime(t time.Time) {
+	wr.writeUint64(uint64(t.UnixMilli()))
+}
+
+func (wr *rdbWriter) writeLen(n uint64) {
+	var b []byte
+	switch {
+	case n < 1<<6:
+		b = []byte{byte(n)}
+	case n < 1<<14:
+		b = []byte{0x40 | byte(n>>8), byte(n)}
+	case n <= math.MaxUint32:
+		b = binary.BigEndian.AppendUint32([]byte{0x80}, uint32(n))
+	default:
+		b = binary.BigEndian.AppendUint64([]byte{0x81}, n)
+	}
+	wr.write(b)
+}
+
+// writeString writes a string, as an integer when it is the canonical form of
+// one that fits 32 bits, compressed when it is long and compresses well.
+func (wr *rdbWriter) writeString(s []byte) {
+	if len(s) <= 11 {
+		if n, ok := parseCanonicalInt(string(s)); ok && wr.writeInt(n) {
+			return
+		}
+	}
+	if len(s) > 20 {
+		if compressed, ok := lzfCompress(s); ok {
+			wr.writeByte(0xc0 | rdbEncLZF)
+			wr.writeLen(uint64(len(compressed)))
+			wr.writeLen(uint64(len(s)))
+			wr.write(compressed)
+			return
+		}
+	}
+	wr.writeLen(uint64(len(s)))
+	wr.write(s)
+}
+
+// writeInt writes n with the integer encoding of strings and reports false
+// when it doesn't fit one.
+func (wr *rdbWriter) writeInt(n int64) bool {
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		wr.write([]byte{0xc0 | rdbEncInt8, byte(n)})
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		wr.write(binary.LittleEndian.AppendUint16([]byte{0xc0 | rdbEncInt16}, uint16(n)))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		wr.write(binary.LittleEndian.AppendUint32([]byte{0xc0 | rdbEncInt32}, uint32(n)))
+	default:
+		return false
+	}
+	return true
+}
+
+func (wr *rdbWriter) writeAux(key, value string) {
+	wr.writeByte(rdbOpAux)
+	wr.writeString([]byte(key))
+	wr.writeString([]byte(value))
+}
+
+func (wr *rdbWriter) writeValue(key string, value interface{}) {
+	switch v := value.(type) {
+	case []byte:
+		wr.writeByte(rdbTypeString)
+		wr.writeString([]byte(key))
+		wr.writeString(v)
+	case int64:
+		wr.writeByte(rdbTypeString)
+		wr.writeString([]byte(key))
+		if !wr.writeInt(v) {
+			wr.writeString(strconv.AppendInt(nil, v, 10))
+		}
+	case *deque:
+		wr.writeByte(rdbTypeListQuicklist2)
+		wr.writeString([]byte(key))
+		wr.writeList(v)
+	case *set:
+		if v.intset() {
+			wr.writeByte(rdbTypeSetIntset)
+			wr.writeString([]byte(key))
+			wr.writeString(intsetBytes(v.ints))
+			return
+		}
+		wr.writeByte(rdbTypeSet)
+		wr.writeString([]byte(key))
+		wr.writeLen(uint64(v.Len()))
+		for member := range v.dict {
+			wr.writeString([]byte(member))
+		}
+	case *zset:
+		wr.writeByte(rdbTypeZSet2)
+		wr.writeString([]byte(key))
+		wr.writeLen(uint64(v.Len()))
+		// redis writes the highest scores first so its loader only prepends
+		for _, e := range v.RangeByRank(0, v.Len()-1, true) {
+			wr.writeString([]byte(e.member))
+			wr.writeUint64(math.Float64bits(e.score))
+		}
+	case *hash:
+		wr.writeHash(key, v)
+	case *stream:
+		wr.writeByte(rdbTypeStreamListpacks3)
+		wr.writeString([]byte(key))
+		wr.writeStream(v)
+	default:
+		wr.err = fmt.Errorf("cannot save key %q of type %T", key, value)
+	}
+}
+
+// writeList writes a quicklist of listpack nodes.
+func (wr *rdbWriter) writeList(d *deque) {
+	var nodes []*listpack
+	var node *listpack
+	for i := 0; i < d.Len(); i++ {
+		if node == nil || node.Len() >= rdbListpackNodeSize {
+			node = newListpack()
+			nodes = append(nodes, node)
+		}
+		node.Append(d.At(i))
+	}
+	wr.writeLen(uint64(len(nodes)))
+	for _, node := range nodes {
+		wr.writeLen(quicklistNodePacked)
+		wr.writeString(node.Bytes())
+	}
+}
+
+// writeHash writes a hash as a listpack when it is compact, as a dict
+// otherwise. Hashes with field ttls have their own types, which store the
+// smallest ttl first so that redis can index the key without decoding it.
+func (wr *rdbWriter) writeHash(key string, h *hash) {
+	now := time.Now()
+	var fields, values []string
+	h.forEach(func(field, value string) bool {
+		fields = append(fields, field)
+		values = append(values, value)
+		return true
+	})
+	minExpire := int64(math.MaxInt64)
+	for field, deadline := range h.expires {
+		if !h.fieldExpired(field, now) {
+			minExpire = min(minExpire, deadline.UnixMilli())
+		}
+	}
+	volatile := minExpire != math.MaxInt64
+	ttl := func(field string) int64 {
+		deadline, ok := h.Expiry(field)
+		if !ok {
+			return 0
+		}
+		return deadline.UnixMilli()
+	}
+
+	switch {
+	case h.compact() && !volatile:
+		wr.writeByte(rdbTypeHashListpack)
+		wr.writeString([]byte(key))
+		lp := newListpack()
+		for i, field := range fields {
+			lp.Append(field)
+			lp.Append(values[i])
+		}
+		wr.writeString(lp.Bytes())
+	case h.compact():
+		wr.writeByte(rdbTypeHashListpackEx)
+		wr.writeString([]byte(key))
+		wr.writeUint64(uint64(minExpire))
+		lp := newListpack()
+		for i, field := range fields {
+			lp.Append(field)
+			lp.Append(values[i])
+			lp.Append(strconv.FormatInt(ttl(field), 10))
+		}
+		wr.writeString(lp.Bytes())
+	case !volatile:
+		wr.writeByte(rdbTypeHash)
+		wr.writeString([]byte(key))
+		wr.writeLen(uint64(len(fields)))
+		for i, field := range fields {
+			wr.writeString([]byte(field))
+			wr.writeString([]byte(values[i]))
+		}
+	default:
+		wr.writeByte(rdbTypeHashMetadata)
+		wr.writeString([]byte(key))
+		wr.writeUint64(uint64(minExpire))
+		wr.writeLen(uint64(len(fields)))
+		for i, field := range fields {
+			// ttls are relative to the smallest one, plus one, zero meaning none
+			if deadline := ttl(field); deadline != 0 {
+				wr.writeLen(uint64(deadline - minExpire + 1))
+			} else {
+				wr.writeLen(0)
+			}
+			wr.writeString([]byte(field))
+			wr.writeString([]byte(values[i]))
+		}
+	}
+}
+
+func (wr *rdbWriter) writeStreamID(id streamID) {
+	wr.writeLen(id.ms)
+	wr.writeLen(id.seq)
+}
+
+func rawStreamIDBytes(id streamID) []byte {
+	b := binary.BigEndian.AppendUint64(nil, id.ms)
+	return binary.BigEndian.AppendUint64(b, id.seq)
+}
+
+// writeStream writes each node of the stream as a listpack keyed by the ID of
+// its first entry, then the metadata and the consumer groups.
+func (wr *rdbWriter) writeStream(st *stream) {
+	wr.writeLen(uint64(len(st.nodes)))
+	for _, node := range st.nodes {
+		master := node.entries[0].id
+		wr.writeString(rawStreamIDBytes(master))
+		wr.writeString(streamNodeListpack(master, node))
+	}
+	wr.writeLen(uint64(st.length))
+	wr.writeStreamID(st.lastID)
+	wr.writeStreamID(st.firstID())
+	wr.writeStreamID(st.maxDeletedID)
+	wr.writeLen(uint64(st.entriesAdded))
+
+	groups := st.sortedGroups()
+	wr.writeLen(uint64(len(groups)))
+	for _, g := range groups {
+		wr.writeString([]byte(g.name))
+		wr.writeStreamID(g.lastID)
+		// an unknown count is stored as -1
+		wr.writeLen(uint64(g.entriesRead))
+		ids := sortedIDs(g.pending)
+		wr.writeLen(uint64(len(ids)))
+		for _, id := range ids {
+			pe := g.pending[id]
+			wr.write(rawStreamIDBytes(id))
+			wr.writeMillisecondTime(pe.deliveryTime)
+			wr.writeLen(uint64(pe.deliveryCount))
+		}
+		consumers := make([]*streamConsumer, 0, len(g.consumers))
+		for _, c := range g.consumers {
+			consumers = append(consumers, c)
+		}
+		slices.SortFunc(consumers, func(a, b *streamConsumer) int {
+			return strings.Compare(a.name, b.name)
+		})
+		wr.writeLen(uint64(len(consumers)))
+		for _, c := range consumers {
+			wr.writeString([]byte(c.name))
+			wr.writeMillisecondTime(c.seenTime)
+			if c.activeTime.IsZero() {
+				// consumers that never read are stored as active at -1
+				wr.writeUint64(math.MaxUint64)
+			} else {
+				wr.writeMillisecondTime(c.activeTime)
+			}
+			ids := sortedIDs(c.pending)
+			wr.writeLen(uint64(len(ids)))
+			for _, id := range ids {
+				wr.write(rawStreamIDBytes(id))
+			}
+		}
+	}
+}
+
+// streamNodeListpack encodes a stream node the way streamNodeFrom decodes it,
+// the fields of the first entry becoming the master fields.
+func streamNodeListpack(master streamID, node *streamNode) []byte {
+	lp := newListpack()
+	masterFields := make([]string, 0, len(node.entries[0].fields)/2)
+	for i := 0; i < len(node.entries[0].fields); i += 2 {
+		masterFields = append(masterFields, node.entries[0].fields[i])
+	}
+	lp.Append(strconv.Itoa(node.live))
+	lp.Append(strconv.Itoa(len(node.entries) - node.live))
+	lp.Append(strconv.Itoa(len(masterFields)))
+	for _, field := range masterFields {
+		lp.Append(field)
+	}
+	lp.Append("0")
+
+	for _, e := range node.entries {
+		sameFields := len(e.fields) == 2*len(masterFields)
+		for i := 0; sameFields && i < len(masterFields); i++ {
+			sameFields = e.fields[2*i] == masterFields[i]
+		}
+		flags := 0
+		if e.deleted {
+			flags |= streamItemDeleted
+		}
+		if sameFields {
+			flags |= streamItemSameFields
+		}
+		lp.Append(strconv.Itoa(flags))
+		// the sequence may be lower than the master one, the difference wraps
+		lp.Append(strconv.FormatInt(int64(e.id.ms-master.ms), 10))
+		lp.Append(strconv.FormatInt(int64(e.id.seq-master.seq), 10))
+		if sameFields {
+			for i := 1; i < len(e.fields); i += 2 {
+				lp.Append(e.fields[i])
+			}
+			lp.Append(strconv.Itoa(len(masterFields) + 3))
+			continue
+		}
+		lp.Append(strconv.Itoa(len(e.fields) / 2))
+		for _, item := range e.fields {
+			lp.Append(item)
+		}
+		lp.Append(strconv.Itoa(len(e.fields) + 1 + 3))
+	}
+	return lp.Bytes()
+}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTrip writes items as an RDB dump, then loads it back. It returns the
+// loaded keys along with the version of the dump.
+func roundTrip(t *testing.T, items map[string]*Resource) (map[string]*Resource, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, items, false); err != nil {
+		t.Fatalf("writeRDB: %v", err)
+	}
+	version := string(buf.Bytes()[5:9])
+	loaded, err := newRDBReader(&buf).load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return loaded, version
+}
+
+// dumpValue returns a comparable description of a stored value: its content,
+// its encoding and the metadata the dump must keep. Times are in
+// milliseconds, the precision of the dumps.
+func dumpValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return "string:" + string(v)
+	case int64:
+		return "int:" + strconv.FormatInt(v, 10)
+	case *deque:
+		return v.Range(0, v.Len()-1)
+	case *set:
+		members := v.Members()
+		slices.Sort(members)
+		return map[string]interface{}{"intset": v.intset(), "members": members}
+	case *zset:
+		return v.RangeByRank(0, v.Len()-1, false)
+	case *hash:
+		fields := map[string]string{}
+		ttls := map[string]int64{}
+		v.forEach(func(field, value string) bool {
+			fields[field] = value
+			if deadline, ok := v.Expiry(field); ok {
+				ttls[field] = deadline.UnixMilli()
+			}
+			return true
+		})
+		return map[string]interface{}{"compact": v.compact(), "fields": fields, "ttls": ttls}
+	case *stream:
+		return dumpStream(v)
+	}
+	return value
+}
+
+func dumpStream(st *stream) map[string]interface{} {
+	var entries []string
+	for _, e := range st.Range(streamID{}, maxStreamID, 0, false) {
+		entries = append(entries, e.id.String()+" "+strings.Join(e.fields, " "))
+	}
+	var groups []string
+	for _, g := range st.sortedGroups() {
+		groups = append(groups, g.name+" "+g.lastID.String()+" "+strconv.FormatInt(g.entriesRead, 10))
+		for _, id := range sortedIDs(g.pending) {
+			pe := g.pending[id]
+			groups = append(groups, "  pending "+id.String()+" "+pe.consumer.name+" "+
+				strconv.FormatInt(pe.deliveryTime.UnixMilli(), 10)+" "+strconv.FormatInt(pe.deliveryCount, 10))
+		}
+		for _, c := range g.consumers {
+			active := "never"
+			if !c.activeTime.IsZero() {
+				active = strconv.FormatInt(c.activeTime.UnixMilli(), 10)
+			}
+			consumer := "  consumer " + c.name + " " + strconv.FormatInt(c.seenTime.UnixMilli(), 10) + " " + active
+			for _, id := range sortedIDs(c.pending) {
+				consumer += " " + id.String()
+			}
+			groups = append(groups, consumer)
+		}
+		slices.Sort(groups)
+	}
+	return map[string]interface{}{
+		"entries":      entries,
+		"length":       st.Len(),
+		"lastID":       st.lastID.String(),
+		"firstID":      st.firstID().String(),
+		"maxDeletedID": st.maxDeletedID.String(),
+		"entriesAdded": st.entriesAdded,
+		"groups":       groups,
+	}
+}
+
+// checkRoundTrip fails when the keys loaded from the dump of items differ from
+// items.
+func checkRoundTrip(t *testing.T, items map[string]*Resource) string {
+	t.Helper()
+	loaded, version := roundTrip(t, items)
+	if len(loaded) != len(items) {
+		t.Errorf("loaded %d keys, want %d", len(loaded), len(items))
+	}
+	for key, res := range items {
+		got, ok := loaded[key]
+		if !ok {
+			t.Errorf("key %q missing", key)
+			continue
+		}
+		if want, got := dumpValue(res.value), dumpValue(got.value); !reflect.DeepEqual(got, want) {
+			t.Errorf("key %q:\n got %v\nwant %v", key, got, want)
+		}
+		switch {
+		case res.expired == nil && got.expired != nil:
+			t.Errorf("key %q: got expiry %v, want none", key, got.expired)
+		case res.expired != nil && (got.expired == nil || !got.expired.Equal(*res.expired)):
+			t.Errorf("key %q: got expiry %v, want %v", key, got.expired, res.expired)
+		}
+	}
+	return version
+}
+
+// future returns a deadline in the future, rounded to the millisecond.
+func future(d time.Duration) time.Time {
+	return time.UnixMilli(time.Now().Add(d).UnixMilli())
+}
+
+func TestRDBRoundTripStrings(t *testing.T) {
+	random := make([]byte, 300)
+	rand.New(rand.NewSource(1)).Read(random)
+	items := map[string]*Resource{
+		"empty":        {value: []byte{}},
+		"short":        {value: []byte("hello")},
+		"binary":       {value: []byte("\x00\xff\r\n")},
+		"compressible": {value: bytes.Repeat([]byte("abc"), 1000)},
+		"random":       {value: random},
+		"huge":         {value: bytes.Repeat([]byte("x"), 70000)},
+		"leading zero": {value: []byte("0123")},
+		"":             {value: []byte("empty key")},
+	}
+	for _, n := range []int64{0, -1, math.MinInt8, math.MaxInt8, math.MinInt16, math.MaxInt16,
+		math.MinInt32, math.MaxInt32, math.MaxInt32 + 1, math.MinInt64, math.MaxInt64} {
+		items["int "+strconv.FormatInt(n, 10)] = &Resource{value: n}
+	}
+	deadline := future(time.Hour)
+	items["volatile"] = &Resource{value: []byte("v"), expired: &deadline}
+	if version := checkRoundTrip(t, items); version != "0011" {
+		t.Errorf("version = %s, want 0011", version)
+	}
+}
+
+func TestRDBRoundTripLists(t *testing.T) {
+	small := newDeque()
+	for _, v := range []string{"a", "1", "-5000", "9223372036854775807", ""} {
+		small.PushBack(v)
+	}
+	// enough items for several quicklist nodes, some of them compressible
+	large := newDeque()
+	for i := 0; i < 5000; i++ {
+		switch i % 3 {
+		case 0:
+			large.PushBack(strconv.Itoa(i * 7919))
+		case 1:
+			large.PushBack(strings.Repeat("item", i%50))
+		default:
+			large.PushBack("value-" + strconv.Itoa(i))
+		}
+	}
+	big := newDeque()
+	big.PushBack(strings.Repeat("z", 20000))
+	big.PushBack("tail")
+	checkRoundTrip(t, map[string]*Resource{
+		"small": {value: small},
+		"large": {value: large},
+		"big":   {value: big},
+	})
+}
+
+func TestRDBRoundTripSets(t *testing.T) {
+	ints16, ints32, ints64 := newSet(), newSet(), newSet()
+	for _, n := range []int64{-3, 0, 7, math.MaxInt16} {
+		ints16.Add(strconv.FormatInt(n, 10))
+	}
+	for _, n := range []int64{-3, math.MaxInt32} {
+		ints32.Add(strconv.FormatInt(n, 10))
+	}
+	for _, n := range []int64{math.MinInt64, 1, math.MaxInt64} {
+		ints64.Add(strconv.FormatInt(n, 10))
+	}
+	dict := newSet()
+	for i := 0; i < 1000; i++ {
+		dict.Add("member-" + strconv.Itoa(i))
+	}
+	dict.Add("42")
+	checkRoundTrip(t, map[string]*Resource{
+		"ints16": {value: ints16},
+		"ints32": {value: ints32},
+		"ints64": {value: ints64},
+		"dict":   {value: dict},
+	})
+}
+
+func TestRDBRoundTripSortedSets(t *testing.T) {
+	z := newZSet()
+	z.Set("low", math.Inf(-1))
+	z.Set("high", math.Inf(1))
+	z.Set("zero", 0)
+	z.Set("fraction", 0.1)
+	z.Set("negative", -2.5e-300)
+	z.Set("same score a", 3)
+	z.Set("same score b", 3)
+	large := newZSet()
+	for i := 0; i < 1000; i++ {
+		large.Set("m"+strconv.Itoa(i), float64(i%17)/3)
+	}
+	checkRoundTrip(t, map[string]*Resource{
+		"z":     {value: z},
+		"large": {value: large},
+	})
+}
+
+func TestRDBRoundTripHashes(t *testing.T) {
+	compact := newHash()
+	compact.Set("a", "1")
+	compact.Set("b", "")
+	compact.Set("n", "-12345678901")
+	dict := newHash()
+	dict.convert()
+	for i := 0; i < 1000; i++ {
+		dict.Set("field-"+strconv.Itoa(i), strings.Repeat("v", i%100))
+	}
+	items := map[string]*Resource{
+		"compact": {value: compact},
+		"dict":    {value: dict},
+	}
+	if version := checkRoundTrip(t, items); version != "0011" {
+		t.Errorf("version = %s, want 0011", version)
+	}
+}
+
+func TestRDBRoundTripHashFieldTTLs(t *testing.T) {
+	soon, later := future(time.Hour), future(48*time.Hour)
+	compact := newHash()
+	compact.Set("a", "1")
+	compact.Set("b", "2")
+	compact.Set("c", "3")
+	compact.SetExpiry("a", later)
+	compact.SetExpiry("b", soon)
+	dict := newHash()
+	dict.convert()
+	for i := 0; i < 500; i++ {
+		field := "field-" + strconv.Itoa(i)
+		dict.Set(field, strconv.Itoa(i))
+		if i%3 == 0 {
+			dict.SetExpiry(field, soon.Add(time.Duration(i)*time.Second))
+		}
+	}
+	items := map[string]*Resource{
+		"compact": {value: compact},
+		"dict":    {value: dict},
+		"plain":   {value: []byte("x")},
+	}
+	// field ttls need the format of redis 7.4
+	if version := checkRoundTrip(t, items); version != "0012" {
+		t.Errorf("version = %s, want 0012", version)
+	}
+}
+
+func TestRDBRoundTripStreams(t *testing.T) {
+	const nodeMaxEntries = 4
+	st := newStream()
+	for i := uint64(1); i <= 30; i++ {
+		fields := []string{"temp", strconv.FormatUint(i*3, 10), "city", "rome"}
+		switch {
+		case i%7 == 0:
+			// other fields than the master entry of the node
+			fields = []string{"other", "field", "count", "-12"}
+		case i%5 == 0:
+			fields = []string{"temp", strconv.FormatUint(i, 10)}
+		}
+		st.Add(streamID{1700000000000 + i/3, i % 3}, fields, nodeMaxEntries)
+	}
+	// tombstones, including the first entry of a node and a whole node
+	for _, id := range []streamID{{1700000000001, 1}, {1700000000002, 2}, {1700000000003, 0}, {1700000000003, 1}, {1700000000003, 2}, {1700000000004, 0}} {
+		if !st.Delete(id) {
+			t.Fatalf("no entry %v", id)
+		}
+	}
+	now := time.UnixMilli(time.Now().UnixMilli())
+	g := newStreamGroup("workers", streamID{1700000000006, 0}, 15)
+	alice := g.consumer("alice", now)
+	alice.activeTime = now
+	for _, id := range []streamID{{1700000000001, 0}, {1700000000005, 1}} {
+		pe := g.deliver(id, alice, now.Add(-time.Minute))
+		pe.deliveryCount = 3
+	}
+	bob := g.consumer("bob", now.Add(-time.Hour))
+	g.deliver(streamID{1700000000006, 0}, bob, now).deliveryCount = 1
+	g.consumer("idle", now)
+	st.groups[g.name] = g
+	st.groups["unknown lag"] = newStreamGroup("unknown lag", streamID{}, -1)
+
+	empty := newStream()
+	empty.Add(streamID{5, 5}, []string{"a", "b"}, nodeMaxEntries)
+	empty.Delete(streamID{5, 5})
+	empty.groups["g"] = newStreamGroup("g", streamID{5, 5}, 1)
+
+	checkRoundTrip(t, map[string]*Resource{
+		"stream": {value: st},
+		"empty":  {value: empty},
+		"new":    {value: newStream()},
+	})
+}
+
+func TestRDBSkipsExpiredKeysAndFields(t *testing.T) {
+	past := time.UnixMilli(time.Now().Add(-time.Hour).UnixMilli())
+	h := newHash()
+	h.Set("live", "1")
+	h.Set("dead", "2")
+	h.SetExpiry("dead", past)
+	loaded, _ := roundTrip(t, map[string]*Resource{
+		"expired": {value: []byte("x"), expired: &past},
+		"hash":    {value: h},
+	})
+	if _, ok := loaded["expired"]; ok {
+		t.Error("expired key loaded")
+	}
+	got, ok := loaded["hash"].value.(*hash)
+	if !ok {
+		t.Fatalf("hash not loaded")
+	}
+	if _, ok := got.Get("dead"); ok || got.Len() != 1 {
+		t.Errorf("loaded hash has %d fields, want only the live one", got.Len())
+	}
+}
+
+func TestRDBRoundTripEmpty(t *testing.T) {
+	loaded, version := roundTrip(t, map[string]*Resource{})
+	if len(loaded) != 0 {
+		t.Errorf("loaded %d keys from an empty dump", len(loaded))
+	}
+	if version != "0011" {
+		t.Errorf("version = %s, want 0011", version)
+	}
+}
+
+func TestRDBWrongChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRDB(&buf, map[string]*Resource{"k": {value: []byte("v")}}, false); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	b[len(b)-1] ^= 0xff
+	if _, err := newRDBReader(bytes.NewReader(b)).load(); err == nil {
+		t.Error("dump with a wrong checksum loaded")
+	}
+}
@@ -0,0 +1,493 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var (
+	errGeoUnit         = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+	errGeoNoMember     = errors.New("ERR could not decode requested zset member")
+	errGeoCount        = errors.New("ERR COUNT must be > 0")
+	errGeoAnyCount     = errors.New("ERR the ANY argument requires COUNT argument")
+	errGeoRadius       = errors.New("ERR radius cannot be negative")
+	errGeoBoxSize      = errors.New("ERR height or width cannot be negative")
+	errGeoFrom         = errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	errGeoBy           = errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	errGeoStoreOptions = errors.New("ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "GEOADD",
+			group:    "geo",
+			summary:  "Adds one or more members to a geospatial index. The key is created if it doesn't exist.",
+			arity:    -5,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGeoAdd,
+		},
+		&commandSpec{
+			name:     "GEOPOS",
+			group:    "geo",
+			summary:  "Returns the longitude and latitude of members from a geospatial index.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGeoPos,
+		},
+		&commandSpec{
+			name:     "GEODIST",
+			group:    "geo",
+			summary:  "Returns the distance between two members of a geospatial index.",
+			arity:    -4,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGeoDist,
+		},
+		&commandSpec{
+			name:     "GEOHASH",
+			group:    "geo",
+			summary:  "Returns members from a geospatial index as geohash strings.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGeoHash,
+		},
+		&commandSpec{
+			name:     "GEOSEARCH",
+			group:    "geo",
+			summary:  "Queries a geospatial index for members inside an area of a box or a circle.",
+			arity:    -7,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGeoSearch,
+		},
+		&commandSpec{
+			name:     "GEOSEARCHSTORE",
+			group:    "geo",
+			summary:  "Queries a geospatial index for members inside an area of a box or a circle, optionally stores the result.",
+			arity:    -8,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleGeoSearch,
+		},
+	)
+}
+
+// parseLonLat parses a longitude latitude pair, which must be within the
+// range that can be indexed.
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, err := parseFloat(lonArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	lat, err := parseFloat(latArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !validLonLat(lon, lat) {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// parseGeoUnit returns the number of meters in a distance unit.
+func parseGeoUnit(arg string) (float64, error) {
+	switch strings.ToLower(arg) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errGeoUnit
+}
+
+func formatDistance(meters, conversion float64) string {
+	return strconv.FormatFloat(meters/conversion, 'f', 4, 64)
+}
+
+func coordinatesReply(lon, lat float64) resp.Value {
+	return resp.BulkStrings([]string{formatCoordinate(lon), formatCoordinate(lat)})
+}
+
+// handleGeoAdd indexes points in a sorted set through ZADD, GEOADD takes the
+// NX, XX and CH options of ZADD.
+func (s *server) handleGeoAdd(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	var opts zaddOptions
+	i := 1
+loop:
+	for ; i < len(req.Args); i++ {
+		switch strings.ToUpper(req.Args[i]) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "CH":
+			opts.ch = true
+		default:
+			break loop
+		}
+	}
+	triples := req.Args[i:]
+	if opts.nx && opts.xx {
+		return nil, errors.New("ERR XX and NX options at the same time are not compatible")
+	}
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return nil, errSyntax
+	}
+	scores := make([]float64, 0, len(triples)/3)
+	for i := 0; i < len(triples); i += 3 {
+		lon, lat, err := parseLonLat(triples[i], triples[i+1])
+		if err != nil {
+			return nil, err
+		}
+		score, _ := geoScore(lon, lat)
+		scores = append(scores, score)
+	}
+	z, exists, err := s.lookupZSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if opts.xx {
+			return reply(resp.Integer(0))
+		}
+		z = newZSet()
+		s.InMemoryStore.set(key, &Resource{value: z})
+	}
+	changed, updated := 0, 0
+	for i, score := range scores {
+		_, outcome, _ := zadd(z, triples[3*i+2], score, opts)
+		if outcome == zaddAdded || (opts.ch && outcome == zaddUpdated) {
+			changed++
+		}
+		if outcome == zaddAdded || outcome == zaddUpdated {
+			updated++
+		}
+	}
+	if z.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	} else if changed > 0 {
+		s.signalKeyReady(key)
+	}
+	s.addDirty(updated)
+	return reply(resp.Integer(int64(changed)))
+}
+
+func (s *server) handleGeoPos(req *Request) ([]resp.Value, error) {
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	values := make([]resp.Value, 0, len(req.Args)-1)
+	for _, member := range req.Args[1:] {
+		var score float64
+		var ok bool
+		if exists {
+			score, ok = z.Score(member)
+		}
+		if !ok {
+			values = append(values, resp.NullArray())
+			continue
+		}
+		values = append(values, coordinatesReply(geoDecodeScore(score)))
+	}
+	return reply(resp.Array(values...))
+}
+
+func (s *server) handleGeoDist(req *Request) ([]resp.Value, error) {
+	conversion := 1.0
+	switch len(req.Args) {
+	case 3:
+	case 4:
+		var err error
+		if conversion, err = parseGeoUnit(req.Args[3]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errSyntax
+	}
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.NullBulk())
+	}
+	score1, ok1 := z.Score(req.Args[1])
+	score2, ok2 := z.Score(req.Args[2])
+	if !ok1 || !ok2 {
+		return reply(resp.NullBulk())
+	}
+	lon1, lat1 := geoDecodeScore(score1)
+	lon2, lat2 := geoDecodeScore(score2)
+	return reply(resp.BulkString(formatDistance(geoDistance(lon1, lat1, lon2, lat2), conversion)))
+}
+
+func (s *server) handleGeoHash(req *Request) ([]resp.Value, error) {
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	values := make([]resp.Value, 0, len(req.Args)-1)
+	for _, member := range req.Args[1:] {
+		var score float64
+		var ok bool
+		if exists {
+			score, ok = z.Score(member)
+		}
+		if !ok {
+			values = append(values, resp.NullBulk())
+			continue
+		}
+		values = append(values, resp.BulkString(geohashString(score)))
+	}
+	return reply(resp.Array(values...))
+}
+
+// geoQuery holds the arguments of GEOSEARCH and GEOSEARCHSTORE.
+type geoQuery struct {
+	fromMember string
+	fromLonLat bool
+	shape      geoShape
+	byRadius   bool
+	desc, asc  bool
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeDist  bool
+}
+
+func parseGeoSearch(args []string, store bool) (geoQuery, error) {
+	var q geoQuery
+	hasFrom := false
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch option := strings.ToUpper(args[i]); {
+		case option == "FROMMEMBER" && remaining >= 1:
+			if hasFrom {
+				return q, errGeoFrom
+			}
+			q.fromMember, hasFrom = args[i+1], true
+			i++
+		case option == "FROMLONLAT" && remaining >= 2:
+			if hasFrom {
+				return q, errGeoFrom
+			}
+			lon, lat, err := parseLonLat(args[i+1], args[i+2])
+			if err != nil {
+				return q, err
+			}
+			q.shape.lon, q.shape.lat = lon, lat
+			q.fromLonLat, hasFrom = true, true
+			i += 2
+		case option == "BYRADIUS" && remaining >= 2:
+			if q.byRadius || q.shape.byBox {
+				return q, errGeoBy
+			}
+			radius, err := parseFloat(args[i+1])
+			if err != nil {
+				return q, errors.New("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return q, errGeoRadius
+			}
+			if q.shape.conversion, err = parseGeoUnit(args[i+2]); err != nil {
+				return q, err
+			}
+			q.shape.radius, q.byRadius = radius, true
+			i += 2
+		case option == "BYBOX" && remaining >= 3:
+			if q.byRadius || q.shape.byBox {
+				return q, errGeoBy
+			}
+			width, err := parseFloat(args[i+1])
+			if err != nil {
+				return q, errors.New("ERR need numeric width")
+			}
+			height, err := parseFloat(args[i+2])
+			if err != nil {
+				return q, errors.New("ERR need numeric height")
+			}
+			if width < 0 || height < 0 {
+				return q, errGeoBoxSize
+			}
+			if q.shape.conversion, err = parseGeoUnit(args[i+3]); err != nil {
+				return q, err
+			}
+			q.shape.width, q.shape.height, q.shape.byBox = width, height, true
+			i += 3
+		case option == "ASC":
+			q.asc, q.desc = true, false
+		case option == "DESC":
+			q.asc, q.desc = false, true
+		case option == "COUNT" && remaining >= 1:
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return q, errNotInteger
+			}
+			if n <= 0 {
+				return q, errGeoCount
+			}
+			q.count = int(min(n, 1<<31-1))
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "ANY") {
+				q.any = true
+				i++
+			}
+		case option == "WITHCOORD":
+			q.withCoord = true
+		case option == "WITHDIST":
+			q.withDist = true
+		case option == "WITHHASH":
+			q.withHash = true
+		case option == "STOREDIST" && store:
+			q.storeDist = true
+		case option == "ANY":
+			return q, errGeoAnyCount
+		default:
+			return q, errSyntax
+		}
+	}
+	if !hasFrom {
+		return q, errGeoFrom
+	}
+	if !q.byRadius && !q.shape.byBox {
+		return q, errGeoBy
+	}
+	if store && (q.withCoord || q.withDist || q.withHash) {
+		return q, errGeoStoreOptions
+	}
+	// a COUNT without ANY wants the closest points
+	if q.count > 0 && !q.any && !q.desc {
+		q.asc = true
+	}
+	return q, nil
+}
+
+// geoPoint is a member found by a search.
+type geoPoint struct {
+	member   string
+	score    float64
+	lon, lat float64
+	// distance to the center, in meters
+	distance float64
+}
+
+// search returns the members of z within the shape. With ANY it stops as soon
+// as count members are found, otherwise every member is collected.
+func (q geoQuery) search(z *zset) []geoPoint {
+	points := []geoPoint{}
+	seen := map[geoHashBits]bool{}
+	for _, cell := range q.shape.searchAreas() {
+		if cell.isZero() || seen[cell] {
+			continue
+		}
+		seen[cell] = true
+		for _, e := range z.RangeByScore(cell.scoreRange(), false, 0, -1) {
+			if q.any && len(points) >= q.count {
+				return points
+			}
+			lon, lat := geoDecodeScore(e.score)
+			distance, ok := q.shape.contains(lon, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{e.member, e.score, lon, lat, distance})
+		}
+	}
+	return points
+}
+
+// handleGeoSearch serves GEOSEARCH and GEOSEARCHSTORE, which takes the
+// destination key first.
+func (s *server) handleGeoSearch(req *Request) ([]resp.Value, error) {
+	store := strings.EqualFold(string(req.Command), "GEOSEARCHSTORE")
+	args := req.Args
+	var dst string
+	if store {
+		dst, args = args[0], args[1:]
+	}
+	q, err := parseGeoSearch(args[1:], store)
+	if err != nil {
+		return nil, err
+	}
+	z, exists, err := s.lookupZSet(args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var points []geoPoint
+	if exists {
+		if !q.fromLonLat {
+			score, ok := z.Score(q.fromMember)
+			if !ok {
+				return nil, errGeoNoMember
+			}
+			q.shape.lon, q.shape.lat = geoDecodeScore(score)
+		}
+		points = q.search(z)
+	}
+	if q.asc || q.desc {
+		slices.SortStableFunc(points, func(a, b geoPoint) int {
+			if q.desc {
+				a, b = b, a
+			}
+			switch {
+			case a.distance < b.distance:
+				return -1
+			case a.distance > b.distance:
+				return 1
+			}
+			return 0
+		})
+	}
+	if q.count > 0 && len(points) > q.count {
+		points = points[:q.count]
+	}
+	if store {
+		result := newZSet()
+		for _, p := range points {
+			score := p.score
+			if q.storeDist {
+				score = p.distance / q.shape.conversion
+			}
+			result.Set(p.member, score)
+		}
+		s.storeZSet(dst, result)
+		return reply(resp.Integer(int64(len(points))))
+	}
+	values := make([]resp.Value, len(points))
+	for i, p := range points {
+		if !q.withDist && !q.withHash && !q.withCoord {
+			values[i] = resp.BulkString(p.member)
+			continue
+		}
+		fields := []resp.Value{resp.BulkString(p.member)}
+		if q.withDist {
+			fields = append(fields, resp.BulkString(formatDistance(p.distance, q.shape.conversion)))
+		}
+		if q.withHash {
+			fields = append(fields, resp.Integer(int64(p.score)))
+		}
+		if q.withCoord {
+			fields = append(fields, coordinatesReply(p.lon, p.lat))
+		}
+		values[i] = resp.Array(fields...)
+	}
+	return reply(resp.Array(values...))
+}
@@ -0,0 +1,361 @@
+package app
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Geo indexes are sorted sets whose scores are the 52 bit geohashes of their
+// members, interleaving 26 bits of latitude with 26 bits of longitude as redis
+// does, so that nearby points have close scores. This is a port of the geohash
+// helpers of redis.
+const (
+	geoStepMax = 26
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLonMin  = -180.0
+	geoLonMax  = 180.0
+
+	earthRadiusMeters = 6372797.560856
+	mercatorMax       = 20037726.37
+)
+
+type geoRange struct {
+	min, max float64
+}
+
+var (
+	geoLonRange = geoRange{geoLonMin, geoLonMax}
+	geoLatRange = geoRange{geoLatMin, geoLatMax}
+)
+
+// geoHashBits is a geohash of step bits per coordinate.
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+func (h geoHashBits) isZero() bool {
+	return h.bits == 0 && h.step == 0
+}
+
+// geoArea is the cell covered by a geohash.
+type geoArea struct {
+	hash     geoHashBits
+	lon, lat geoRange
+}
+
+type geoNeighbors struct {
+	north, east, west, south                   geoHashBits
+	northEast, southEast, northWest, southWest geoHashBits
+}
+
+// interleave64 spreads the bits of x over the even bits of the result and the
+// bits of y over the odd ones.
+func interleave64(x, y uint32) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := [...]uint{1, 2, 4, 8, 16}
+	xx, yy := uint64(x), uint64(y)
+	for i := len(s) - 1; i >= 0; i-- {
+		xx = (xx | xx<<s[i]) & b[i]
+		yy = (yy | yy<<s[i]) & b[i]
+	}
+	return xx | yy<<1
+}
+
+// deinterleave64 is the inverse of interleave64, returning x in the low 32 bits
+// and y in the high ones.
+func deinterleave64(interleaved uint64) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+	x, y := interleaved, interleaved>>1
+	for i := range s {
+		x = (x | x>>s[i]) & b[i]
+		y = (y | y>>s[i]) & b[i]
+	}
+	return x | y<<32
+}
+
+// validLonLat reports whether a point can be indexed, latitudes near the poles
+// can't be represented by the Mercator projection.
+func validLonLat(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+func geohashEncode(lonRange, latRange geoRange, lon, lat float64, step uint) (geoHashBits, bool) {
+	if !validLonLat(lon, lat) || lon < lonRange.min || lon > lonRange.max || lat < latRange.min || lat > latRange.max {
+		return geoHashBits{}, false
+	}
+	latOffset := (lat - latRange.min) / (latRange.max - latRange.min)
+	lonOffset := (lon - lonRange.min) / (lonRange.max - lonRange.min)
+	latOffset *= float64(uint64(1) << step)
+	lonOffset *= float64(uint64(1) << step)
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(lonOffset)), step: step}, true
+}
+
+func geohashDecode(lonRange, latRange geoRange, hash geoHashBits) geoArea {
+	separated := deinterleave64(hash.bits)
+	latScale := latRange.max - latRange.min
+	lonScale := lonRange.max - lonRange.min
+	ilat := float64(uint32(separated))
+	ilon := float64(uint32(separated >> 32))
+	cells := float64(uint64(1) << hash.step)
+	return geoArea{
+		hash: hash,
+		lat:  geoRange{latRange.min + ilat/cells*latScale, latRange.min + (ilat+1)/cells*latScale},
+		lon:  geoRange{lonRange.min + ilon/cells*lonScale, lonRange.min + (ilon+1)/cells*lonScale},
+	}
+}
+
+// center returns the coordinates of the middle of the area.
+func (a geoArea) center() (float64, float64) {
+	lon := min(max((a.lon.min+a.lon.max)/2, geoLonMin), geoLonMax)
+	lat := min(max((a.lat.min+a.lat.max)/2, geoLatMin), geoLatMax)
+	return lon, lat
+}
+
+// geoScore returns the score a point is indexed with.
+func geoScore(lon, lat float64) (float64, bool) {
+	hash, ok := geohashEncode(geoLonRange, geoLatRange, lon, lat, geoStepMax)
+	if !ok {
+		return 0, false
+	}
+	return float64(hash.bits), true
+}
+
+// geoDecodeScore returns the coordinates of the point indexed with score.
+func geoDecodeScore(score float64) (float64, float64) {
+	hash := geoHashBits{bits: uint64(score), step: geoStepMax}
+	return geohashDecode(geoLonRange, geoLatRange, hash).center()
+}
+
+func geohashMoveX(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+	if d > 0 {
+		x += zz + 1
+	} else {
+		x |= zz
+		x -= zz + 1
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+func geohashMoveY(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	if d > 0 {
+		y += zz + 1
+	} else {
+		y |= zz
+		y -= zz + 1
+	}
+	y &= 0x5555555555555555 >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+func geohashNeighbors(hash geoHashBits) geoNeighbors {
+	move := func(dx, dy int) geoHashBits {
+		h := hash
+		if dx != 0 {
+			geohashMoveX(&h, dx)
+		}
+		if dy != 0 {
+			geohashMoveY(&h, dy)
+		}
+		return h
+	}
+	return geoNeighbors{
+		east:      move(1, 0),
+		west:      move(-1, 0),
+		south:     move(0, -1),
+		north:     move(0, 1),
+		northWest: move(-1, 1),
+		southWest: move(-1, -1),
+		northEast: move(1, 1),
+		southEast: move(1, -1),
+	}
+}
+
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusMeters * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// geoDistance is the haversine distance in meters between two points.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	v := math.Sin((degRad(lon2) - degRad(lon1)) / 2)
+	if v == 0 {
+		return geoLatDistance(lat1, lat2)
+	}
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// geoShape is the area searched by GEOSEARCH: a circle of the given radius or
+// a box, around a center, with sizes in the unit the query used.
+type geoShape struct {
+	lon, lat      float64
+	byBox         bool
+	radius        float64
+	width, height float64
+	// conversion is the number of meters in the unit of the query
+	conversion float64
+}
+
+// contains returns the distance in meters from the center of the shape to the
+// point, ok is false when the point is outside the shape.
+func (sh geoShape) contains(lon, lat float64) (float64, bool) {
+	if !sh.byBox {
+		distance := geoDistance(sh.lon, sh.lat, lon, lat)
+		return distance, distance <= sh.radius*sh.conversion
+	}
+	if geoLatDistance(lat, sh.lat) > sh.height*sh.conversion/2 {
+		return 0, false
+	}
+	if geoDistance(lon, lat, sh.lon, lat) > sh.width*sh.conversion/2 {
+		return 0, false
+	}
+	return geoDistance(sh.lon, sh.lat, lon, lat), true
+}
+
+// boundingBox returns the min longitude, min latitude, max longitude and max
+// latitude enclosing the shape.
+func (sh geoShape) boundingBox() (float64, float64, float64, float64) {
+	height, width := sh.radius, sh.radius
+	if sh.byBox {
+		height, width = sh.height/2, sh.width/2
+	}
+	height *= sh.conversion
+	width *= sh.conversion
+	latDelta := radDeg(height / earthRadiusMeters)
+	lonDeltaTop := radDeg(width / earthRadiusMeters / math.Cos(degRad(sh.lat+latDelta)))
+	lonDeltaBottom := radDeg(width / earthRadiusMeters / math.Cos(degRad(sh.lat-latDelta)))
+	// the widest side is the one closest to the equator
+	lonDelta := lonDeltaTop
+	if sh.lat < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return sh.lon - lonDelta, sh.lat - latDelta, sh.lon + lonDelta, sh.lat + latDelta
+}
+
+// geoEstimateSteps returns the geohash precision whose cells are about the
+// size of the searched radius.
+func geoEstimateSteps(rangeMeters, lat float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// make sure the range is included in most of the base cases
+	step -= 2
+	// cells get narrower towards the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// searchAreas returns the cell holding the center of the shape and its eight
+// neighbors, which together cover the shape. Neighbors entirely outside of
+// the bounding box of the shape are zeroed.
+func (sh geoShape) searchAreas() []geoHashBits {
+	radiusMeters := sh.radius * sh.conversion
+	if sh.byBox {
+		radiusMeters = math.Sqrt(math.Pow(sh.width/2, 2)+math.Pow(sh.height/2, 2)) * sh.conversion
+	}
+	minLon, minLat, maxLon, maxLat := sh.boundingBox()
+	steps := geoEstimateSteps(radiusMeters, sh.lat)
+	hash, _ := geohashEncode(geoLonRange, geoLatRange, sh.lon, sh.lat, steps)
+	neighbors := geohashNeighbors(hash)
+	area := geohashDecode(geoLonRange, geoLatRange, hash)
+
+	// near the edge of its cell the neighbors may not cover the whole shape,
+	// in which case larger cells are used
+	north := geohashDecode(geoLonRange, geoLatRange, neighbors.north)
+	south := geohashDecode(geoLonRange, geoLatRange, neighbors.south)
+	east := geohashDecode(geoLonRange, geoLatRange, neighbors.east)
+	west := geohashDecode(geoLonRange, geoLatRange, neighbors.west)
+	if steps > 1 && (north.lat.max < maxLat || south.lat.min > minLat || east.lon.max < maxLon || west.lon.min > minLon) {
+		steps--
+		hash, _ = geohashEncode(geoLonRange, geoLatRange, sh.lon, sh.lat, steps)
+		neighbors = geohashNeighbors(hash)
+		area = geohashDecode(geoLonRange, geoLatRange, hash)
+	}
+
+	if steps >= 2 {
+		if area.lat.min < minLat {
+			neighbors.south, neighbors.southWest, neighbors.southEast = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.lat.max > maxLat {
+			neighbors.north, neighbors.northEast, neighbors.northWest = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.lon.min < minLon {
+			neighbors.west, neighbors.southWest, neighbors.northWest = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.lon.max > maxLon {
+			neighbors.east, neighbors.southEast, neighbors.northEast = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+	}
+	return []geoHashBits{
+		hash,
+		neighbors.north, neighbors.south, neighbors.east, neighbors.west,
+		neighbors.northEast, neighbors.northWest, neighbors.southEast, neighbors.southWest,
+	}
+}
+
+// scoreRange returns the scores of the points within the cell, the max being
+// excluded.
+func (h geoHashBits) scoreRange() scoreRange {
+	shift := 52 - h.step*2
+	return scoreRange{
+		min:   float64(h.bits << shift),
+		max:   float64((h.bits + 1) << shift),
+		maxex: true,
+	}
+}
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohashString returns the standard 11 characters geohash of the point indexed
+// with score, which unlike the scores uses the full latitude range.
+func geohashString(score float64) string {
+	lon, lat := geoDecodeScore(score)
+	hash, _ := geohashEncode(geoLonRange, geoRange{-90, 90}, lon, lat, geoStepMax)
+	var b [11]byte
+	for i := range b {
+		idx := 0
+		if i < 10 {
+			idx = int(hash.bits>>(52-(uint(i)+1)*5)) & 0x1f
+		}
+		b[i] = geoAlphabet[idx]
+	}
+	return string(b[:])
+}
+
+// formatCoordinate formats a coordinate the way redis replies them, with 17
+// decimals at most.
+func formatCoordinate(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,123 @@
+package app
+
+// stringMatch reports whether str matches the glob-style pattern with the same
+// semantics as redis: '*' matches any sequence, '?' any single byte, '[...]' a
+// set of bytes which may contain ranges ("a-z") and be negated with '^', and '\'
+// escapes the next byte.
+func stringMatch(pattern, str string, nocase bool) bool {
+	skipLonger := false
+	return stringMatchImpl(pattern, str, nocase, &skipLonger)
+}
+
+// stringMatchImpl is stringMatch, skipLonger is set once the rest of the
+// pattern after a star failed against every suffix of str. Matching a longer
+// prefix with an earlier star can't help then, so the callers give up right
+// away instead of backtracking, which is exponential in the number of stars
+// (redis CVE-2022-36021).
+func stringMatchImpl(pattern, str string, nocase bool, skipLonger *bool) bool {
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			// consecutive stars are the same as a single one
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; s <= len(str); s++ {
+				if stringMatchImpl(pattern[p+1:], str[s:], nocase, skipLonger) {
+					return true
+				}
+				if *skipLonger {
+					return false
+				}
+			}
+			*skipLonger = true
+			return false
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+			var matched bool
+			matched, p = matchSet(pattern, p+1, str[s], nocase)
+			if !matched {
+				return false
+			}
+			s++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if s >= len(str) || !equalByte(pattern[p], str[s], nocase) {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+	return s == len(str)
+}
+
+// matchSet matches c against the set starting at pattern[p] (right after '[') and
+// returns the index of the closing ']'. An unterminated set extends to the end
+// of the pattern.
+func matchSet(pattern string, p int, c byte, nocase bool) (bool, int) {
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	matched := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if equalByte(pattern[p], c, nocase) {
+				matched = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			if nocase {
+				start, end, c = toLowerByte(start), toLowerByte(end), toLowerByte(c)
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			p += 2
+		default:
+			if equalByte(pattern[p], c, nocase) {
+				matched = true
+			}
+		}
+	}
+	if p == len(pattern) {
+		// keep the caller's loop from stepping past the end
+		p--
+	}
+	return matched != negate, p
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLowerByte(a) == toLowerByte(b)
+	}
+	return a == b
+}
+
+func toLowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		nocase, want bool
+	}{
+		{"*", "", false, true},
+		{"h?llo", "hello", false, true},
+		{"h*llo", "heeello", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h\\*llo", "h*llo", false, true},
+		{"HELLO", "hello", true, true},
+		{"*b*c", "abxbc", false, true},
+		{"*b*c", "abxbcd", false, false},
+		{"a*b*c*", "aXbYcZ", false, true},
+		{"*ab", "aab", false, true},
+		{"*[ab]x", "zax", false, true},
+	}
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.str, tt.nocase); got != tt.want {
+			t.Errorf("stringMatch(%q, %q, %v) = %v, want %v", tt.pattern, tt.str, tt.nocase, got, tt.want)
+		}
+	}
+}
+
+func TestStringMatchManyStars(t *testing.T) {
+	str := strings.Repeat("a", 40)
+	start := time.Now()
+	if stringMatch(strings.Repeat("*a", 20)+"*b", str, false) {
+		t.Error("pattern ending with b matched a string of a")
+	}
+	if !stringMatch(strings.Repeat("*a", 20)+"*", str, false) {
+		t.Error("pattern of 20 a did not match 40 a")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("matching took %v", elapsed)
+	}
+}
@@ -0,0 +1,265 @@
+package app
+
+import (
+	"maps"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// hash is the hash value. Small hashes are a flat slice of field value pairs
+// searched linearly, like the listpack encoding of redis, and are converted to
+// a map once they outgrow hash-max-listpack-entries or hash-max-listpack-value.
+// A converted hash never goes back to the compact encoding.
+//
+// Fields can have their own deadline. Expired fields are hidden by the
+// accessors until they are reclaimed, either when a command writes to the key
+// or by the active expire cycle.
+type hash struct {
+	pairs []hashPair
+	dict  map[string]string
+	// expires holds the deadlines of the fields having one, nil when none has
+	expires map[string]time.Time
+	// index orders the fields of the map for HSCAN
+	index *scanIndex
+	// expiryPaused is the flag of the store holding the hash, fields don't
+	// expire while it is set
+	expiryPaused *atomic.Bool
+}
+
+type hashPair struct {
+	field string
+	value string
+}
+
+func newHash() *hash {
+	return &hash{}
+}
+
+// fieldExpired reports whether the ttl of field elapsed.
+func (h *hash) fieldExpired(field string, now time.Time) bool {
+	if h.expires == nil {
+		return false
+	}
+	deadline, ok := h.expires[field]
+	return ok && !h.paused() && deadlinePassed(deadline, now)
+}
+
+// Len returns the number of live fields.
+func (h *hash) Len() int {
+	n := len(h.pairs)
+	if h.dict != nil {
+		n = len(h.dict)
+	}
+	if len(h.expires) > 0 {
+		now := time.Now()
+		for field := range h.expires {
+			if h.fieldExpired(field, now) {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+func (h *hash) Get(field string) (string, bool) {
+	if h.fieldExpired(field, time.Now()) {
+		return "", false
+	}
+	return h.get(field)
+}
+
+func (h *hash) get(field string) (string, bool) {
+	if h.dict != nil {
+		value, ok := h.dict[field]
+		return value, ok
+	}
+	for _, p := range h.pairs {
+		if p.field == field {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+// Set stores value in field and reports whether the field is new. The ttl of an
+// existing field is kept.
+func (h *hash) Set(field, value string) bool {
+	if h.fieldExpired(field, time.Now()) {
+		// the expired field is replaced by a new one
+		h.Delete(field)
+	}
+	if h.dict != nil {
+		_, exists := h.dict[field]
+		h.dict[field] = value
+		if !exists {
+			h.index.add(field)
+		}
+		return !exists
+	}
+	for i := range h.pairs {
+		if h.pairs[i].field == field {
+			h.pairs[i].value = value
+			return false
+		}
+	}
+	h.pairs = append(h.pairs, hashPair{field, value})
+	return true
+}
+
+func (h *hash) Delete(field string) bool {
+	if h.fieldExpired(field, time.Now()) {
+		h.remove(field)
+		return false
+	}
+	return h.remove(field)
+}
+
+func (h *hash) remove(field string) bool {
+	delete(h.expires, field)
+	if h.dict != nil {
+		_, exists := h.dict[field]
+		if exists {
+			delete(h.dict, field)
+			h.index.remove(field)
+		}
+		return exists
+	}
+	for i, p := range h.pairs {
+		if p.field == field {
+			h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// forEach calls fn for every live field until it returns false, compact hashes
+// are walked in insertion order.
+func (h *hash) forEach(fn func(field, value string) bool) {
+	now := time.Now()
+	if h.dict != nil {
+		for field, value := range h.dict {
+			if h.fieldExpired(field, now) {
+				continue
+			}
+			if !fn(field, value) {
+				return
+			}
+		}
+		return
+	}
+	for _, p := range h.pairs {
+		if h.fieldExpired(p.field, now) {
+			continue
+		}
+		if !fn(p.field, p.value) {
+			return
+		}
+	}
+}
+
+func (h *hash) Fields() []string {
+	fields := make([]string, 0, h.Len())
+	h.forEach(func(field, _ string) bool {
+		fields = append(fields, field)
+		return true
+	})
+	return fields
+}
+
+// Expiry returns the deadline of a field, ok is false when it has none.
+func (h *hash) Expiry(field string) (time.Time, bool) {
+	deadline, ok := h.expires[field]
+	return deadline, ok
+}
+
+func (h *hash) SetExpiry(field string, deadline time.Time) {
+	if h.expires == nil {
+		h.expires = make(map[string]time.Time)
+	}
+	h.expires[field] = deadline
+}
+
+// Persist removes the ttl of a field and reports whether it had one.
+func (h *hash) Persist(field string) bool {
+	if _, ok := h.expires[field]; !ok {
+		return false
+	}
+	delete(h.expires, field)
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+	return true
+}
+
+func (h *hash) volatile() bool {
+	return len(h.expires) > 0
+}
+
+// hasExpired reports whether some field of the hash is waiting to be reclaimed.
+func (h *hash) hasExpired(now time.Time) bool {
+	for field := range h.expires {
+		if h.fieldExpired(field, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// paused reports whether the store holding the hash pauses expiry.
+func (h *hash) paused() bool {
+	return h.expiryPaused != nil && h.expiryPaused.Load()
+}
+
+// reclaimExpired deletes the fields whose ttl elapsed and returns them.
+func (h *hash) reclaimExpired(now time.Time) []string {
+	var reclaimed []string
+	for field := range h.expires {
+		if h.fieldExpired(field, now) {
+			h.remove(field)
+			reclaimed = append(reclaimed, field)
+		}
+	}
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+	return reclaimed
+}
+
+// clone returns a copy of the hash sharing no storage with it, ttls included.
+func (h *hash) clone() *hash {
+	c := &hash{pairs: slices.Clone(h.pairs), dict: maps.Clone(h.dict), expires: maps.Clone(h.expires)}
+	if c.dict != nil {
+		c.index = newScanIndex()
+		for field := range c.dict {
+			c.index.add(field)
+		}
+	}
+	return c
+}
+
+func (h *hash) compact() bool {
+	return h.dict == nil
+}
+
+func (h *hash) convert() {
+	h.dict = make(map[string]string, len(h.pairs))
+	h.index = newScanIndex()
+	for _, p := range h.pairs {
+		h.dict[p.field] = p.value
+		h.index.add(p.field)
+	}
+	h.pairs = nil
+}
+
+// hashSet sets a field of h, converting it to a map when it grows past the
+// configured listpack limits.
+func (s *server) hashSet(h *hash, field, value string) bool {
+	created := h.Set(field, value)
+	if h.compact() && (h.Len() > s.Config.HashMaxListpackEntries ||
+		len(field) > s.Config.HashMaxListpackValue || len(value) > s.Config.HashMaxListpackValue) {
+		h.convert()
+	}
+	return created
+}
@@ -0,0 +1,499 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var (
+	errHashNotInteger = errors.New("ERR hash value is not an integer")
+	errHashNotFloat   = errors.New("ERR hash value is not a float")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "HSET",
+			group:    "hash",
+			summary:  "Creates or modifies the value of a field in a hash.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHSet,
+		},
+		&commandSpec{
+			name:     "HMSET",
+			group:    "hash",
+			summary:  "Sets the values of multiple fields.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHSet,
+		},
+		&commandSpec{
+			name:     "HSETNX",
+			group:    "hash",
+			summary:  "Sets the value of a field in a hash only when the field doesn't exist.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHSetNX,
+		},
+		&commandSpec{
+			name:     "HGET",
+			group:    "hash",
+			summary:  "Returns the value of a field in a hash.",
+			arity:    3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHGet,
+		},
+		&commandSpec{
+			name:     "HMGET",
+			group:    "hash",
+			summary:  "Returns the values of all fields in a hash.",
+			arity:    -3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHMGet,
+		},
+		&commandSpec{
+			name:     "HDEL",
+			group:    "hash",
+			summary:  "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHDel,
+		},
+		&commandSpec{
+			name:     "HEXISTS",
+			group:    "hash",
+			summary:  "Determines whether a field exists in a hash.",
+			arity:    3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHExists,
+		},
+		&commandSpec{
+			name:     "HLEN",
+			group:    "hash",
+			summary:  "Returns the number of fields in a hash.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHLen,
+		},
+		&commandSpec{
+			name:     "HSTRLEN",
+			group:    "hash",
+			summary:  "Returns the length of the value of a field.",
+			arity:    3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHStrLen,
+		},
+		&commandSpec{
+			name:     "HKEYS",
+			group:    "hash",
+			summary:  "Returns all fields in a hash.",
+			arity:    2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHGetAll,
+		},
+		&commandSpec{
+			name:     "HVALS",
+			group:    "hash",
+			summary:  "Returns all values in a hash.",
+			arity:    2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHGetAll,
+		},
+		&commandSpec{
+			name:     "HGETALL",
+			group:    "hash",
+			summary:  "Returns all fields and values in a hash.",
+			arity:    2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHGetAll,
+		},
+		&commandSpec{
+			name:     "HINCRBY",
+			group:    "hash",
+			summary:  "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHIncrBy,
+		},
+		&commandSpec{
+			name:     "HINCRBYFLOAT",
+			group:    "hash",
+			summary:  "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHIncrByFloat,
+		},
+		&commandSpec{
+			name:     "HRANDFIELD",
+			group:    "hash",
+			summary:  "Returns one or more random fields from a hash.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHRandField,
+		},
+		&commandSpec{
+			name:     "HSCAN",
+			group:    "hash",
+			summary:  "Iterates over fields and values of a hash.",
+			arity:    -3,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHScan,
+		},
+	)
+}
+
+// lookupHash returns the live hash stored at key, it fails with WRONGTYPE when
+// the key holds another type.
+func (s *server) lookupHash(key string, now time.Time) (*hash, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+	h, isHash := res.value.(*hash)
+	if !isHash {
+		return nil, false, errWrongType
+	}
+	if h.Len() == 0 {
+		// every field expired and the key is waiting to be reclaimed
+		return nil, false, nil
+	}
+	return h, true, nil
+}
+
+// hashForWrite returns the hash stored at key, creating an empty one when the
+// key doesn't exist.
+func (s *server) hashForWrite(key string, now time.Time) (*hash, error) {
+	h, exists, err := s.lookupHash(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		h = newHash()
+		s.InMemoryStore.set(key, &Resource{value: h})
+	}
+	return h, nil
+}
+
+func (s *server) handleHSet(req *Request) ([]resp.Value, error) {
+	command := strings.ToLower(string(req.Command))
+	key, pairs := req.Args[0], req.Args[1:]
+	if len(pairs)%2 != 0 {
+		return nil, errWrongArgs(command)
+	}
+	h, err := s.hashForWrite(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	created := 0
+	for i := 0; i < len(pairs); i += 2 {
+		if s.hashSet(h, pairs[i], pairs[i+1]) {
+			created++
+		}
+		// overwriting a field discards its ttl
+		h.Persist(pairs[i])
+	}
+	s.addDirty(len(pairs) / 2)
+	if command == "hmset" {
+		return reply(resp.OK)
+	}
+	return reply(resp.Integer(int64(created)))
+}
+
+func (s *server) handleHSetNX(req *Request) ([]resp.Value, error) {
+	key, field, value := req.Args[0], req.Args[1], req.Args[2]
+	h, err := s.hashForWrite(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := h.Get(field); exists {
+		return reply(resp.Integer(0))
+	}
+	s.hashSet(h, field, value)
+	s.addDirty(1)
+	return reply(resp.Integer(1))
+}
+
+func (s *server) handleHGet(req *Request) ([]resp.Value, error) {
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if value, ok := h.Get(req.Args[1]); ok {
+			return reply(resp.BulkString(value))
+		}
+	}
+	return reply(resp.NullBulk())
+}
+
+func (s *server) handleHMGet(req *Request) ([]resp.Value, error) {
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	values := make([]resp.Value, 0, len(req.Args)-1)
+	for _, field := range req.Args[1:] {
+		value, ok := "", false
+		if exists {
+			value, ok = h.Get(field)
+		}
+		if ok {
+			values = append(values, resp.BulkString(value))
+		} else {
+			values = append(values, resp.NullBulk())
+		}
+	}
+	return reply(resp.Array(values...))
+}
+
+func (s *server) handleHDel(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	h, exists, err := s.lookupHash(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	deleted := 0
+	for _, field := range req.Args[1:] {
+		if h.Delete(field) {
+			deleted++
+		}
+	}
+	if h.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	}
+	s.addDirty(deleted)
+	return reply(resp.Integer(int64(deleted)))
+}
+
+func (s *server) handleHExists(req *Request) ([]resp.Value, error) {
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	_, ok := h.Get(req.Args[1])
+	return reply(resp.Bool(ok))
+}
+
+func (s *server) handleHLen(req *Request) ([]resp.Value, error) {
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(int64(h.Len())))
+}
+
+func (s *server) handleHStrLen(req *Request) ([]resp.Value, error) {
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	value, _ := h.Get(req.Args[1])
+	return reply(resp.Integer(int64(len(value))))
+}
+
+// handleHGetAll serves HGETALL, HKEYS and HVALS.
+func (s *server) handleHGetAll(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	items := []string{}
+	if exists {
+		h.forEach(func(field, value string) bool {
+			if command != "HVALS" {
+				items = append(items, field)
+			}
+			if command != "HKEYS" {
+				items = append(items, value)
+			}
+			return true
+		})
+	}
+	return reply(resp.BulkStrings(items))
+}
+
+func (s *server) handleHIncrBy(req *Request) ([]resp.Value, error) {
+	key, field := req.Args[0], req.Args[1]
+	incr, err := strconv.ParseInt(req.Args[2], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	h, err := s.hashForWrite(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	current := int64(0)
+	if value, ok := h.Get(field); ok {
+		current, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errHashNotInteger
+		}
+	}
+	if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+		return nil, errOverflow
+	}
+	current += incr
+	s.hashSet(h, field, strconv.FormatInt(current, 10))
+	s.addDirty(1)
+	return reply(resp.Integer(current))
+}
+
+func (s *server) handleHIncrByFloat(req *Request) ([]resp.Value, error) {
+	key, field := req.Args[0], req.Args[1]
+	incr, err := parseFloat(req.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.hashForWrite(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	current := 0.0
+	if value, ok := h.Get(field); ok {
+		current, err = strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(current) {
+			return nil, errHashNotFloat
+		}
+	}
+	current += incr
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return nil, errors.New("ERR increment would produce NaN or Infinity")
+	}
+	formatted := formatFloat(current)
+	s.hashSet(h, field, formatted)
+	s.addDirty(1)
+	return reply(resp.BulkString(formatted))
+}
+
+func (s *server) handleHRandField(req *Request) ([]resp.Value, error) {
+	if len(req.Args) > 3 || (len(req.Args) == 3 && !strings.EqualFold(req.Args[2], "WITHVALUES")) {
+		return nil, errSyntax
+	}
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Args) == 1 {
+		if !exists {
+			return reply(resp.NullBulk())
+		}
+		fields := h.Fields()
+		return reply(resp.BulkString(fields[rand.Intn(len(fields))]))
+	}
+
+	count, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	withValues := len(req.Args) == 3
+	if !exists || count == 0 {
+		return reply(resp.Array())
+	}
+	fields := randomMembers(h.Fields(), count)
+	items := make([]string, 0, len(fields)*2)
+	for _, field := range fields {
+		items = append(items, field)
+		if withValues {
+			value, _ := h.Get(field)
+			items = append(items, value)
+		}
+	}
+	return reply(resp.BulkStrings(items))
+}
+
+// randomMembers picks count random members, all distinct when count is
+// positive and possibly repeated when it is negative.
+func randomMembers(members []string, count int64) []string {
+	if count < 0 {
+		picked := make([]string, 0, min(-count, 1024))
+		for i := int64(0); i < -count; i++ {
+			picked = append(picked, members[rand.Intn(len(members))])
+		}
+		return picked
+	}
+	if count >= int64(len(members)) {
+		return members
+	}
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	return members[:count]
+}
+
+// handleHScan returns small hashes in a single call like redis does with
+// listpacks, larger ones are walked in scan hash order.
+func (s *server) handleHScan(req *Request) ([]resp.Value, error) {
+	cursor, err := parseCursor(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScanOptions(req.Args[2:], false, true)
+	if err != nil {
+		return nil, err
+	}
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	items := []string{}
+	next := uint32(0)
+	if exists {
+		var page []string
+		if h.compact() {
+			page = h.Fields()
+		} else {
+			page, next, _ = h.index.page(uint32(cursor), opts.count)
+		}
+		for _, field := range page {
+			value, ok := h.Get(field)
+			if !ok || !opts.matches(field) {
+				// fields of the index may have expired
+				continue
+			}
+			items = append(items, field)
+			if !opts.noValues {
+				items = append(items, value)
+			}
+		}
+	}
+	return reply(resp.Array(
+		resp.BulkString(strconv.FormatUint(uint64(next), 10)),
+		resp.BulkStrings(items),
+	))
+}
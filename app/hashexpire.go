@@ -0,0 +1,254 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// maxFieldExpireMs is the largest field deadline accepted, in unix milliseconds.
+// Redis keeps field deadlines on 48 bits.
+const maxFieldExpireMs = 1<<48 - 1
+
+// replies of the hash field expiration commands, one per field
+const (
+	fieldNotFound     = -2
+	fieldNoTTL        = -1
+	fieldNotSet       = 0
+	fieldSet          = 1
+	fieldDeleted      = 2
+	fieldPersistedTTL = 1
+)
+
+var errFieldsArg = errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+
+func init() {
+	expireSpec := func(name, summary string) *commandSpec {
+		return &commandSpec{
+			name:     name,
+			group:    "hash",
+			summary:  summary,
+			arity:    -6,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHExpire,
+		}
+	}
+	ttlSpec := func(name, summary string) *commandSpec {
+		return &commandSpec{
+			name:     name,
+			group:    "hash",
+			summary:  summary,
+			arity:    -5,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHTTL,
+		}
+	}
+	registerCommands(
+		expireSpec("HEXPIRE", "Set expiry for hash field using relative time to expire (seconds)"),
+		expireSpec("HPEXPIRE", "Set expiry for hash field using relative time to expire (milliseconds)"),
+		expireSpec("HEXPIREAT", "Set expiry for hash field using an absolute Unix timestamp (seconds)"),
+		expireSpec("HPEXPIREAT", "Set expiry for hash field using an absolute Unix timestamp (milliseconds)"),
+		ttlSpec("HTTL", "Returns the TTL in seconds of a hash field."),
+		ttlSpec("HPTTL", "Returns the TTL in milliseconds of a hash field."),
+		ttlSpec("HEXPIRETIME", "Returns the expiration time of a hash field as a Unix timestamp, in seconds."),
+		ttlSpec("HPEXPIRETIME", "Returns the expiration time of a hash field as a Unix timestamp, in msec."),
+		&commandSpec{
+			name:     "HPERSIST",
+			group:    "hash",
+			summary:  "Removes the expiration time for each specified field",
+			arity:    -5,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleHPersist,
+		},
+	)
+}
+
+// parseFieldsArg parses the trailing FIELDS numfields field [field ...] arguments
+// of the field expiration commands.
+func parseFieldsArg(args []string) ([]string, error) {
+	if len(args) < 2 || !strings.EqualFold(args[0], "FIELDS") {
+		return nil, errFieldsArg
+	}
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || n <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != int64(len(args)-2) {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// fieldReplies replies the same code for every field.
+func fieldReplies(fields []string, code int64) []resp.Value {
+	replies := make([]resp.Value, len(fields))
+	for i := range replies {
+		replies[i] = resp.Integer(code)
+	}
+	return replies
+}
+
+// handleHExpire serves HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT.
+func (s *server) handleHExpire(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	key := req.Args[0]
+	n, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if n < 0 {
+		return nil, errors.New("ERR invalid expire time, must be >= 0")
+	}
+	rest := req.Args[2:]
+	condition := ""
+	switch strings.ToUpper(rest[0]) {
+	case "NX", "XX", "GT", "LT":
+		condition = strings.ToUpper(rest[0])
+		rest = rest[1:]
+	}
+	fields, err := parseFieldsArg(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := time.Second
+	if strings.HasPrefix(command, "HP") {
+		unit = time.Millisecond
+	}
+	now := time.Now()
+	deadline, ok := expiryTime(n, unit, strings.HasSuffix(command, "AT"), now)
+	if !ok || deadline.UnixMilli() > maxFieldExpireMs {
+		return nil, errors.New("ERR invalid expire time in '" + strings.ToLower(command) + "' command")
+	}
+
+	h, exists, err := s.lookupHash(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Array(fieldReplies(fields, fieldNotFound)...))
+	}
+	replies := make([]resp.Value, 0, len(fields))
+	for _, field := range fields {
+		result := expireField(h, field, deadline, condition, now)
+		if result == fieldSet || result == fieldDeleted {
+			s.addDirty(1)
+		}
+		replies = append(replies, resp.Integer(result))
+	}
+	if h.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	} else if h.volatile() {
+		s.InMemoryStore.trackFieldExpiry(key)
+	}
+	return reply(resp.Array(replies...))
+}
+
+// expireField applies a deadline to one field, a field without ttl counts as
+// never expiring for GT and LT.
+func expireField(h *hash, field string, deadline time.Time, condition string, now time.Time) int64 {
+	if _, ok := h.Get(field); !ok {
+		return fieldNotFound
+	}
+	current, hasTTL := h.Expiry(field)
+	switch condition {
+	case "NX":
+		if hasTTL {
+			return fieldNotSet
+		}
+	case "XX":
+		if !hasTTL {
+			return fieldNotSet
+		}
+	case "GT":
+		if !hasTTL || !deadline.After(current) {
+			return fieldNotSet
+		}
+	case "LT":
+		if hasTTL && !deadline.Before(current) {
+			return fieldNotSet
+		}
+	}
+	if !h.paused() && !deadline.After(now) {
+		// a deadline in the past deletes the field right away
+		h.Delete(field)
+		return fieldDeleted
+	}
+	h.SetExpiry(field, deadline)
+	return fieldSet
+}
+
+// handleHTTL serves HTTL, HPTTL, HEXPIRETIME and HPEXPIRETIME.
+func (s *server) handleHTTL(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	fields, err := parseFieldsArg(req.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	h, exists, err := s.lookupHash(req.Args[0], now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Array(fieldReplies(fields, fieldNotFound)...))
+	}
+	replies := make([]resp.Value, 0, len(fields))
+	for _, field := range fields {
+		if _, ok := h.Get(field); !ok {
+			replies = append(replies, resp.Integer(fieldNotFound))
+			continue
+		}
+		deadline, hasTTL := h.Expiry(field)
+		if !hasTTL {
+			replies = append(replies, resp.Integer(fieldNoTTL))
+			continue
+		}
+		var value int64
+		switch command {
+		case "HTTL":
+			value = (deadline.Sub(now).Milliseconds() + 999) / 1000
+		case "HPTTL":
+			value = deadline.Sub(now).Milliseconds()
+		case "HEXPIRETIME":
+			value = deadline.Unix()
+		case "HPEXPIRETIME":
+			value = deadline.UnixMilli()
+		}
+		replies = append(replies, resp.Integer(value))
+	}
+	return reply(resp.Array(replies...))
+}
+
+func (s *server) handleHPersist(req *Request) ([]resp.Value, error) {
+	fields, err := parseFieldsArg(req.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	h, exists, err := s.lookupHash(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Array(fieldReplies(fields, fieldNotFound)...))
+	}
+	replies := make([]resp.Value, 0, len(fields))
+	for _, field := range fields {
+		switch _, ok := h.Get(field); {
+		case !ok:
+			replies = append(replies, resp.Integer(fieldNotFound))
+		case h.Persist(field):
+			s.addDirty(1)
+			replies = append(replies, resp.Integer(fieldPersistedTTL))
+		default:
+			replies = append(replies, resp.Integer(fieldNoTTL))
+		}
+	}
+	return reply(resp.Array(replies...))
+}
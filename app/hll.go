@@ -0,0 +1,290 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// HyperLogLogs are plain strings laid out exactly like the ones of redis, so
+// they can be exchanged with it through DUMP files: a 16 bytes header holding
+// the "HYLL" magic, the encoding and the cached cardinality, followed by the
+// registers, either densely packed 6 bits each or run length encoded in the
+// sparse encoding.
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllRegMax    = 1<<hllBits - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	// sparse opcodes
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+
+	hllAlphaInf = 0.721347520444481703680
+)
+
+var (
+	errNotHLL     = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errInvalidHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// hllRegs holds the registers of a HyperLogLog unpacked, one byte each.
+type hllRegs [hllRegisters]uint8
+
+// murmurHash64A is the hash function redis applies to HyperLogLog elements.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ (uint64(len(key)) * m)
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register an element maps to and the length of the run
+// of zeros of its hash, plus one, which is the value the register may take.
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	// the last bit set bounds the run when the remaining bits are all zeros
+	hash = hash>>hllP | 1<<hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// newHLL returns an empty HyperLogLog in the sparse encoding, a single XZERO
+// opcode covering every register.
+func newHLL() []byte {
+	b := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(b, "HYLL")
+	b[4] = hllSparse
+	return append(b, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// validHLL reports whether b looks like a HyperLogLog, the sparse encoding is
+// only checked thoroughly when decoded.
+func validHLL(b []byte) bool {
+	if len(b) < hllHdrSize || string(b[:4]) != "HYLL" {
+		return false
+	}
+	switch b[4] {
+	case hllDense:
+		return len(b) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+func hllCachedCard(b []byte) (uint64, bool) {
+	if b[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCachedCard(b []byte, card uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], card)
+}
+
+func hllInvalidateCache(b []byte) {
+	b[15] |= 0x80
+}
+
+func hllDenseGet(regs []byte, i int) uint8 {
+	byteIndex := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+	v := regs[byteIndex] >> fb
+	// the last register doesn't spill into a following byte
+	if byteIndex+1 < len(regs) {
+		v |= regs[byteIndex+1] << (8 - fb)
+	}
+	return v & hllRegMax
+}
+
+func hllDenseSet(regs []byte, i int, v uint8) {
+	byteIndex := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+	regs[byteIndex] &^= hllRegMax << fb
+	regs[byteIndex] |= v << fb
+	if byteIndex+1 < len(regs) {
+		regs[byteIndex+1] &^= hllRegMax >> (8 - fb)
+		regs[byteIndex+1] |= v >> (8 - fb)
+	}
+}
+
+// decodeHLL unpacks the registers of a HyperLogLog of either encoding.
+func decodeHLL(b []byte, regs *hllRegs) error {
+	body := b[hllHdrSize:]
+	if b[4] == hllDense {
+		for i := range regs {
+			regs[i] = hllDenseGet(body, i)
+		}
+		return nil
+	}
+	i := 0
+	for p := 0; p < len(body); p++ {
+		op := body[p]
+		var runLen int
+		var value uint8
+		switch {
+		case op&0xc0 == 0x00:
+			runLen = int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if p+1 >= len(body) {
+				return errInvalidHLL
+			}
+			runLen = (int(op&0x3f)<<8 | int(body[p+1])) + 1
+			p++
+		default:
+			runLen = int(op&0x3) + 1
+			value = (op>>2)&0x1f + 1
+		}
+		if i+runLen > hllRegisters {
+			return errInvalidHLL
+		}
+		for end := i + runLen; i < end; i++ {
+			regs[i] = value
+		}
+	}
+	if i != hllRegisters {
+		return errInvalidHLL
+	}
+	return nil
+}
+
+// encodeHLL packs regs with the header of old, sparse when every register fits
+// a VAL opcode and the result stays within maxSparse bytes, dense otherwise.
+// The cached cardinality is invalidated.
+func encodeHLL(old []byte, regs *hllRegs, maxSparse int) []byte {
+	if old[4] == hllSparse {
+		if b, ok := encodeSparse(regs, maxSparse); ok {
+			copy(b[:hllHdrSize], old[:hllHdrSize])
+			hllInvalidateCache(b)
+			return b
+		}
+	}
+	b := old
+	if b[4] != hllDense {
+		b = make([]byte, hllDenseSize)
+		copy(b[:hllHdrSize], old[:hllHdrSize])
+		b[4] = hllDense
+	}
+	body := b[hllHdrSize:]
+	for i, v := range regs {
+		hllDenseSet(body, i, v)
+	}
+	hllInvalidateCache(b)
+	return b
+}
+
+func encodeSparse(regs *hllRegs, maxSparse int) ([]byte, bool) {
+	b := make([]byte, hllHdrSize, hllHdrSize+64)
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		if v > hllSparseValMaxValue {
+			return nil, false
+		}
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == v {
+			run++
+		}
+		i += run
+		for run > 0 {
+			var n int
+			switch {
+			case v != 0:
+				n = min(run, hllSparseValMaxLen)
+				b = append(b, 0x80|(v-1)<<2|byte(n-1))
+			case run > hllSparseZeroMaxLen:
+				n = min(run, hllSparseXZeroMaxLen)
+				b = append(b, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+			default:
+				n = run
+				b = append(b, byte(n-1))
+			}
+			run -= n
+		}
+		if len(b) > maxSparse {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+// hllCount estimates the cardinality of regs with the estimator of Otmar Ertl
+// that redis uses.
+func hllCount(regs *hllRegs) uint64 {
+	var histogram [hllQ + 2]int
+	for _, v := range regs {
+		histogram[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "PFADD",
+			group:    "hyperloglog",
+			summary:  "Adds elements to a HyperLogLog key. Creates the key if it doesn't exist.",
+			arity:    -2,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePFAdd,
+		},
+		&commandSpec{
+			name:    "PFCOUNT",
+			group:   "hyperloglog",
+			summary: "Returns the approximated cardinality of the set(s) observed by the HyperLogLog key(s).",
+			arity:   -2,
+			// counting a single key caches the cardinality in its header
+			flags:    flagReadonly | flagExclusive,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handlePFCount,
+		},
+		&commandSpec{
+			name:     "PFMERGE",
+			group:    "hyperloglog",
+			summary:  "Merges one or more HyperLogLog values into a single key.",
+			arity:    -2,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handlePFMerge,
+		},
+	)
+}
+
+// lookupHLL returns the HyperLogLog stored at key, failing with WRONGTYPE when
+// the key holds another type or a string that isn't a HyperLogLog.
+func (s *server) lookupHLL(key string, now time.Time) (*Resource, []byte, bool, error) {
+	res, exists, err := s.lookupString(key, now)
+	if err != nil || !exists {
+		return nil, nil, false, err
+	}
+	b, isBytes := res.value.([]byte)
+	if !isBytes || !validHLL(b) {
+		return nil, nil, false, errNotHLL
+	}
+	return res, b, true, nil
+}
+
+func (s *server) handlePFAdd(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	res, b, exists, err := s.lookupHLL(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	updated := !exists
+	if !exists {
+		b = newHLL()
+		res = &Resource{value: b}
+		s.InMemoryStore.set(key, res)
+	}
+	if b[4] == hllDense {
+		// dense registers are updated in place
+		regs := b[hllHdrSize:]
+		for _, element := range req.Args[1:] {
+			i, count := hllPatLen([]byte(element))
+			if count > hllDenseGet(regs, i) {
+				hllDenseSet(regs, i, count)
+				hllInvalidateCache(b)
+				updated = true
+			}
+		}
+	} else {
+		var regs hllRegs
+		if err := decodeHLL(b, &regs); err != nil {
+			return nil, err
+		}
+		changed := false
+		for _, element := range req.Args[1:] {
+			i, count := hllPatLen([]byte(element))
+			if count > regs[i] {
+				regs[i] = count
+				changed = true
+			}
+		}
+		if changed {
+			res.value = encodeHLL(b, &regs, s.Config.HllSparseMaxBytes)
+			updated = true
+		}
+	}
+	if !updated {
+		return reply(resp.Integer(0))
+	}
+	s.addDirty(1)
+	return reply(resp.Integer(1))
+}
+
+// unionHLL folds the registers of the HyperLogLog b into regs.
+func unionHLL(b []byte, regs *hllRegs) error {
+	var other hllRegs
+	if err := decodeHLL(b, &other); err != nil {
+		return err
+	}
+	for i, v := range other {
+		regs[i] = max(regs[i], v)
+	}
+	return nil
+}
+
+// handlePFCount estimates the cardinality of a HyperLogLog, or of the union of
+// several of them. The estimate of a single key is cached until it changes.
+func (s *server) handlePFCount(req *Request) ([]resp.Value, error) {
+	now := time.Now()
+	if len(req.Args) == 1 {
+		_, b, exists, err := s.lookupHLL(req.Args[0], now)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return reply(resp.Integer(0))
+		}
+		if card, ok := hllCachedCard(b); ok {
+			return reply(resp.Integer(int64(card)))
+		}
+		var regs hllRegs
+		if err := decodeHLL(b, &regs); err != nil {
+			return nil, err
+		}
+		card := hllCount(&regs)
+		hllSetCachedCard(b, card)
+		return reply(resp.Integer(int64(card)))
+	}
+	var regs hllRegs
+	for _, key := range req.Args {
+		_, b, exists, err := s.lookupHLL(key, now)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		if err := unionHLL(b, &regs); err != nil {
+			return nil, err
+		}
+	}
+	return reply(resp.Integer(int64(hllCount(&regs))))
+}
+
+// handlePFMerge stores the union of the sources into the destination, which
+// counts as a source itself. The result stays sparse as long as every input is.
+func (s *server) handlePFMerge(req *Request) ([]resp.Value, error) {
+	now := time.Now()
+	dst := req.Args[0]
+	var regs hllRegs
+	dense := false
+	for _, key := range req.Args {
+		_, b, exists, err := s.lookupHLL(key, now)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		dense = dense || b[4] == hllDense
+		if err := unionHLL(b, &regs); err != nil {
+			return nil, err
+		}
+	}
+	res, b, exists, _ := s.lookupHLL(dst, now)
+	if !exists {
+		b = newHLL()
+		res = &Resource{value: b}
+		s.InMemoryStore.set(dst, res)
+	}
+	if dense && b[4] == hllSparse {
+		b[4] = hllDense
+		b = append(b[:hllHdrSize:hllHdrSize], make([]byte, hllDenseSize-hllHdrSize)...)
+	}
+	res.value = encodeHLL(b, &regs, s.Config.HllSparseMaxBytes)
+	s.addDirty(1)
+	return reply(resp.SimpleString("OK"))
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// infoSections lists the INFO sections in the order they are reported.
+var infoSections = []struct {
+	name  string
+	title string
+	lines func(s *server) []string
+}{
+	{"clients", "Clients", (*server).clientsInfo},
+	{"persistence", "Persistence", (*server).persistenceInfo},
+	{"stats", "Stats", (*server).statsInfo},
+	{"replication", "Replication", (*server).replicationInfo},
+	{"keyspace", "Keyspace", (*server).keyspaceInfo},
+}
+
+func (s *server) handleInfo(req *Request) ([]resp.Value, error) {
+	wanted := map[string]bool{}
+	for _, arg := range req.Args {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var builder strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + section.title + "\r\n")
+		for _, line := range section.lines(s) {
+			builder.WriteString(line + "\r\n")
+		}
+	}
+	// unknown sections produce an empty reply like in redis
+	return reply(resp.BulkString(builder.String()))
+}
+
+func (s *server) clientsInfo() []string {
+	return []string{
+		fmt.Sprintf("connected_clients:%d", s.connectedClients.Load()),
+		fmt.Sprintf("blocked_clients:%d", s.blocking.blocked.Load()),
+	}
+}
+
+func (s *server) statsInfo() []string {
+	return []string{
+		fmt.Sprintf("expired_keys:%d", s.InMemoryStore.expiredKeys.Load()),
+		fmt.Sprintf("expired_subkeys:%d", s.InMemoryStore.expiredFields.Load()),
+	}
+}
+
+func (s *server) replicationInfo() []string {
+	role := "role:master"
+	if s.Config.ReplicaOf != nil {
+		role = "role:slave"
+	}
+	return []string{
+		role,
+		"master_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb",
+		"master_repl_offset:0",
+	}
+}
+
+func (s *server) keyspaceInfo() []string {
+	keys := s.InMemoryStore.Len()
+	if keys == 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=0", keys, s.InMemoryStore.Volatile()),
+	}
+}
@@ -1,10 +1,360 @@
 package app
 
-import "time"
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-type InMemoryStore map[string]*Resource
+// shardCount must stay a power of two, shards are picked by masking the key hash
+const shardCount = 64
+
+// InMemoryStore is the keyspace, split into shards that are locked independently
+// so that commands on unrelated keys from different connections run in parallel.
+//
+// Methods named with a lower-case verb (get, set, delete, ...) expect the caller to
+// hold the lock of the shard owning the key, the command dispatcher takes those
+// locks for every key a command declares. Methods that walk the whole keyspace
+// lock the shards themselves, one at a time.
+type InMemoryStore struct {
+	shards [shardCount]*shard
+	// cut is locked while a snapshot copies the shards one at a time, the
+	// writes to keys of several shards read lock it so that a copy never holds
+	// half of one
+	cut sync.RWMutex
+	// expiredKeys counts keys reclaimed because their ttl elapsed
+	expiredKeys atomic.Int64
+	// expiredFields counts hash fields reclaimed because their ttl elapsed
+	expiredFields atomic.Int64
+	// expiryPaused is set while the append only file is replayed. Its commands
+	// ran on keys that were alive at the time, so deadlines that elapsed since
+	// must not apply before the replay completes, like redis does while loading.
+	expiryPaused atomic.Bool
+	// onReclaim, when set, is called with the shard of key locked once key is
+	// deleted because its ttl elapsed, or once fields of the hash stored at key
+	// are for the same reason
+	onReclaim func(key string, fields []string)
+}
+
+type shard struct {
+	mu    sync.RWMutex
+	items map[string]*Resource
+	// volatile indexes the keys having an expiry, sampled by the active expire cycle
+	volatile map[string]struct{}
+	// volatileHashes indexes the hashes having fields with an expiry
+	volatileHashes map[string]struct{}
+	// index orders the keys for SCAN
+	index *scanIndex
+	// copied is set once a snapshot taken with snapshotWith copied the shard,
+	// until the snapshot completes
+	copied atomic.Bool
+}
 
 type Resource struct {
 	value   interface{}
 	expired *time.Time
 }
+
+// typeName is the type reported by TYPE for a stored value.
+func typeName(res *Resource) string {
+	switch res.value.(type) {
+	case []byte, int64:
+		return "string"
+	case *deque:
+		return "list"
+	case *hash:
+		return "hash"
+	case *set:
+		return "set"
+	case *zset:
+		return "zset"
+	case *stream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// cloneValue returns a deep copy of a stored value.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return slices.Clone(v)
+	case *deque:
+		return v.clone()
+	case *hash:
+		return v.clone()
+	case *set:
+		return v.clone()
+	case *zset:
+		return v.clone()
+	case *stream:
+		return v.clone()
+	}
+	return value
+}
+
+func NewInMemoryStore() *InMemoryStore {
+	m := &InMemoryStore{}
+	for i := range m.shards {
+		m.shards[i] = &shard{
+			items:          make(map[string]*Resource),
+			volatile:       make(map[string]struct{}),
+			volatileHashes: make(map[string]struct{}),
+			index:          newScanIndex(),
+		}
+	}
+	return m
+}
+
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() & (shardCount - 1))
+}
+
+func (m *InMemoryStore) shardFor(key string) *shard {
+	return m.shards[shardIndex(key)]
+}
+
+// lockKeys locks the shards owning keys, exclusively when write is set, and
+// returns the function releasing them. Shards are always acquired in index order
+// so multi-key commands cannot deadlock each other.
+func (m *InMemoryStore) lockKeys(keys []string, write bool) func() {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, shardIndex(key))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+	cut := write && len(indexes) > 1
+	if cut {
+		m.cut.RLock()
+	}
+	for _, i := range indexes {
+		if write {
+			m.shards[i].mu.Lock()
+		} else {
+			m.shards[i].mu.RLock()
+		}
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			if write {
+				m.shards[indexes[j]].mu.Unlock()
+			} else {
+				m.shards[indexes[j]].mu.RUnlock()
+			}
+		}
+		if cut {
+			m.cut.RUnlock()
+		}
+	}
+}
+
+// lockAll locks every shard, used by commands that change the whole keyspace.
+func (m *InMemoryStore) lockAll(write bool) func() {
+	if write {
+		m.cut.RLock()
+	}
+	for _, sh := range m.shards {
+		if write {
+			sh.mu.Lock()
+		} else {
+			sh.mu.RLock()
+		}
+	}
+	return func() {
+		for i := len(m.shards) - 1; i >= 0; i-- {
+			if write {
+				m.shards[i].mu.Unlock()
+			} else {
+				m.shards[i].mu.RUnlock()
+			}
+		}
+		if write {
+			m.cut.RUnlock()
+		}
+	}
+}
+
+// expired reports whether the ttl of res elapsed, unless expiry is paused.
+func (m *InMemoryStore) expired(res *Resource, now time.Time) bool {
+	return !m.expiryPaused.Load() && expired(res, now)
+}
+
+// alreadyExpired reports whether a deadline given to an expire command is not
+// in the future, the key or field is then deleted instead.
+func (m *InMemoryStore) alreadyExpired(deadline time.Time, now time.Time) bool {
+	return !m.expiryPaused.Load() && !deadline.After(now)
+}
+
+func (m *InMemoryStore) get(key string) (*Resource, bool) {
+	res, ok := m.shardFor(key).items[key]
+	return res, ok
+}
+
+// lookup returns the resource stored at key unless it has expired.
+func (m *InMemoryStore) lookup(key string, now time.Time) (*Resource, bool) {
+	res, ok := m.get(key)
+	if !ok || m.expired(res, now) {
+		return nil, false
+	}
+	return res, true
+}
+
+func (m *InMemoryStore) set(key string, res *Resource) {
+	sh := m.shardFor(key)
+	if _, exists := sh.items[key]; !exists {
+		sh.index.add(key)
+	}
+	sh.items[key] = res
+	if res.expired != nil {
+		sh.volatile[key] = struct{}{}
+	} else {
+		delete(sh.volatile, key)
+	}
+	if h, ok := res.value.(*hash); ok {
+		// the fields of the hash expire along with the keys
+		h.expiryPaused = &m.expiryPaused
+	}
+	if h, ok := res.value.(*hash); ok && h.volatile() {
+		sh.volatileHashes[key] = struct{}{}
+	} else {
+		delete(sh.volatileHashes, key)
+	}
+}
+
+// trackFieldExpiry records that the hash stored at key has fields with a ttl.
+func (m *InMemoryStore) trackFieldExpiry(key string) {
+	m.shardFor(key).volatileHashes[key] = struct{}{}
+}
+
+// setExpiry changes the expiry of the resource stored at key, a nil deadline
+// makes the key persistent.
+func (m *InMemoryStore) setExpiry(key string, res *Resource, deadline *time.Time) {
+	res.expired = deadline
+	if deadline != nil {
+		m.shardFor(key).volatile[key] = struct{}{}
+	} else {
+		delete(m.shardFor(key).volatile, key)
+	}
+}
+
+func (m *InMemoryStore) delete(key string) bool {
+	sh := m.shardFor(key)
+	if _, ok := sh.items[key]; !ok {
+		return false
+	}
+	sh.removeKey(key)
+	return true
+}
+
+// removeKey deletes key from the shard and its indexes.
+func (sh *shard) removeKey(key string) {
+	delete(sh.items, key)
+	delete(sh.volatile, key)
+	delete(sh.volatileHashes, key)
+	sh.index.remove(key)
+}
+
+// Load copies resources into the store, used when restoring a dump at startup.
+func (m *InMemoryStore) Load(items map[string]*Resource) {
+	unlock := m.lockAll(true)
+	defer unlock()
+	for key, res := range items {
+		m.set(key, res)
+	}
+}
+
+// forEach calls fn for every live key, holding the read lock of one shard at a
+// time. Returning false from fn stops the iteration.
+func (m *InMemoryStore) forEach(fn func(key string, res *Resource) bool) {
+	now := time.Now()
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		for key, res := range sh.items {
+			if m.expired(res, now) {
+				continue
+			}
+			if !fn(key, res) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// snapshot returns a deep copy of the live keys, which can then be serialized
+// while writes go on. The shards are copied one at a time, holding off only the
+// writes to the shard being copied and the writes to keys of several shards.
+// Every command is thus either entirely in the snapshot or not at all, and the
+// commands on the keys of a shard are in up to the one that ran last before the
+// copy of the shard. Unlike the fork of redis, which gives its child the
+// dataset at one point in time, the snapshot may hold a write and miss an
+// earlier one to a key of another shard.
+func (m *InMemoryStore) snapshot() map[string]*Resource {
+	return m.snapshotWith(nil)
+}
+
+// snapshotWith copies the keyspace like snapshot. With fn set, the shards are
+// marked as copied, so that the writes made meanwhile can tell with copied
+// whether the snapshot holds them, and fn runs once the last shard is copied,
+// before the marks are cleared.
+func (m *InMemoryStore) snapshotWith(fn func()) map[string]*Resource {
+	m.cut.Lock()
+	defer m.cut.Unlock()
+	now := time.Now()
+	items := make(map[string]*Resource)
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		m.copyShard(sh, items, now)
+		if fn != nil {
+			sh.copied.Store(true)
+		}
+		sh.mu.RUnlock()
+	}
+	if fn != nil {
+		fn()
+		for _, sh := range m.shards {
+			sh.copied.Store(false)
+		}
+	}
+	return items
+}
+
+// copied reports whether the snapshot being taken by snapshotWith copied the
+// shard of keys, which must be locked, before the writes to them.
+func (m *InMemoryStore) copied(keys []string) bool {
+	return len(keys) > 0 && m.shardFor(keys[0]).copied.Load()
+}
+
+// copyShard adds deep copies of the live keys of sh to items. Must be called
+// with the shard locked.
+func (m *InMemoryStore) copyShard(sh *shard, items map[string]*Resource, now time.Time) {
+	for key, res := range sh.items {
+		if m.expired(res, now) {
+			continue
+		}
+		copied := &Resource{value: cloneValue(res.value)}
+		if res.expired != nil {
+			deadline := *res.expired
+			copied.expired = &deadline
+		}
+		items[key] = copied
+	}
+}
+
+// Len returns the number of keys, including expired ones not reclaimed yet.
+func (m *InMemoryStore) Len() int {
+	n := 0
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		n += len(sh.items)
+		sh.mu.RUnlock()
+	}
+	return n
+}
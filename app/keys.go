@@ -0,0 +1,305 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var errNoSuchKey = errors.New("ERR no such key")
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "DEL",
+			group:    "generic",
+			summary:  "Deletes one or more keys.",
+			arity:    -2,
+			flags:    flagWrite,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleDel,
+		},
+		&commandSpec{
+			name:     "UNLINK",
+			group:    "generic",
+			summary:  "Asynchronously deletes one or more keys.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleDel,
+		},
+		&commandSpec{
+			name:     "EXISTS",
+			group:    "generic",
+			summary:  "Determines whether one or more keys exist.",
+			arity:    -2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleExists,
+		},
+		&commandSpec{
+			name:     "TYPE",
+			group:    "generic",
+			summary:  "Determines the type of value stored at a key.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleType,
+		},
+		&commandSpec{
+			name:     "RENAME",
+			group:    "generic",
+			summary:  "Renames a key and overwrites the destination.",
+			arity:    3,
+			flags:    flagWrite,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleRename,
+		},
+		&commandSpec{
+			name:     "RENAMENX",
+			group:    "generic",
+			summary:  "Renames a key only when the target key name doesn't exist.",
+			arity:    3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleRename,
+		},
+		&commandSpec{
+			name:     "EXPIRE",
+			group:    "generic",
+			summary:  "Sets the expiration time of a key in seconds.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleExpire,
+		},
+		&commandSpec{
+			name:     "PEXPIRE",
+			group:    "generic",
+			summary:  "Sets the expiration time of a key in milliseconds.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleExpire,
+		},
+		&commandSpec{
+			name:     "EXPIREAT",
+			group:    "generic",
+			summary:  "Sets the expiration time of a key to a Unix timestamp.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleExpire,
+		},
+		&commandSpec{
+			name:     "PEXPIREAT",
+			group:    "generic",
+			summary:  "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleExpire,
+		},
+		&commandSpec{
+			name:     "TTL",
+			group:    "generic",
+			summary:  "Returns the expiration time in seconds of a key.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleTTL,
+		},
+		&commandSpec{
+			name:     "PTTL",
+			group:    "generic",
+			summary:  "Returns the expiration time in milliseconds of a key.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleTTL,
+		},
+		&commandSpec{
+			name:     "EXPIRETIME",
+			group:    "generic",
+			summary:  "Returns the expiration time of a key as a Unix timestamp.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleTTL,
+		},
+		&commandSpec{
+			name:     "PEXPIRETIME",
+			group:    "generic",
+			summary:  "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleTTL,
+		},
+		&commandSpec{
+			name:     "PERSIST",
+			group:    "generic",
+			summary:  "Removes the expiration time of a key.",
+			arity:    2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePersist,
+		},
+	)
+}
+
+func (s *server) handleDel(req *Request) ([]resp.Value, error) {
+	now := time.Now()
+	deleted := 0
+	for _, key := range req.Args {
+		// expired keys are removed too but do not count as deleted
+		_, live := s.InMemoryStore.lookup(key, now)
+		if s.InMemoryStore.delete(key) && live {
+			deleted++
+		}
+	}
+	s.addDirty(deleted)
+	return reply(resp.Integer(int64(deleted)))
+}
+
+func (s *server) handleExists(req *Request) ([]resp.Value, error) {
+	now := time.Now()
+	count := 0
+	// a key given several times is counted several times
+	for _, key := range req.Args {
+		if _, ok := s.InMemoryStore.lookup(key, now); ok {
+			count++
+		}
+	}
+	return reply(resp.Integer(int64(count)))
+}
+
+func (s *server) handleType(req *Request) ([]resp.Value, error) {
+	res, ok := s.InMemoryStore.lookup(req.Args[0], time.Now())
+	if !ok {
+		return reply(resp.SimpleString("none"))
+	}
+	return reply(resp.SimpleString(typeName(res)))
+}
+
+func (s *server) handleRename(req *Request) ([]resp.Value, error) {
+	onlyIfMissing := strings.EqualFold(string(req.Command), "RENAMENX")
+	src, dst := req.Args[0], req.Args[1]
+	now := time.Now()
+	res, ok := s.InMemoryStore.lookup(src, now)
+	if !ok {
+		return nil, errNoSuchKey
+	}
+	if onlyIfMissing {
+		if _, exists := s.InMemoryStore.lookup(dst, now); exists {
+			return reply(resp.Integer(0))
+		}
+	}
+	if src != dst {
+		// the resource moves with its expiry
+		s.InMemoryStore.delete(src)
+		s.InMemoryStore.set(dst, res)
+		s.signalKeyReady(dst)
+		s.addDirty(1)
+	}
+	if onlyIfMissing {
+		return reply(resp.Integer(1))
+	}
+	return reply(resp.OK)
+}
+
+func (s *server) handleExpire(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	key := req.Args[0]
+	n, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	var nx, xx, gt, lt bool
+	for _, arg := range req.Args[2:] {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return nil, errors.New("ERR Unsupported option " + arg)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return nil, errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return nil, errors.New("ERR GT and LT options at the same time are not compatible")
+	}
+
+	unit := time.Second
+	if strings.HasPrefix(command, "P") {
+		unit = time.Millisecond
+	}
+	now := time.Now()
+	deadline, ok := expiryTime(n, unit, strings.HasSuffix(command, "AT"), now)
+	if !ok {
+		return nil, errors.New("ERR invalid expire time in '" + strings.ToLower(command) + "' command")
+	}
+	res, exists := s.InMemoryStore.lookup(key, now)
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	// a key without expiry behaves as if it had an infinite ttl for GT and LT
+	switch {
+	case nx && res.expired != nil,
+		xx && res.expired == nil,
+		gt && (res.expired == nil || !deadline.After(*res.expired)),
+		lt && res.expired != nil && !deadline.Before(*res.expired):
+		return reply(resp.Integer(0))
+	}
+	s.addDirty(1)
+	if s.InMemoryStore.alreadyExpired(deadline, now) {
+		s.InMemoryStore.delete(key)
+		return reply(resp.Integer(1))
+	}
+	s.InMemoryStore.setExpiry(key, res, &deadline)
+	return reply(resp.Integer(1))
+}
+
+func (s *server) handleTTL(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	now := time.Now()
+	res, ok := s.InMemoryStore.lookup(req.Args[0], now)
+	if !ok {
+		return reply(resp.Integer(-2))
+	}
+	if res.expired == nil {
+		return reply(resp.Integer(-1))
+	}
+	switch command {
+	case "EXPIRETIME":
+		return reply(resp.Integer(res.expired.Unix()))
+	case "PEXPIRETIME":
+		return reply(resp.Integer(res.expired.UnixMilli()))
+	}
+	remaining := res.expired.Sub(now).Milliseconds()
+	if command == "TTL" {
+		// rounded to the closest second like redis
+		return reply(resp.Integer((remaining + 500) / 1000))
+	}
+	return reply(resp.Integer(remaining))
+}
+
+func (s *server) handlePersist(req *Request) ([]resp.Value, error) {
+	res, ok := s.InMemoryStore.lookup(req.Args[0], time.Now())
+	if !ok || res.expired == nil {
+		return reply(resp.Integer(0))
+	}
+	s.InMemoryStore.setExpiry(req.Args[0], res, nil)
+	s.addDirty(1)
+	return reply(resp.Integer(1))
+}
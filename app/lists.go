@@ -0,0 +1,796 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var (
+	errIndexRange     = errors.New("ERR index out of range")
+	errNotPositive    = errors.New("ERR value is out of range, must be positive")
+	errRankZero       = errors.New("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+	errCountNegative  = errors.New("ERR COUNT can't be negative")
+	errMaxLenNegative = errors.New("ERR MAXLEN can't be negative")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "LPUSH",
+			group:    "list",
+			summary:  "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePush,
+		},
+		&commandSpec{
+			name:     "RPUSH",
+			group:    "list",
+			summary:  "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePush,
+		},
+		&commandSpec{
+			name:     "LPUSHX",
+			group:    "list",
+			summary:  "Prepends one or more elements to a list only when the list exists.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePush,
+		},
+		&commandSpec{
+			name:     "RPUSHX",
+			group:    "list",
+			summary:  "Appends an element to a list only when the list exists.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePush,
+		},
+		&commandSpec{
+			name:     "LPOP",
+			group:    "list",
+			summary:  "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePop,
+		},
+		&commandSpec{
+			name:     "RPOP",
+			group:    "list",
+			summary:  "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handlePop,
+		},
+		&commandSpec{
+			name:     "LRANGE",
+			group:    "list",
+			summary:  "Returns a range of elements from a list.",
+			arity:    4,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLRange,
+		},
+		&commandSpec{
+			name:     "LINDEX",
+			group:    "list",
+			summary:  "Returns an element from a list by its index.",
+			arity:    3,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLIndex,
+		},
+		&commandSpec{
+			name:     "LSET",
+			group:    "list",
+			summary:  "Sets the value of an element in a list by its index.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLSet,
+		},
+		&commandSpec{
+			name:     "LLEN",
+			group:    "list",
+			summary:  "Returns the length of a list.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLLen,
+		},
+		&commandSpec{
+			name:     "LREM",
+			group:    "list",
+			summary:  "Removes elements from a list. Deletes the list if the last element was removed.",
+			arity:    4,
+			flags:    flagWrite,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLRem,
+		},
+		&commandSpec{
+			name:     "LTRIM",
+			group:    "list",
+			summary:  "Removes elements from both ends a list. Deletes the list if all elements were trimmed.",
+			arity:    4,
+			flags:    flagWrite,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLTrim,
+		},
+		&commandSpec{
+			name:     "LINSERT",
+			group:    "list",
+			summary:  "Inserts an element before or after another element in a list.",
+			arity:    5,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLInsert,
+		},
+		&commandSpec{
+			name:     "LPOS",
+			group:    "list",
+			summary:  "Returns the index of matching elements in a list.",
+			arity:    -3,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleLPos,
+		},
+		&commandSpec{
+			name:     "LMOVE",
+			group:    "list",
+			summary:  "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.",
+			arity:    5,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleLMove,
+		},
+		&commandSpec{
+			name:     "RPOPLPUSH",
+			group:    "list",
+			summary:  "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleLMove,
+		},
+		&commandSpec{
+			name:     "LMPOP",
+			group:    "list",
+			summary:  "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.",
+			arity:    -4,
+			flags:    flagWrite,
+			keysFunc: numkeysKeys(0),
+			handler:  (*server).handleLMPop,
+		},
+		&commandSpec{
+			name:     "BLPOP",
+			group:    "list",
+			summary:  "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			arity:    -3,
+			flags:    flagWrite | flagBlocking,
+			firstKey: 1, lastKey: -2, keyStep: 1,
+			handler: (*server).handleBlockingPop,
+		},
+		&commandSpec{
+			name:     "BRPOP",
+			group:    "list",
+			summary:  "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			arity:    -3,
+			flags:    flagWrite | flagBlocking,
+			firstKey: 1, lastKey: -2, keyStep: 1,
+			handler: (*server).handleBlockingPop,
+		},
+		&commandSpec{
+			name:     "BLMOVE",
+			group:    "list",
+			summary:  "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.",
+			arity:    6,
+			flags:    flagWrite | flagDenyOOM | flagBlocking,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleBlockingMove,
+		},
+		&commandSpec{
+			name:     "BRPOPLPUSH",
+			group:    "list",
+			summary:  "Pops an element from a list, pushes it to another list and returns it. Block until an element is available otherwise. Deletes the list if the last element was popped.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM | flagBlocking,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleBlockingMove,
+		},
+		&commandSpec{
+			name:     "BLMPOP",
+			group:    "list",
+			summary:  "Pops the first element from one of multiple lists. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			arity:    -5,
+			flags:    flagWrite | flagBlocking,
+			keysFunc: numkeysKeys(1),
+			handler:  (*server).handleLMPop,
+		},
+	)
+}
+
+// lookupList returns the live list stored at key, it fails with WRONGTYPE when
+// the key holds another type.
+func (s *server) lookupList(key string, now time.Time) (*deque, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+	list, isList := res.value.(*deque)
+	if !isList {
+		return nil, false, errWrongType
+	}
+	return list, true, nil
+}
+
+// deleteIfEmpty removes a list left without elements, keys never hold empty lists.
+func (s *server) deleteIfEmpty(key string, list *deque) {
+	if list.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	}
+}
+
+func parseIndex(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return n, nil
+}
+
+// parseDirection parses the LEFT|RIGHT arguments of LMOVE and friends.
+func parseDirection(arg string) (left bool, err error) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	default:
+		return false, errSyntax
+	}
+}
+
+func (s *server) handlePush(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	key := req.Args[0]
+	list, exists, err := s.lookupList(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if strings.HasSuffix(command, "X") {
+			return reply(resp.Integer(0))
+		}
+		list = newDeque()
+		s.InMemoryStore.set(key, &Resource{value: list})
+	}
+	for _, element := range req.Args[1:] {
+		pushElement(list, command[0] == 'L', element)
+	}
+	s.addDirty(len(req.Args) - 1)
+	s.signalKeyReady(key)
+	return reply(resp.Integer(int64(list.Len())))
+}
+
+func popElement(list *deque, left bool) string {
+	if left {
+		return list.PopFront()
+	}
+	return list.PopBack()
+}
+
+func pushElement(list *deque, left bool, element string) {
+	if left {
+		list.PushFront(element)
+	} else {
+		list.PushBack(element)
+	}
+}
+
+func (s *server) handlePop(req *Request) ([]resp.Value, error) {
+	left := strings.EqualFold(string(req.Command), "LPOP")
+	key := req.Args[0]
+	if len(req.Args) > 2 {
+		return nil, errSyntax
+	}
+	count, withCount := 1, len(req.Args) == 2
+	if withCount {
+		n, err := strconv.Atoi(req.Args[1])
+		if err != nil || n < 0 {
+			return nil, errNotPositive
+		}
+		count = n
+	}
+	list, exists, err := s.lookupList(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if withCount {
+			return reply(resp.NullArray())
+		}
+		return reply(resp.NullBulk())
+	}
+	if !withCount {
+		element := popElement(list, left)
+		s.deleteIfEmpty(key, list)
+		s.addDirty(1)
+		return reply(resp.BulkString(element))
+	}
+	popped := []string{}
+	for len(popped) < count && list.Len() > 0 {
+		popped = append(popped, popElement(list, left))
+	}
+	s.deleteIfEmpty(key, list)
+	s.addDirty(len(popped))
+	return reply(resp.BulkStrings(popped))
+}
+
+func (s *server) handleLRange(req *Request) ([]resp.Value, error) {
+	start, err := parseIndex(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseIndex(req.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	list, exists, err := s.lookupList(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Array())
+	}
+	from, to, ok := normalizeRange(int64(start), int64(end), int64(list.Len()))
+	if !ok {
+		return reply(resp.Array())
+	}
+	return reply(resp.BulkStrings(list.Range(int(from), int(to))))
+}
+
+// listIndex converts an index that may count from the end into a position, it
+// reports false when the index is out of range.
+func listIndex(list *deque, index int) (int, bool) {
+	if index < 0 {
+		index += list.Len()
+	}
+	return index, index >= 0 && index < list.Len()
+}
+
+func (s *server) handleLIndex(req *Request) ([]resp.Value, error) {
+	index, err := parseIndex(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	list, exists, err := s.lookupList(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.NullBulk())
+	}
+	i, ok := listIndex(list, index)
+	if !ok {
+		return reply(resp.NullBulk())
+	}
+	return reply(resp.BulkString(list.At(i)))
+}
+
+func (s *server) handleLSet(req *Request) ([]resp.Value, error) {
+	index, err := parseIndex(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	list, exists, err := s.lookupList(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoSuchKey
+	}
+	i, ok := listIndex(list, index)
+	if !ok {
+		return nil, errIndexRange
+	}
+	list.Set(i, req.Args[2])
+	s.addDirty(1)
+	return reply(resp.OK)
+}
+
+func (s *server) handleLLen(req *Request) ([]resp.Value, error) {
+	list, exists, err := s.lookupList(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(int64(list.Len())))
+}
+
+func (s *server) handleLRem(req *Request) ([]resp.Value, error) {
+	key, element := req.Args[0], req.Args[2]
+	count, err := parseIndex(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	list, exists, err := s.lookupList(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	items := list.Range(0, list.Len()-1)
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	keep := make([]bool, len(items))
+	for n := 0; n < len(items); n++ {
+		// a negative count removes from the tail
+		i := n
+		if count < 0 {
+			i = len(items) - 1 - n
+		}
+		if items[i] == element && (limit == 0 || removed < limit) {
+			removed++
+			continue
+		}
+		keep[i] = true
+	}
+	remaining := items[:0]
+	for i, item := range items {
+		if keep[i] {
+			remaining = append(remaining, item)
+		}
+	}
+	list.replace(remaining)
+	s.deleteIfEmpty(key, list)
+	s.addDirty(removed)
+	return reply(resp.Integer(int64(removed)))
+}
+
+func (s *server) handleLTrim(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	start, err := parseIndex(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseIndex(req.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	list, exists, err := s.lookupList(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.OK)
+	}
+	from, to, ok := normalizeRange(int64(start), int64(end), int64(list.Len()))
+	if !ok {
+		s.addDirty(list.Len())
+		s.InMemoryStore.delete(key)
+		return reply(resp.OK)
+	}
+	s.addDirty(list.Len() - int(to-from+1))
+	list.replace(list.Range(int(from), int(to)))
+	return reply(resp.OK)
+}
+
+func (s *server) handleLInsert(req *Request) ([]resp.Value, error) {
+	key, pivot, element := req.Args[0], req.Args[2], req.Args[3]
+	var after bool
+	switch strings.ToUpper(req.Args[1]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return nil, errSyntax
+	}
+	list, exists, err := s.lookupList(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	for i := 0; i < list.Len(); i++ {
+		if list.At(i) != pivot {
+			continue
+		}
+		if after {
+			i++
+		}
+		list.Insert(i, element)
+		s.addDirty(1)
+		return reply(resp.Integer(int64(list.Len())))
+	}
+	return reply(resp.Integer(-1))
+}
+
+func (s *server) handleLPos(req *Request) ([]resp.Value, error) {
+	key, element := req.Args[0], req.Args[1]
+	rank, count, maxLen := 1, -1, 0
+	args := req.Args[2:]
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		n, err := parseIndex(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return nil, errRankZero
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return nil, errCountNegative
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return nil, errMaxLenNegative
+			}
+			maxLen = n
+		default:
+			return nil, errSyntax
+		}
+	}
+	list, exists, err := s.lookupList(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if count >= 0 {
+			return reply(resp.Array())
+		}
+		return reply(resp.NullBulk())
+	}
+
+	positions := []resp.Value{}
+	// a negative rank searches from the tail
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	for n := 0; n < list.Len() && (maxLen == 0 || n < maxLen); n++ {
+		i := n
+		if rank < 0 {
+			i = list.Len() - 1 - n
+		}
+		if list.At(i) != element {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		positions = append(positions, resp.Integer(int64(i)))
+		if count < 0 || (count > 0 && len(positions) == count) {
+			break
+		}
+	}
+	if count < 0 {
+		if len(positions) == 0 {
+			return reply(resp.NullBulk())
+		}
+		return reply(positions[0])
+	}
+	return reply(resp.Array(positions...))
+}
+
+func (s *server) handleLMove(req *Request) ([]resp.Value, error) {
+	src, dst := req.Args[0], req.Args[1]
+	// RPOPLPUSH is LMOVE source destination RIGHT LEFT
+	fromLeft, toLeft := false, true
+	if len(req.Args) == 4 {
+		var err error
+		if fromLeft, err = parseDirection(req.Args[2]); err != nil {
+			return nil, err
+		}
+		if toLeft, err = parseDirection(req.Args[3]); err != nil {
+			return nil, err
+		}
+	}
+	element, ok, err := s.moveElement(src, dst, fromLeft, toLeft, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return reply(resp.NullBulk())
+	}
+	return reply(resp.BulkString(element))
+}
+
+// moveElement pops an element from src and pushes it to dst, the destination is
+// type checked before anything is popped.
+func (s *server) moveElement(src, dst string, fromLeft, toLeft bool, now time.Time) (string, bool, error) {
+	source, exists, err := s.lookupList(src, now)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	destination, dstExists, err := s.lookupList(dst, now)
+	if err != nil {
+		return "", false, err
+	}
+	element := popElement(source, fromLeft)
+	if !dstExists {
+		destination = newDeque()
+		s.InMemoryStore.set(dst, &Resource{value: destination})
+	}
+	pushElement(destination, toLeft, element)
+	s.addDirty(1)
+	s.signalKeyReady(dst)
+	// checked after the push since source and destination can be the same list
+	s.deleteIfEmpty(src, source)
+	return element, true, nil
+}
+
+// handleBlockingPop serves BLPOP and BRPOP, popping from the first non empty
+// list among the keys.
+func (s *server) handleBlockingPop(req *Request) ([]resp.Value, error) {
+	left := strings.EqualFold(string(req.Command), "BLPOP")
+	keys := req.Args[:len(req.Args)-1]
+	timeout, err := parseTimeout(req.Args[len(req.Args)-1])
+	if err != nil {
+		return nil, err
+	}
+	serve := func(key string) (resp.Value, bool, error) {
+		list, exists, err := s.lookupList(key, time.Now())
+		if err != nil || !exists {
+			return resp.Value{}, false, err
+		}
+		element := popElement(list, left)
+		s.deleteIfEmpty(key, list)
+		s.addDirty(1)
+		return resp.BulkStrings([]string{key, element}), true, nil
+	}
+	for _, key := range keys {
+		value, ok, err := serve(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return reply(value)
+		}
+	}
+	return s.block(req, &blockedClient{
+		valueType:    "list",
+		keys:         keys,
+		serve:        serve,
+		timeout:      timeout,
+		timeoutReply: resp.NullArray(),
+	})
+}
+
+// handleBlockingMove serves BLMOVE and BRPOPLPUSH.
+func (s *server) handleBlockingMove(req *Request) ([]resp.Value, error) {
+	src, dst := req.Args[0], req.Args[1]
+	// BRPOPLPUSH is BLMOVE source destination RIGHT LEFT timeout
+	fromLeft, toLeft := false, true
+	if len(req.Args) == 5 {
+		var err error
+		if fromLeft, err = parseDirection(req.Args[2]); err != nil {
+			return nil, err
+		}
+		if toLeft, err = parseDirection(req.Args[3]); err != nil {
+			return nil, err
+		}
+	}
+	timeout, err := parseTimeout(req.Args[len(req.Args)-1])
+	if err != nil {
+		return nil, err
+	}
+	serve := func(key string) (resp.Value, bool, error) {
+		element, ok, err := s.moveElement(src, dst, fromLeft, toLeft, time.Now())
+		if err != nil || !ok {
+			return resp.Value{}, false, err
+		}
+		return resp.BulkString(element), true, nil
+	}
+	value, ok, err := serve(src)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return reply(value)
+	}
+	return s.block(req, &blockedClient{
+		valueType:    "list",
+		keys:         []string{src},
+		extraKeys:    []string{dst},
+		serve:        serve,
+		timeout:      timeout,
+		timeoutReply: resp.NullBulk(),
+	})
+}
+
+// handleLMPop serves LMPOP and BLMPOP, popping up to count elements from the
+// first non empty list among the keys.
+func (s *server) handleLMPop(req *Request) ([]resp.Value, error) {
+	blocking := strings.EqualFold(string(req.Command), "BLMPOP")
+	args := req.Args
+	var timeout time.Duration
+	if blocking {
+		var err error
+		if timeout, err = parseTimeout(args[0]); err != nil {
+			return nil, err
+		}
+		args = args[1:]
+	}
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil || numkeys <= 0 {
+		return nil, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numkeys > len(args)-2 {
+		return nil, errSyntax
+	}
+	keys, rest := args[1:1+numkeys], args[1+numkeys:]
+	left, err := parseDirection(rest[0])
+	if err != nil {
+		return nil, err
+	}
+	count := 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.EqualFold(rest[1], "COUNT"):
+		count, err = strconv.Atoi(rest[2])
+		if err != nil || count <= 0 {
+			return nil, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, errSyntax
+	}
+
+	serve := func(key string) (resp.Value, bool, error) {
+		list, exists, err := s.lookupList(key, time.Now())
+		if err != nil || !exists {
+			return resp.Value{}, false, err
+		}
+		popped := []string{}
+		for len(popped) < count && list.Len() > 0 {
+			popped = append(popped, popElement(list, left))
+		}
+		s.deleteIfEmpty(key, list)
+		s.addDirty(len(popped))
+		return resp.Array(resp.BulkString(key), resp.BulkStrings(popped)), true, nil
+	}
+	for _, key := range keys {
+		value, ok, err := serve(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return reply(value)
+		}
+	}
+	if !blocking {
+		return reply(resp.NullArray())
+	}
+	return s.block(req, &blockedClient{
+		valueType:    "list",
+		keys:         keys,
+		serve:        serve,
+		timeout:      timeout,
+		timeoutReply: resp.NullArray(),
+	})
+}
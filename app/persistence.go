@@ -0,0 +1,262 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// bgsaveRetryDelay is how long automatic saves wait after a failed one.
+const bgsaveRetryDelay = 5 * time.Second
+
+var (
+	errBgsaveInProgress = errors.New("ERR Background save already in progress")
+	errMisconf          = errors.New("MISCONF Redis is configured to save RDB snapshots, but it's currently unable to persist to disk. Commands that may modify the data set are disabled, because this instance is configured to report errors during writes if RDB snapshotting fails (stop-writes-on-bgsave-error option). Please check the Redis logs for details about the RDB error.")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:    "SAVE",
+			group:   "server",
+			summary: "Synchronously saves the database(s) to disk.",
+			arity:   1,
+			flags:   flagAdmin | flagNoScript,
+			handler: (*server).handleSave,
+		},
+		&commandSpec{
+			name:    "BGSAVE",
+			group:   "server",
+			summary: "Asynchronously saves the database(s) to disk.",
+			arity:   -1,
+			flags:   flagAdmin | flagNoScript,
+			handler: (*server).handleBgsave,
+		},
+		&commandSpec{
+			name:    "LASTSAVE",
+			group:   "server",
+			summary: "Returns the Unix timestamp of the last successful save to disk.",
+			arity:   1,
+			flags:   flagLoading | flagStale | flagFast,
+			handler: (*server).handleLastSave,
+		},
+	)
+}
+
+// rdbState tracks the dumps of the keyspace to the RDB file.
+type rdbState struct {
+	// saveMu serializes the writes of the dump
+	saveMu sync.Mutex
+	// dirty counts the changes to the keyspace since the last save
+	dirty atomic.Int64
+
+	mu              sync.Mutex
+	bgsaveRunning   bool
+	bgsaveScheduled bool
+	bgsaveStart     time.Time
+	lastSave        time.Time
+	lastBgsaveTry   time.Time
+	lastBgsaveOK    bool
+	// lastBgsaveDuration is -1 until a background save completes
+	lastBgsaveDuration time.Duration
+}
+
+func newRDBState() *rdbState {
+	return &rdbState{lastSave: time.Now(), lastBgsaveOK: true, lastBgsaveDuration: -1}
+}
+
+func (s *server) rdbFilename() string {
+	return filepath.Join(s.Config.Dir, s.Config.DbFilename)
+}
+
+// snapshot copies the keyspace for a save, along with the number of changes
+// the copy holds.
+func (s *server) snapshot() (map[string]*Resource, int64) {
+	dirty := s.rdb.dirty.Load()
+	return s.InMemoryStore.snapshot(), dirty
+}
+
+// addDirty counts n changes to the keyspace towards the save rules. Write
+// commands call it with what they actually changed, like redis commands add to
+// server.dirty, so that commands changing nothing don't trigger saves.
+func (s *server) addDirty(n int) {
+	s.rdb.dirty.Add(int64(n))
+}
+
+// saveSnapshot writes items to the RDB file, the changes they hold no longer
+// count as unsaved once written.
+func (s *server) saveSnapshot(items map[string]*Resource, dirty int64) error {
+	s.rdb.saveMu.Lock()
+	defer s.rdb.saveMu.Unlock()
+	if err := WriteRedisDBFile(s.rdbFilename(), items); err != nil {
+		return err
+	}
+	s.rdb.dirty.Add(-dirty)
+	s.rdb.mu.Lock()
+	s.rdb.lastSave = time.Now()
+	s.rdb.mu.Unlock()
+	return nil
+}
+
+// startBgsave snapshots the keyspace and writes it in the background. It
+// reports false when a background save is already running, in which case a new
+// one starts once it completes if schedule is set.
+func (s *server) startBgsave(schedule bool) bool {
+	s.rdb.mu.Lock()
+	if s.rdb.bgsaveRunning {
+		s.rdb.bgsaveScheduled = s.rdb.bgsaveScheduled || schedule
+		s.rdb.mu.Unlock()
+		return false
+	}
+	s.rdb.bgsaveRunning = true
+	s.rdb.bgsaveStart = time.Now()
+	s.rdb.lastBgsaveTry = s.rdb.bgsaveStart
+	s.rdb.mu.Unlock()
+
+	items, dirty := s.snapshot()
+	go func() {
+		err := s.saveSnapshot(items, dirty)
+		if err != nil {
+			log.Printf("background save failed: %v", err)
+		}
+		s.rdb.mu.Lock()
+		s.rdb.bgsaveRunning = false
+		s.rdb.lastBgsaveOK = err == nil
+		s.rdb.lastBgsaveDuration = time.Since(s.rdb.bgsaveStart)
+		scheduled := s.rdb.bgsaveScheduled
+		s.rdb.bgsaveScheduled = false
+		s.rdb.mu.Unlock()
+		if scheduled {
+			s.startBgsave(false)
+		}
+	}()
+	return true
+}
+
+func (s *server) handleSave(req *Request) ([]resp.Value, error) {
+	s.rdb.mu.Lock()
+	running := s.rdb.bgsaveRunning
+	s.rdb.mu.Unlock()
+	if running {
+		return nil, errBgsaveInProgress
+	}
+	if err := s.saveSnapshot(s.snapshot()); err != nil {
+		log.Printf("save failed: %v", err)
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	// a successful save lifts the refusal of writes, like in redis
+	s.rdb.mu.Lock()
+	s.rdb.lastBgsaveOK = true
+	s.rdb.mu.Unlock()
+	return reply(resp.OK)
+}
+
+// handleBgsave starts a background save. With SCHEDULE, a save requested while
+// another one runs starts once it completes instead of failing.
+func (s *server) handleBgsave(req *Request) ([]resp.Value, error) {
+	schedule := false
+	switch {
+	case len(req.Args) == 1 && strings.EqualFold(req.Args[0], "SCHEDULE"):
+		schedule = true
+	case len(req.Args) > 0:
+		return nil, errSyntax
+	}
+	if s.startBgsave(schedule) {
+		return reply(resp.SimpleString("Background saving started"))
+	}
+	if !schedule {
+		return nil, errBgsaveInProgress
+	}
+	return reply(resp.SimpleString("Background saving scheduled"))
+}
+
+func (s *server) handleLastSave(req *Request) ([]resp.Value, error) {
+	s.rdb.mu.Lock()
+	defer s.rdb.mu.Unlock()
+	return reply(resp.Integer(s.rdb.lastSave.Unix()))
+}
+
+// writesRefused returns the error write commands fail with while the data
+// can't be persisted: the last background save failed while automatic saves
+// are configured, or the append only file can't be written.
+func (s *server) writesRefused() error {
+	if s.Config.StopWritesOnBgsaveError && len(s.Config.SaveRules) > 0 {
+		s.rdb.mu.Lock()
+		ok := s.rdb.lastBgsaveOK
+		s.rdb.mu.Unlock()
+		if !ok {
+			return errMisconf
+		}
+	}
+	if err := s.aofError(); err != nil {
+		return fmt.Errorf("MISCONF Errors writing to the AOF file: %v", err)
+	}
+	return nil
+}
+
+// saveLoop checks the save rules hz times per second.
+func (s *server) saveLoop() {
+	hz := s.Config.Hz
+	if hz <= 0 {
+		hz = defaultHz
+	}
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.checkSaveRules(now)
+	}
+}
+
+// checkSaveRules starts a background save when a save rule is met. After a
+// failed save, the next attempt waits for bgsaveRetryDelay.
+func (s *server) checkSaveRules(now time.Time) {
+	s.rdb.mu.Lock()
+	running := s.rdb.bgsaveRunning
+	lastSave, lastTry, ok := s.rdb.lastSave, s.rdb.lastBgsaveTry, s.rdb.lastBgsaveOK
+	s.rdb.mu.Unlock()
+	if running || (!ok && now.Sub(lastTry) <= bgsaveRetryDelay) {
+		return
+	}
+	dirty := s.rdb.dirty.Load()
+	for _, rule := range s.Config.SaveRules {
+		if dirty >= int64(rule.Changes) && now.Sub(lastSave) > time.Duration(rule.Seconds)*time.Second {
+			log.Printf("%d changes in %d seconds. Saving...", rule.Changes, rule.Seconds)
+			s.startBgsave(false)
+			return
+		}
+	}
+}
+
+func (s *server) persistenceInfo() []string {
+	s.rdb.mu.Lock()
+	defer s.rdb.mu.Unlock()
+	inProgress, current := 0, int64(-1)
+	if s.rdb.bgsaveRunning {
+		inProgress, current = 1, int64(time.Since(s.rdb.bgsaveStart).Seconds())
+	}
+	status := "ok"
+	if !s.rdb.lastBgsaveOK {
+		status = "err"
+	}
+	last := int64(-1)
+	if s.rdb.lastBgsaveDuration >= 0 {
+		last = int64(s.rdb.lastBgsaveDuration.Seconds())
+	}
+	lines := []string{
+		"loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", s.rdb.dirty.Load()),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", inProgress),
+		fmt.Sprintf("rdb_last_save_time:%d", s.rdb.lastSave.Unix()),
+		"rdb_last_bgsave_status:" + status,
+		fmt.Sprintf("rdb_last_bgsave_time_sec:%d", last),
+		fmt.Sprintf("rdb_current_bgsave_time_sec:%d", current),
+	}
+	return append(lines, s.aofInfo()...)
+}
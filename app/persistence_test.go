@@ -0,0 +1,57 @@
+package app
+
+import "testing"
+
+// TestDirtyCountsChanges checks that the save rules count what write commands
+// changed, not how many ran.
+func TestDirtyCountsChanges(t *testing.T) {
+	s := newTestServer(t)
+	mustDo(t, s, "SET", "k", "v")
+	mustDo(t, s, "SADD", "s", "a")
+	mustDo(t, s, "RPUSH", "l", "a")
+	mustDo(t, s, "HSET", "h", "f", "v")
+	mustDo(t, s, "XGROUP", "CREATE", "x", "g", "$", "MKSTREAM")
+
+	tests := []struct {
+		args  []string
+		dirty int64
+	}{
+		{[]string{"DEL", "missing"}, 0},
+		{[]string{"SET", "k", "w", "NX"}, 0},
+		{[]string{"SETNX", "k", "w"}, 0},
+		{[]string{"MSETNX", "k", "w", "other", "w"}, 0},
+		{[]string{"SREM", "s", "b"}, 0},
+		{[]string{"HDEL", "h", "missing"}, 0},
+		{[]string{"LPOP", "missing"}, 0},
+		{[]string{"LPUSHX", "missing", "a"}, 0},
+		{[]string{"EXPIRE", "missing", "10"}, 0},
+		{[]string{"PERSIST", "k"}, 0},
+		{[]string{"ZADD", "z", "XX", "1", "a"}, 0},
+		{[]string{"SINTERSTORE", "dst", "s", "missing"}, 0},
+		{[]string{"XACK", "x", "g", "1-1"}, 0},
+		{[]string{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "x", ">"}, 0},
+
+		{[]string{"SET", "k", "w", "XX"}, 1},
+		{[]string{"DEL", "k", "missing"}, 1},
+		{[]string{"MSET", "a", "1", "b", "2"}, 2},
+		{[]string{"SADD", "s", "a", "b", "c"}, 2},
+		{[]string{"HSET", "h", "f", "w"}, 1},
+		{[]string{"RPUSH", "l", "b", "c"}, 2},
+		{[]string{"LPOP", "l", "5"}, 3},
+		{[]string{"ZADD", "z", "1", "a", "2", "b"}, 2},
+		{[]string{"ZADD", "z", "1", "a", "3", "b"}, 1},
+		{[]string{"SINTERSTORE", "s", "missing"}, 1},
+		{[]string{"XADD", "x", "*", "f", "v"}, 1},
+		{[]string{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "x", ">"}, 1},
+	}
+	for _, tt := range tests {
+		before := s.rdb.dirty.Load()
+		if _, err := do(s, tt.args...); err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if got := s.rdb.dirty.Load() - before; got != tt.dirty {
+			t.Errorf("%v changed the dirty count by %d, want %d", tt.args, got, tt.dirty)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"strconv"
+)
+
+// Value types and opcodes of the RDB format.
+const (
+	rdbTypeString            = 0
+	rdbTypeList              = 1
+	rdbTypeSet               = 2
+	rdbTypeZSet              = 3
+	rdbTypeHash              = 4
+	rdbTypeZSet2             = 5
+	rdbTypeModule            = 6
+	rdbTypeModule2           = 7
+	rdbTypeHashZipmap        = 9
+	rdbTypeListZiplist       = 10
+	rdbTypeSetIntset         = 11
+	rdbTypeZSetZiplist       = 12
+	rdbTypeHashZiplist       = 13
+	rdbTypeListQuicklist     = 14
+	rdbTypeStreamListpacks   = 15
+	rdbTypeHashListpack      = 16
+	rdbTypeZSetListpack      = 17
+	rdbTypeListQuicklist2    = 18
+	rdbTypeStreamListpacks2  = 19
+	rdbTypeSetListpack       = 20
+	rdbTypeStreamListpacks3  = 21
+	rdbTypeHashMetadataPre   = 22
+	rdbTypeHashListpackExPre = 23
+	rdbTypeHashMetadata      = 24
+	rdbTypeHashListpackEx    = 25
+
+	rdbOpSlotInfo     = 244
+	rdbOpFunction2    = 245
+	rdbOpFunctionPre  = 246
+	rdbOpModuleAux    = 247
+	rdbOpIdle         = 248
+	rdbOpFreq         = 249
+	rdbOpAux          = 250
+	rdbOpResizeDB     = 251
+	rdbOpExpireTimeMs = 252
+	rdbOpExpireTime   = 253
+	rdbOpSelectDB     = 254
+	rdbOpEOF          = 255
+
+	// special encodings of strings, flagged by the two top bits of a length
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+
+	// opcodes of the values serialized by modules
+	rdbModuleOpEOF    = 0
+	rdbModuleOpSInt   = 1
+	rdbModuleOpUInt   = 2
+	rdbModuleOpFloat  = 3
+	rdbModuleOpDouble = 4
+	rdbModuleOpString = 5
+
+	// containers of the nodes of a quicklist
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+
+	// flags of the entries of a stream listpack
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+var errCorruptRDB = errors.New("corrupt RDB payload")
+
+// crcJones is the table of the CRC-64/Jones checksum trailing RDB files.
+var crcJones = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// rdbChecksum extends the checksum crc with p. Unlike the checksums of the
+// crc64 package, the one of redis has no initial or final inversion.
+func rdbChecksum(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcJones, p)
+}
+
+// lzfDecompress expands data compressed with LZF to its original length n.
+func lzfDecompress(data []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(data); {
+		ctrl := int(data[i])
+		i++
+		if ctrl < 1<<5 {
+			// literal run of ctrl+1 bytes
+			end := i + ctrl + 1
+			if end > len(data) {
+				return nil, errCorruptRDB
+			}
+			out = append(out, data[i:end]...)
+			i = end
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(data) {
+				return nil, errCorruptRDB
+			}
+			length += int(data[i])
+			i++
+		}
+		if i >= len(data) {
+			return nil, errCorruptRDB
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(data[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorruptRDB
+		}
+		// the reference may overlap the bytes being written
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, errCorruptRDB
+	}
+	return out, nil
+}
+
+// lzfCompress compresses data with LZF and reports false when the result would
+// not save at least 4 bytes, in which case data is better stored as is.
+func lzfCompress(data []byte) ([]byte, bool) {
+	const (
+		hashLog = 14
+		maxOff  = 1 << 13
+		maxRef  = 1<<8 + 1<<3
+		maxLit  = 1 << 5
+	)
+	limit := len(data) - 4
+	if limit <= 0 {
+		return nil, false
+	}
+	out := make([]byte, 0, limit)
+	var table [1 << hashLog]int
+	literals := 0
+	flush := func(end int) {
+		for literals > 0 {
+			n := min(literals, maxLit)
+			out = append(out, byte(n-1))
+			out = append(out, data[end-literals:end-literals+n]...)
+			literals -= n
+		}
+	}
+	i := 0
+	for i+2 < len(data) && len(out) < limit {
+		h := (uint32(data[i])<<16 | uint32(data[i+1])<<8 | uint32(data[i+2])) * 2654435761 >> (32 - hashLog)
+		// positions are stored plus one, zero meaning empty
+		ref := table[h] - 1
+		table[h] = i + 1
+		off := i - ref - 1
+		if ref < 0 || off >= maxOff || data[ref] != data[i] || data[ref+1] != data[i+1] || data[ref+2] != data[i+2] {
+			literals++
+			i++
+			continue
+		}
+		flush(i)
+		n := 3
+		for n < maxRef && i+n < len(data) && data[ref+n] == data[i+n] {
+			n++
+		}
+		if n-2 < 7 {
+			out = append(out, byte(off>>8)|byte(n-2)<<5)
+		} else {
+			out = append(out, byte(off>>8)|7<<5, byte(n-2-7))
+		}
+		out = append(out, byte(off))
+		i += n
+	}
+	literals += len(data) - i
+	flush(len(data))
+	if len(out) > limit {
+		return nil, false
+	}
+	return out, true
+}
+
+// listpackEntries returns the entries of a listpack as strings, integers are
+// formatted in decimal.
+func listpackEntries(lp []byte) ([]string, error) {
+	if len(lp) < 7 {
+		return nil, errCorruptRDB
+	}
+	if int(binary.LittleEndian.Uint32(lp)) != len(lp) {
+		return nil, errCorruptRDB
+	}
+	entries := make([]string, 0, binary.LittleEndian.Uint16(lp[4:]))
+	p := 6
+	for {
+		if p >= len(lp) {
+			return nil, errCorruptRDB
+		}
+		b := lp[p]
+		if b == 0xff {
+			return entries, nil
+		}
+		var size, header int
+		var entry string
+		switch {
+		case b&0x80 == 0:
+			size, entry = 1, strconv.Itoa(int(b))
+		case b&0xc0 == 0x80:
+			header, size = 1, int(b&0x3f)
+		case b&0xe0 == 0xc0:
+			if p+2 > len(lp) {
+				return nil, errCorruptRDB
+			}
+			v := int64(b&0x1f)<<8 | int64(lp[p+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			size, entry = 2, strconv.FormatInt(v, 10)
+		case b&0xf0 == 0xe0:
+			if p+2 > len(lp) {
+				return nil, errCorruptRDB
+			}
+			header, size = 2, int(b&0x0f)<<8|int(lp[p+1])
+		case b == 0xf0:
+			if p+5 > len(lp) {
+				return nil, errCorruptRDB
+			}
+			header, size = 5, int(binary.LittleEndian.Uint32(lp[p+1:]))
+		case b >= 0xf1 && b <= 0xf4:
+			width := [...]int{2, 3, 4, 8}[b-0xf1]
+			if p+1+width > len(lp) {
+				return nil, errCorruptRDB
+			}
+			size, entry = 1+width, strconv.FormatInt(littleEndianInt(lp[p+1:p+1+width]), 10)
+		default:
+			return nil, errCorruptRDB
+		}
+		if header > 0 {
+			if p+header+size > len(lp) {
+				return nil, errCorruptRDB
+			}
+			entry = string(lp[p+header : p+header+size])
+			size += header
+		}
+		entries = append(entries, entry)
+		// skip the entry and its back length
+		p += size + listpackBacklenSize(size)
+	}
+}
+
+// listpackBacklenSize is the number of bytes encoding the size of an entry
+// after it, so that a listpack can be walked backwards.
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// listpack builds a listpack, the encoding of the small collections of redis
+// that RDB files store as a single string.
+type listpack struct {
+	buf   []byte
+	count int
+}
+
+func newListpack() *listpack {
+	return &listpack{buf: make([]byte, 6, 64)}
+}
+
+// Append adds an entry, strings holding integers are stored as integers.
+func (lp *listpack) Append(entry string) {
+	start := len(lp.buf)
+	if n, err := strconv.ParseInt(entry, 10, 64); err == nil && strconv.FormatInt(n, 10) == entry {
+		lp.appendInt(n)
+	} else {
+		size := len(entry)
+		switch {
+		case size < 1<<6:
+			lp.buf = append(lp.buf, 0x80|byte(size))
+		case size < 1<<12:
+			lp.buf = append(lp.buf, 0xe0|byte(size>>8), byte(size))
+		default:
+			lp.buf = append(lp.buf, 0xf0)
+			lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(size))
+		}
+		lp.buf = append(lp.buf, entry...)
+	}
+	lp.appendBacklen(len(lp.buf) - start)
+	lp.count++
+}
+
+func (lp *listpack) appendInt(n int64) {
+	switch {
+	case n >= 0 && n <= 127:
+		lp.buf = append(lp.buf, byte(n))
+	case n >= -4096 && n <= 4095:
+		u := uint16(n) & 0x1fff
+		lp.buf = append(lp.buf, 0xc0|byte(u>>8), byte(u))
+	case n >= -1<<15 && n < 1<<15:
+		lp.buf = append(lp.buf, 0xf1)
+		lp.buf = binary.LittleEndian.AppendUint16(lp.buf, uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		lp.buf = append(lp.buf, 0xf2, byte(n), byte(n>>8), byte(n>>16))
+	case n >= -1<<31 && n < 1<<31:
+		lp.buf = append(lp.buf, 0xf3)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(n))
+	default:
+		lp.buf = append(lp.buf, 0xf4)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(n))
+	}
+}
+
+// appendBacklen stores the size of the entry just appended, most significant
+// bits first, 7 bits per byte, the high bit flagging the bytes that follow the
+// first one.
+func (lp *listpack) appendBacklen(size int) {
+	n := listpackBacklenSize(size)
+	for i := n - 1; i >= 0; i-- {
+		b := byte(size>>(7*i)) & 0x7f
+		if i < n-1 {
+			b |= 0x80
+		}
+		lp.buf = append(lp.buf, b)
+	}
+}
+
+// Len returns the size of the listpack once terminated.
+func (lp *listpack) Len() int {
+	return len(lp.buf) + 1
+}
+
+// Bytes terminates the listpack and returns it.
+func (lp *listpack) Bytes() []byte {
+	b := append(lp.buf, 0xff)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	// the count saturates, readers then walk the whole listpack
+	binary.LittleEndian.PutUint16(b[4:], uint16(min(lp.count, 0xffff)))
+	return b
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*uint(len(b))
+	return int64(v<<shift) >> shift
+}
+
+// ziplistEntries returns the entries of a ziplist, the listpack predecessor.
+func ziplistEntries(zl []byte) ([]string, error) {
+	if len(zl) < 11 || int(binary.LittleEndian.Uint32(zl)) != len(zl) {
+		return nil, errCorruptRDB
+	}
+	entries := make([]string, 0, binary.LittleEndian.Uint16(zl[8:]))
+	p := 10
+	for {
+		if p >= len(zl) {
+			return nil, errCorruptRDB
+		}
+		if zl[p] == 0xff {
+			return entries, nil
+		}
+		// skip the length of the previous entry
+		if zl[p] == 0xfe {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(zl) {
+			return nil, errCorruptRDB
+		}
+		b := zl[p]
+		var header, size int
+		var entry string
+		switch {
+		case b>>6 == 0:
+			header, size = 1, int(b&0x3f)
+		case b>>6 == 1:
+			if p+2 > len(zl) {
+				return nil, errCorruptRDB
+			}
+			header, size = 2, int(b&0x3f)<<8|int(zl[p+1])
+		case b>>6 == 2:
+			if p+5 > len(zl) {
+				return nil, errCorruptRDB
+			}
+			header, size = 5, int(binary.BigEndian.Uint32(zl[p+1:]))
+		case b >= 0xf1 && b <= 0xfd:
+			// immediate integer between 0 and 12
+			header, entry = 1, strconv.Itoa(int(b&0x0f)-1)
+		default:
+			var width int
+			switch b {
+			case 0xc0:
+				width = 2
+			case 0xd0:
+				width = 4
+			case 0xe0:
+				width = 8
+			case 0xf0:
+				width = 3
+			case 0xfe:
+				width = 1
+			default:
+				return nil, errCorruptRDB
+			}
+			if p+1+width > len(zl) {
+				return nil, errCorruptRDB
+			}
+			header, entry = 1+width, strconv.FormatInt(littleEndianInt(zl[p+1:p+1+width]), 10)
+		}
+		if b>>6 != 3 {
+			if p+header+size > len(zl) {
+				return nil, errCorruptRDB
+			}
+			entry = string(zl[p+header : p+header+size])
+		}
+		entries = append(entries, entry)
+		p += header + size
+	}
+}
+
+// zipmapEntries returns the field value pairs of a zipmap, the encoding of
+// small hashes before ziplists.
+func zipmapEntries(zm []byte) ([]string, error) {
+	var entries []string
+	p := 1
+	readLen := func() (int, bool) {
+		if p >= len(zm) {
+			return 0, false
+		}
+		b := zm[p]
+		switch {
+		case b < 254:
+			p++
+			return int(b), true
+		case b == 254 && p+5 <= len(zm):
+			n := int(binary.LittleEndian.Uint32(zm[p+1:]))
+			p += 5
+			return n, true
+		}
+		return 0, false
+	}
+	for {
+		if p >= len(zm) {
+			return nil, errCorruptRDB
+		}
+		if zm[p] == 0xff {
+			return entries, nil
+		}
+		n, ok := readLen()
+		if !ok || p+n > len(zm) {
+			return nil, errCorruptRDB
+		}
+		entries = append(entries, string(zm[p:p+n]))
+		p += n
+		n, ok = readLen()
+		if !ok || p+1+n > len(zm) {
+			return nil, errCorruptRDB
+		}
+		// values are followed by free space left by updates
+		free := int(zm[p])
+		p++
+		entries = append(entries, string(zm[p:p+n]))
+		p += n + free
+	}
+}
+
+// intsetMembers returns the integers of an intset.
+func intsetMembers(is []byte) ([]int64, error) {
+	if len(is) < 8 {
+		return nil, errCorruptRDB
+	}
+	width := int(binary.LittleEndian.Uint32(is))
+	n := int(binary.LittleEndian.Uint32(is[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(is) != 8+n*width {
+		return nil, errCorruptRDB
+	}
+	members := make([]int64, n)
+	for i := range members {
+		members[i] = littleEndianInt(is[8+i*width : 8+(i+1)*width])
+	}
+	return members, nil
+}
+
+// intsetBytes encodes sorted integers as an intset, with the smallest width
+// holding all of them.
+func intsetBytes(ints []int64) []byte {
+	width := 2
+	for _, n := range ints {
+		switch {
+		case n < -1<<31 || n >= 1<<31:
+			width = 8
+		case (n < -1<<15 || n >= 1<<15) && width < 4:
+			width = 4
+		}
+	}
+	b := make([]byte, 8, 8+len(ints)*width)
+	binary.LittleEndian.PutUint32(b, uint32(width))
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(ints)))
+	for _, n := range ints {
+		switch width {
+		case 2:
+			b = binary.LittleEndian.AppendUint16(b, uint16(n))
+		case 4:
+			b = binary.LittleEndian.AppendUint32(b, uint32(n))
+		default:
+			b = binary.LittleEndian.AppendUint64(b, uint64(n))
+		}
+	}
+	return b
+}
+
+// moduleTypeName decodes the name of a module type from its 64 bit ID, nine
+// characters of 6 bits followed by a 10 bits encoding version.
+func moduleTypeName(id uint64) string {
+	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	var name [9]byte
+	id >>= 10
+	for j := 8; j >= 0; j-- {
+		name[j] = charset[id&63]
+		id >>= 6
+	}
+	return string(name[:])
+}
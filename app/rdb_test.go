@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLZFRoundTrip(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name         string
+		data         []byte
+		compressible bool
+	}{
+		{"repeated byte", bytes.Repeat([]byte("a"), 1000), true},
+		{"repeated pattern", bytes.Repeat([]byte("abcdefgh"), 500), true},
+		{"long back reference", append(append(slices.Clone(random[:300]), random[:300]...), random[:300]...), true},
+		{"text", []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 20)), true},
+		{"random", random, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed, ok := lzfCompress(tt.data)
+			if ok != tt.compressible {
+				t.Fatalf("lzfCompress ok = %v, want %v", ok, tt.compressible)
+			}
+			if !ok {
+				return
+			}
+			if len(compressed) >= len(tt.data) {
+				t.Fatalf("compressed %d bytes to %d", len(tt.data), len(compressed))
+			}
+			got, err := lzfDecompress(compressed, len(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Fatal("decompressed data differs")
+			}
+		})
+	}
+}
+
+func TestListpackRoundTrip(t *testing.T) {
+	entries := []string{"", "a", strings.Repeat("x", 63), strings.Repeat("y", 64),
+		strings.Repeat("z", 4095), strings.Repeat("w", 4096), "007", "-0", "1.5", "12a"}
+	// integers around the bounds of every listpack integer encoding
+	for _, n := range []int64{0, 127, 128, -4096, 4095, 4096, math.MinInt16, math.MaxInt16,
+		-1 << 23, 1<<23 - 1, math.MinInt32, math.MaxInt32, math.MinInt64, math.MaxInt64} {
+		entries = append(entries, strconv.FormatInt(n, 10))
+	}
+	lp := newListpack()
+	for _, e := range entries {
+		lp.Append(e)
+	}
+	if lp.Len() != len(lp.Bytes()) {
+		t.Errorf("Len() = %d, encoded %d bytes", lp.Len(), len(lp.Bytes()))
+	}
+	got, err := listpackEntries(lp.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, entries) {
+		t.Errorf("listpackEntries = %q, want %q", got, entries)
+	}
+}
+
+func TestIntsetRoundTrip(t *testing.T) {
+	tests := []struct {
+		ints  []int64
+		width uint32
+	}{
+		{[]int64{-3, 0, 5, math.MaxInt16}, 2},
+		{[]int64{math.MinInt16 - 1, 1, math.MaxInt32}, 4},
+		{[]int64{math.MinInt64, -1, math.MaxInt32 + 1}, 8},
+	}
+	for _, tt := range tests {
+		is := intsetBytes(tt.ints)
+		if width := binary.LittleEndian.Uint32(is); width != tt.width {
+			t.Errorf("intsetBytes(%v) width = %d, want %d", tt.ints, width, tt.width)
+		}
+		got, err := intsetMembers(is)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.ints) {
+			t.Errorf("intsetMembers = %v, want %v", got, tt.ints)
+		}
+	}
+}
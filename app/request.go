@@ -1,9 +1,16 @@
 package app
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"strings"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
 )
 
 const (
@@ -12,123 +19,164 @@ const (
 
 type Command string
 
+// commands the replica sends to its master during the handshake, everything the
+// server understands is declared in the command table
 const (
 	PING     Command = "PING"
-	ECHO     Command = "ECHO"
-	SET      Command = "SET"
-	GET      Command = "GET"
-	CONFIG   Command = "CONFIG"
-	KEYS     Command = "KEYS"
-	INFO     Command = "INFO"
 	REPLCONF Command = "REPLCONF"
 	PSYNC    Command = "PSYNC"
 )
 
-func toCommand(str string) (Command, error) {
-	switch str {
-	case "PING":
-		return PING, nil
-	case "ECHO":
-		return ECHO, nil
-	case "SET":
-		return SET, nil
-	case "GET":
-		return GET, nil
-	case "CONFIG":
-		return CONFIG, nil
-	case "KEYS":
-		return KEYS, nil
-	case "INFO":
-		return INFO, nil
-	case "REPLCONF":
-		return REPLCONF, nil
-	case "PSYNC":
-		return PSYNC, nil
-	default:
-		return "", fmt.Errorf("Command %s not recognized", str)
-	}
-}
-
-func (c *Command) ToStr() string {
-	switch *c {
-	case PING:
-		return "PING"
-	case ECHO:
-		return "ECHO"
-	case SET:
-		return "SET"
-	case GET:
-		return "GET"
-	case CONFIG:
-		return "CONFIG"
-	case KEYS:
-		return "KEYS"
-	case INFO:
-		return "INFO"
-	case REPLCONF:
-		return "REPLCONF"
-	case PSYNC:
-		return "PSYNC"
-	default:
-		return ""
-	}
-}
-
 type Request struct {
 	nBytes  int
 	Command Command
 	Args    []string
+
+	// client is the connection the request came from, nil for internal requests
+	client *client
+	// block is set by blocking commands that found nothing to serve, the
+	// dispatcher waits on it once the key locks are released
+	block *blockedClient
 }
 
-func RequestParser(conn net.Conn) (*Request, error) {
-	fmt.Println("parsing request")
-	// buffer the conn
-	buffer := make([]byte, receiveBuf)
+const (
+	// limits mirror the defaults used by redis itself
+	maxInlineSize    = 64 * 1024
+	maxMultiBulkLen  = 1024 * 1024
+	maxBulkLen       = 512 * 1024 * 1024
+	readerBufferSize = maxInlineSize
+)
 
-	req := &Request{}
-	// start reading chunks delimited by newline byte
+// ProtocolError is returned when the client sends bytes that are not valid RESP,
+// the connection cannot be resynchronised afterwards and has to be closed.
+type ProtocolError struct {
+	msg string
+}
 
-	n, err := conn.Read(buffer)
-	if err != nil {
-		return nil, err
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("Protocol error: %s", e.msg)
+}
+
+// RequestReader decodes RESP requests from a buffered connection. Bytes read past
+// the end of a command stay in the buffer, so pipelined commands are returned one
+// by one on subsequent calls.
+type RequestReader struct {
+	r *bufio.Reader
+}
+
+func NewRequestReader(rd io.Reader) *RequestReader {
+	return &RequestReader{r: bufio.NewReaderSize(rd, readerBufferSize)}
+}
+
+// Buffered returns the number of bytes already received but not yet parsed.
+func (rr *RequestReader) Buffered() int {
+	return rr.r.Buffered()
+}
+
+func (rr *RequestReader) ReadRequest() (*Request, error) {
+	var (
+		parts  []string
+		nBytes int
+		err    error
+	)
+	for len(parts) == 0 {
+		// empty inline lines are skipped like redis does
+		var n int
+		parts, n, err = rr.readCommand()
+		nBytes += n
+		if err != nil {
+			return nil, err
+		}
 	}
-	req.nBytes = n
-	parsedArray := parseBulkBytes(buffer)
-	comm, err := toCommand(parsedArray[2])
-	if err != nil {
-		return nil, fmt.Errorf("command not recognized %s", parsedArray[2])
+	req := &Request{
+		nBytes: nBytes,
+		Args:   parts[1:],
 	}
-	req.Command = comm
-	// parse command args
-	req.Args = extractArgs(parsedArray)
-
+	// unknown commands are reported back to the client by the dispatcher
+	req.Command = Command(parts[0])
 	return req, nil
 }
 
-func parseBulkBytes(input []byte) []string {
-	// Convert byte slice to string
-	str := string(input)
-
-	// Trim any trailing \r\n to prevent an extra empty element after splitting
-	str = strings.TrimSuffix(str, "\r\n")
+func (rr *RequestReader) readCommand() ([]string, int, error) {
+	first, err := rr.r.Peek(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if first[0] == '*' {
+		return rr.readMultiBulk()
+	}
+	return rr.readInline()
+}
 
-	// Split the string by "\r\n"
-	parts := strings.Split(str, "\r\n")
+// readLine returns a line without its trailing \r\n together with the number
+// of bytes consumed from the stream.
+func (rr *RequestReader) readLine() ([]byte, int, error) {
+	line, err := rr.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, 0, &ProtocolError{msg: "too big inline request"}
+		}
+		if errors.Is(err, io.EOF) && len(line) > 0 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	n := len(line)
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	return line, n, nil
+}
 
-	return parts
+func (rr *RequestReader) readInline() ([]string, int, error) {
+	line, n, err := rr.readLine()
+	if err != nil {
+		return nil, n, err
+	}
+	return strings.Fields(string(line)), n, nil
 }
 
-func extractArgs(parsedArray []string) []string {
-	args := []string{}
-	// args start after position two in array
-	for _, chunk := range parsedArray[3 : len(parsedArray)-1] {
-		// check if first character is $
-		if chunk[0] == '$' {
-			continue
+func (rr *RequestReader) readMultiBulk() ([]string, int, error) {
+	line, total, err := rr.readLine()
+	if err != nil {
+		return nil, total, err
+	}
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count > maxMultiBulkLen {
+		return nil, total, &ProtocolError{msg: "invalid multibulk length"}
+	}
+	parts := make([]string, 0, max(count, 0))
+	for i := 0; i < count; i++ {
+		line, n, err := rr.readLine()
+		total += n
+		if err != nil {
+			return nil, total, unexpectedEOF(err)
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, total, &ProtocolError{msg: fmt.Sprintf("expected '$', got '%s'", line)}
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, total, &ProtocolError{msg: "invalid bulk length"}
 		}
-		args = append(args, chunk)
+		// read the payload by its declared length, it may contain \r\n itself
+		buf := make([]byte, size+2)
+		n, err = io.ReadFull(rr.r, buf)
+		total += n
+		if err != nil {
+			return nil, total, unexpectedEOF(err)
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, total, &ProtocolError{msg: "bulk string not terminated by CRLF"}
+		}
+		parts = append(parts, string(buf[:size]))
+	}
+	return parts, total, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
 	}
-	return args
+	return err
 }
 
 func sendRequestToMaster(conn net.Conn, req *Request) error {
@@ -136,8 +184,7 @@ func sendRequestToMaster(conn net.Conn, req *Request) error {
 	switch req.Command {
 	case PING:
 		// send as a single element resp array
-		message := "*1\r\n$4\r\nPING\r\n"
-		_, err := conn.Write([]byte(message))
+		_, err := conn.Write(resp.Encode(buildRespArray(req)))
 		if err != nil {
 			return fmt.Errorf("Failed to send data: %v", err)
 		}
@@ -151,8 +198,7 @@ func sendRequestToMaster(conn net.Conn, req *Request) error {
 	case REPLCONF:
 		switch req.Args[0] {
 		case "listening-port":
-			respArray := buildRespArray(req)
-			_, err := conn.Write([]byte(respArray))
+			_, err := conn.Write(resp.Encode(buildRespArray(req)))
 			if err != nil {
 				return fmt.Errorf("Failed to send REPLCONF req: %v", err)
 			}
@@ -164,8 +210,7 @@ func sendRequestToMaster(conn net.Conn, req *Request) error {
 			}
 			return nil
 		case "capa":
-			respArray := buildRespArray(req)
-			_, err := conn.Write([]byte(respArray))
+			_, err := conn.Write(resp.Encode(buildRespArray(req)))
 			if err != nil {
 				return fmt.Errorf("Failed to send REPLCONF req: %v", err)
 			}
@@ -180,8 +225,7 @@ func sendRequestToMaster(conn net.Conn, req *Request) error {
 			return fmt.Errorf("REPLCONG args not recognized")
 		}
 	case PSYNC:
-		respArray := buildRespArray(req)
-		_, err := conn.Write([]byte(respArray))
+		_, err := conn.Write(resp.Encode(buildRespArray(req)))
 		if err != nil {
 			return fmt.Errorf("Failed to send PSYNC req: %v", err)
 		}
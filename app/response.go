@@ -4,183 +4,238 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
 )
 
 const (
 	emptyRdbBase64 string = "UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog=="
 )
 
-func (s *server) parseResponses(req *Request) ([]string, error) {
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:    "PING",
+			group:   "connection",
+			summary: "Returns the server's liveliness response.",
+			arity:   -1,
+			flags:   flagFast | flagStale,
+			handler: (*server).handlePing,
+		},
+		&commandSpec{
+			name:    "ECHO",
+			group:   "connection",
+			summary: "Returns the given string.",
+			arity:   2,
+			flags:   flagFast | flagStale | flagLoading,
+			handler: (*server).handleEcho,
+		},
+		&commandSpec{
+			name:    "CONFIG",
+			group:   "server",
+			summary: "A container for server configuration commands.",
+			arity:   -2,
+			subcommands: subcommands(
+				&commandSpec{
+					name:    "GET",
+					summary: "Returns the effective values of configuration parameters.",
+					arity:   -3,
+					flags:   flagAdmin | flagNoScript | flagLoading | flagStale,
+					handler: (*server).handleConfigGet,
+				},
+			),
+		},
+		&commandSpec{
+			name:    "KEYS",
+			group:   "generic",
+			summary: "Returns all key names that match a pattern.",
+			arity:   2,
+			flags:   flagReadonly,
+			handler: (*server).handleKeys,
+		},
+		&commandSpec{
+			name:    "INFO",
+			group:   "server",
+			summary: "Returns information and statistics about the server.",
+			arity:   -1,
+			flags:   flagLoading | flagStale,
+			handler: (*server).handleInfo,
+		},
+		&commandSpec{
+			name:    "REPLCONF",
+			group:   "server",
+			summary: "An internal command for configuring the replication stream.",
+			arity:   -1,
+			flags:   flagAdmin | flagNoScript | flagLoading | flagStale,
+			handler: (*server).handleReplConf,
+		},
+		&commandSpec{
+			name:    "PSYNC",
+			group:   "server",
+			summary: "An internal command used in replication.",
+			arity:   -3,
+			flags:   flagAdmin | flagNoScript,
+			handler: (*server).handlePsync,
+		},
+	)
+}
+
+func (s *server) parseResponses(req *Request) ([]resp.Value, error) {
 	if req == nil {
 		return nil, fmt.Errorf("Request is nil")
 	}
-	switch req.Command {
-	case PING:
-		return []string{"+PONG\r\n"}, nil
-	case ECHO:
-		return []string{fmt.Sprintf("+%s\r\n", req.Args[0])}, nil
-	case SET:
-		err := s.setValue(req.Args)
-		if err != nil {
+	spec, err := lookupCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	write := spec.has(flagWrite)
+	if write {
+		if err := s.writesRefused(); err != nil {
 			return nil, err
 		}
-		return []string{"+OK\r\n"}, nil
-	case GET:
-		value, ok := s.getValue(req.Args[0])
-		if ok {
-			return []string{fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)}, nil
-		}
-		return []string{"$-1\r\n"}, nil
-	case CONFIG:
-		res, err := s.handleConfig(req.Args)
-		if err != nil {
-			return nil, fmt.Errorf("error handling COMMAND %v", err)
-		}
-		return []string{res}, nil
-	case KEYS:
-		res, err := s.handleKeys(req.Args)
-		if err != nil {
-			return nil, fmt.Errorf("error handling KEYS %v", err)
-		}
-		return []string{res}, nil
-	case INFO:
-		res, err := s.handleInfo(req.Args)
-		if err != nil {
-			return nil, fmt.Errorf("error handling INFO %v", err)
-		}
-		return []string{res}, nil
-	case REPLCONF:
-		res, err := s.handleReplConf()
-		if err != nil {
-			return nil, fmt.Errorf("error handling REPLCONF %v", err)
-		}
-		return []string{res}, nil
-	case PSYNC:
-		res, err := s.handlePsync(req.Args)
-		if err != nil {
-			return nil, fmt.Errorf("error handling PSYNC %v", err)
+	}
+	keys := spec.keys(req.Args)
+	if len(keys) == 0 {
+		// commands without keys lock the shards they walk themselves
+		replies, err := spec.handler(s, req)
+		if write && err == nil {
+			s.feedAOF(req, nil, replies)
 		}
-		return res, nil
-	default:
-		return nil, fmt.Errorf("unknown request command %s", req.Command)
+		return replies, err
 	}
+	replies, err := s.executeLocked(spec, req, keys)
+	if write {
+		s.serveReadyKeys()
+	}
+	if req.block != nil {
+		return s.waitBlocked(req)
+	}
+	return replies, err
 }
 
-func (s *server) setValue(args []string) error {
-	// if expire time in args
-	for i, arg := range args {
-		args[i] = strings.ToLower(arg)
-	}
-	if slices.Contains(args, "px") {
-		expiry, err := strconv.Atoi(args[3])
-		if err != nil {
-			return fmt.Errorf("cannot convert expiry milliseconds to integer")
+// executeLocked runs a handler holding the locks of the keys it declares, expired
+// keys among them are reclaimed first so handlers never see them.
+func (s *server) executeLocked(spec *commandSpec, req *Request, keys []string) ([]resp.Value, error) {
+	now := time.Now()
+	if spec.has(flagWrite) || spec.has(flagExclusive) {
+		unlock := s.InMemoryStore.lockKeys(keys, true)
+		defer unlock()
+		s.InMemoryStore.reclaimExpired(keys, now)
+		replies, err := spec.handler(s, req)
+		if !spec.has(flagWrite) {
+			return replies, err
 		}
-		duration := time.Duration(expiry) * time.Millisecond
-		expiredTs := time.Now().Add(duration)
-		s.InMemoryStore[args[0]] = &Resource{
-			value:   args[1],
-			expired: &expiredTs,
+		// blocked commands are fed once served
+		if err == nil && req.block == nil {
+			s.feedAOF(req, keys, replies)
 		}
-		return nil
-	}
-	s.InMemoryStore[args[0]] = &Resource{
-		value: args[1],
+		s.signalDeletedKeys(keys)
+		return replies, err
 	}
-	return nil
 
+	unlock := s.InMemoryStore.lockKeys(keys, false)
+	replies, err := spec.handler(s, req)
+	stale := s.InMemoryStore.expiredAmong(keys, now)
+	unlock()
+	// read-only commands only hold read locks, expired keys they met are
+	// deleted once the exclusive locks are taken
+	if len(stale) > 0 {
+		unlock := s.InMemoryStore.lockKeys(stale, true)
+		s.InMemoryStore.reclaimExpired(stale, time.Now())
+		unlock()
+	}
+	return replies, err
 }
 
-func (s *server) getValue(key string) (string, bool) {
-	tNow := time.Now()
-	res, ok := s.InMemoryStore[key]
-	if ok {
-		valStr := res.value.(string)
-		// does it have expiry?
-		if expired(res, tNow) {
-			return "", false
-		}
-		return valStr, ok
+func (s *server) handlePing(req *Request) ([]resp.Value, error) {
+	if len(req.Args) > 1 {
+		return nil, errWrongArgs("ping")
 	}
-	return "", false
+	if len(req.Args) == 1 {
+		return reply(resp.BulkString(req.Args[0]))
+	}
+	return reply(resp.SimpleString("PONG"))
 }
 
-func (s *server) handleConfig(args []string) (string, error) {
-	// config first arg
-	switch args[0] {
-	case "GET":
-		return s.handleConfigGet(args)
-	default:
-		return "", fmt.Errorf("unrecognized config command")
-	}
+func (s *server) handleEcho(req *Request) ([]resp.Value, error) {
+	return reply(resp.BulkString(req.Args[0]))
 }
 
-func (s *server) handleConfigGet(args []string) (string, error) {
-	switch args[1] {
+func (s *server) handleConfigGet(req *Request) ([]resp.Value, error) {
+	switch strings.ToLower(req.Args[1]) {
 	case "dir":
-		// build RESP bulk string
-		bulkStr := fmt.Sprintf("*2\r\n$%d\r\ndir\r\n$%d\r\n%s\r\n", 3, len(s.Config.Dir), s.Config.Dir)
-		return bulkStr, nil
+		return reply(resp.BulkStrings([]string{"dir", s.Config.Dir}))
 	case "dbfilename":
-		bulkStr := fmt.Sprintf("*2\r\n$%d\r\ndbfilename\r\n$%d\r\n%s\r\n", 3, len(s.Config.DbFilename), s.Config.DbFilename)
-		return bulkStr, nil
+		return reply(resp.BulkStrings([]string{"dbfilename", s.Config.DbFilename}))
+	case "hz":
+		return reply(resp.BulkStrings([]string{"hz", strconv.Itoa(s.Config.Hz)}))
+	case "hash-max-listpack-entries":
+		return reply(resp.BulkStrings([]string{"hash-max-listpack-entries", strconv.Itoa(s.Config.HashMaxListpackEntries)}))
+	case "hash-max-listpack-value":
+		return reply(resp.BulkStrings([]string{"hash-max-listpack-value", strconv.Itoa(s.Config.HashMaxListpackValue)}))
+	case "set-max-intset-entries":
+		return reply(resp.BulkStrings([]string{"set-max-intset-entries", strconv.Itoa(s.Config.SetMaxIntsetEntries)}))
+	case "stream-node-max-entries":
+		return reply(resp.BulkStrings([]string{"stream-node-max-entries", strconv.Itoa(s.Config.StreamNodeMaxEntries)}))
+	case "hll-sparse-max-bytes":
+		return reply(resp.BulkStrings([]string{"hll-sparse-max-bytes", strconv.Itoa(s.Config.HllSparseMaxBytes)}))
+	case "save":
+		return reply(resp.BulkStrings([]string{"save", formatSaveRules(s.Config.SaveRules)}))
+	case "stop-writes-on-bgsave-error":
+		return reply(resp.BulkStrings([]string{"stop-writes-on-bgsave-error", yesNo(s.Config.StopWritesOnBgsaveError)}))
+	case "appendonly":
+		return reply(resp.BulkStrings([]string{"appendonly", yesNo(s.Config.AppendOnly)}))
+	case "appendfilename":
+		return reply(resp.BulkStrings([]string{"appendfilename", s.Config.AppendFilename}))
+	case "appenddirname":
+		return reply(resp.BulkStrings([]string{"appenddirname", s.Config.AppendDirname}))
+	case "appendfsync":
+		return reply(resp.BulkStrings([]string{"appendfsync", s.Config.AppendFsync.String()}))
+	case "aof-load-truncated":
+		return reply(resp.BulkStrings([]string{"aof-load-truncated", yesNo(s.Config.AofLoadTruncated)}))
+	case "aof-use-rdb-preamble":
+		return reply(resp.BulkStrings([]string{"aof-use-rdb-preamble", yesNo(s.Config.AofUseRdbPreamble)}))
+	case "auto-aof-rewrite-percentage":
+		return reply(resp.BulkStrings([]string{"auto-aof-rewrite-percentage", strconv.Itoa(s.Config.AutoAofRewritePercentage)}))
+	case "auto-aof-rewrite-min-size":
+		return reply(resp.BulkStrings([]string{"auto-aof-rewrite-min-size", strconv.FormatInt(s.Config.AutoAofRewriteMinSize, 10)}))
 	default:
-		return "", fmt.Errorf("unrecognized config command, expecting dir or dbfilename")
+		// unknown parameters simply don't match, like redis does
+		return reply(resp.Array())
 	}
 }
 
-func (s *server) handleKeys(args []string) (string, error) {
-	switch args[0] {
-	case "*":
-		// return all the keys
-		// return all the keys
-		return formatMapKeys(s.InMemoryStore), nil
-
-	default:
-		return "", fmt.Errorf("argument for KEYS is not supported")
-	}
-}
-
-func (s *server) handleInfo(args []string) (string, error) {
-	switch args[0] {
-	case "replication":
-		keyVal1 := "role:master"
-		if s.Config.ReplicaOf != nil {
-			keyVal1 = "role:slave"
+func (s *server) handleKeys(req *Request) ([]resp.Value, error) {
+	pattern := req.Args[0]
+	keys := []string{}
+	s.InMemoryStore.forEach(func(key string, res *Resource) bool {
+		if pattern == "*" || stringMatch(pattern, key, false) {
+			keys = append(keys, key)
 		}
-		keyVal2 := "master_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-		keyVal3 := "master_repl_offset:0"
-		bulkString := formatBulkString([]string{keyVal1, keyVal2, keyVal3})
-		fmt.Printf("got bulk string %s", bulkString)
-		return bulkString, nil
-	default:
-		return "", fmt.Errorf("Unrecognized argument %s for INFO command", args[0])
-	}
+		return true
+	})
+	return reply(resp.BulkStrings(keys))
 }
 
-func (s *server) handleReplConf() (string, error) {
+func (s *server) handleReplConf(req *Request) ([]resp.Value, error) {
 	// always repond with a simple RESP simple string OK
-	return "+OK\r\n", nil
+	return reply(resp.OK)
 }
 
-func (s *server) handlePsync(args []string) ([]string, error) {
-	simpleRespStr := simpleRespString([]string{
+func (s *server) handlePsync(req *Request) ([]resp.Value, error) {
+	fullResync := resp.SimpleString(strings.Join([]string{
 		"FULLRESYNC", "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb", "0",
-	})
+	}, " "))
 	// send empty rdb file
 	// Decode the Base64 string
 	decodedBytes, err := base64.StdEncoding.DecodeString(emptyRdbBase64)
 	if err != nil {
 		log.Fatalf("Error decoding Base64 string: %v", err)
 	}
-	// serialize into a resp rdb contentx
-	rdbContentStr := rdbContentResp(decodedBytes)
-
-	return []string{
-		simpleRespStr, rdbContentStr,
+	return []resp.Value{
+		fullResync, resp.RDBFile(decodedBytes),
 	}, nil
 }
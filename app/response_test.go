@@ -0,0 +1,187 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	return startTestServer(t, testConfig(t))
+}
+
+// testConfig is the default configuration with a temporary directory.
+func testConfig(t *testing.T) *Config {
+	return &Config{
+		Dir:                    t.TempDir(),
+		DbFilename:             "dump.rdb",
+		Hz:                     10,
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		SetMaxIntsetEntries:    512,
+		StreamNodeMaxEntries:   100,
+		HllSparseMaxBytes:      3000,
+		AppendFilename:         "appendonly.aof",
+		AppendDirname:          "appendonlydir",
+	}
+}
+
+// startTestServer creates a server loading its data from config.Dir.
+func startTestServer(t *testing.T, config *Config) *server {
+	t.Helper()
+	s, err := NewServer(nil, NewInMemoryStore(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// do runs a command through the dispatcher like a client would.
+func do(s *server, args ...string) (resp.Value, error) {
+	replies, err := s.parseResponses(&Request{Command: Command(args[0]), Args: args[1:]})
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if len(replies) != 1 {
+		return resp.Value{}, fmt.Errorf("%s: got %d replies", args[0], len(replies))
+	}
+	return replies[0], nil
+}
+
+// mustDo is do for commands that can't fail, it is safe to call from the
+// goroutines of a test.
+func mustDo(t *testing.T, s *server, args ...string) resp.Value {
+	t.Helper()
+	v, err := do(s, args...)
+	if err != nil {
+		t.Errorf("%v: %v", args, err)
+	}
+	return v
+}
+
+// TestParallelClients runs commands on shared keys from many goroutines, as
+// clients on different connections do. Run with -race, it checks that every
+// command locks the shards of all the keys it touches.
+func TestParallelClients(t *testing.T) {
+	const (
+		clients    = 8
+		iterations = 300
+	)
+	s := newTestServer(t)
+	// keys of multi-key commands spread over several shards
+	msetKeys := []string{"m:a", "m:b", "m:c", "m:d", "m:e", "m:f"}
+	setKeys := []string{"set:0", "set:1", "set:2", "set:3"}
+	mustDo(t, s, "SET", "token:a", "token")
+	mustDo(t, s, "XGROUP", "CREATE", "xs", "streams", "$", "MKSTREAM")
+
+	var wg sync.WaitGroup
+	var readMu sync.Mutex
+	read := 0
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			own := "own:" + strconv.Itoa(c)
+			for i := 0; i < iterations; i++ {
+				value := strconv.Itoa(c*iterations + i)
+				mustDo(t, s, "INCR", "counter")
+
+				mustDo(t, s, "SET", own, value)
+				if got := mustDo(t, s, "GET", own); got.Str != value {
+					t.Errorf("GET %s = %q, want %q", own, got.Str, value)
+				}
+
+				// MSET is atomic, MGET never sees half of it
+				mset := []string{"MSET"}
+				for _, key := range msetKeys {
+					mset = append(mset, key, value)
+				}
+				mustDo(t, s, mset...)
+				got := mustDo(t, s, append([]string{"MGET"}, msetKeys...)...)
+				for _, v := range got.Array[1:] {
+					if v.Str != got.Array[0].Str {
+						t.Errorf("MGET saw a partial MSET: %v", got.Array)
+						break
+					}
+				}
+
+				mustDo(t, s, "SADD", setKeys[i%len(setKeys)], value)
+				mustDo(t, s, append([]string{"SUNIONSTORE", "set:union"}, setKeys...)...)
+
+				// the token moves between two shards, it is never lost nor copied
+				if i%2 == 0 {
+					do(s, "RENAME", "token:a", "token:b")
+				} else {
+					do(s, "RENAME", "token:b", "token:a")
+				}
+
+				// the group is named like the STREAMS keyword
+				mustDo(t, s, "XADD", "xs", "*", "client", value)
+				entries := mustDo(t, s, "XREADGROUP", "GROUP", "streams", "c"+strconv.Itoa(c), "COUNT", "10", "STREAMS", "xs", ">")
+				if !entries.Null {
+					readMu.Lock()
+					read += len(entries.Array[0].Array[1].Array)
+					readMu.Unlock()
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	total := int64(clients * iterations)
+	if got := mustDo(t, s, "GET", "counter"); got.Str != strconv.FormatInt(total, 10) {
+		t.Errorf("counter = %s, want %d", got.Str, total)
+	}
+	if got := mustDo(t, s, "EXISTS", "token:a", "token:b"); got.Int != 1 {
+		t.Errorf("%d tokens exist, want 1", got.Int)
+	}
+	if got := mustDo(t, s, "SCARD", "set:union"); got.Int < total-int64(clients) {
+		t.Errorf("SCARD set:union = %d, want at least %d", got.Int, total-int64(clients))
+	}
+	if got := mustDo(t, s, "SUNIONSTORE", "set:union", setKeys[0], setKeys[1], setKeys[2], setKeys[3]); got.Int != total {
+		t.Errorf("SUNIONSTORE = %d, want %d", got.Int, total)
+	}
+	if got := mustDo(t, s, "XLEN", "xs"); got.Int != total {
+		t.Errorf("XLEN = %d, want %d", got.Int, total)
+	}
+	// every entry is delivered once, the ones left are read now
+	if entries := mustDo(t, s, "XREADGROUP", "GROUP", "streams", "last", "STREAMS", "xs", ">"); !entries.Null {
+		read += len(entries.Array[0].Array[1].Array)
+	}
+	if int64(read) != total {
+		t.Errorf("read %d entries, want %d", read, total)
+	}
+}
+
+// TestBlockedClientDisconnects pipelines more requests than are queued behind a
+// blocked BLPOP, then disconnects: the connection must be dropped anyway.
+func TestBlockedClientDisconnects(t *testing.T) {
+	s := newTestServer(t)
+	server, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handleConnection(server)
+		close(done)
+	}()
+
+	pipeline := strings.Repeat("*2\r\n$4\r\nECHO\r\n$1\r\na\r\n", 4*requestQueueSize)
+	if _, err := conn.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$4\r\nlist\r\n$1\r\n0\r\n" + pipeline)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked client was not dropped")
+	}
+	if n := s.connectedClients.Load(); n != 0 {
+		t.Errorf("%d clients connected, want 0", n)
+	}
+}
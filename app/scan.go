@@ -0,0 +1,192 @@
+package app
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+const defaultScanCount = 10
+
+var errInvalidCursor = errors.New("ERR invalid cursor")
+
+func init() {
+	registerCommands(&commandSpec{
+		name:    "SCAN",
+		group:   "generic",
+		summary: "Iterates over the key names in the database.",
+		arity:   -2,
+		flags:   flagReadonly,
+		handler: (*server).handleScan,
+	})
+}
+
+// scanHash orders the elements of a collection for cursor based iteration.
+func scanHash(member string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(member))
+	return h.Sum32()
+}
+
+// scanIndex orders the members of a collection by scan hash, so that a page
+// of a cursor scan is found in O(log n) and walked in O(count) instead of
+// sorting the whole collection on every call. It is a skiplist like the one of
+// sorted sets, with the scan hash as score.
+type scanIndex struct {
+	zsl *zskiplist
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{zsl: newZSkiplist()}
+}
+
+// add indexes member, which must not be indexed already.
+func (x *scanIndex) add(member string) {
+	x.zsl.insert(float64(scanHash(member)), member)
+}
+
+func (x *scanIndex) remove(member string) {
+	x.zsl.delete(float64(scanHash(member)), member)
+}
+
+// page returns the members with a scan hash of at least cursor, in hash order,
+// stopping after count of them. Members sharing a hash are never split across
+// pages. Since the order only depends on the members themselves, every member
+// present for the whole iteration is returned even when the collection changes
+// between calls. done reports whether the page reached the end of the
+// collection.
+func (x *scanIndex) page(cursor uint32, count int) (page []string, next uint32, done bool) {
+	from := float64(cursor)
+	node := x.zsl.firstInRange(
+		func(n *zskiplistNode) bool { return n.score < from },
+		func(*zskiplistNode) bool { return true },
+	)
+	for ; node != nil; node = node.level[0].forward {
+		if len(page) >= count && node.score != node.backward.score {
+			return page, uint32(node.score), false
+		}
+		page = append(page, node.member)
+	}
+	return page, 0, true
+}
+
+// scanOptions are the MATCH, COUNT and TYPE arguments shared by the SCAN family.
+type scanOptions struct {
+	pattern  string
+	count    int
+	typeName string
+	// noValues makes HSCAN return the fields only
+	noValues bool
+}
+
+func parseScanOptions(args []string, allowType, allowNoValues bool) (scanOptions, error) {
+	opts := scanOptions{count: defaultScanCount}
+	for i := 0; i < len(args); i += 2 {
+		if allowNoValues && strings.EqualFold(args[i], "NOVALUES") {
+			opts.noValues = true
+			// a flag without value
+			i--
+			continue
+		}
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.pattern = args[i+1]
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if count < 1 {
+				return opts, errSyntax
+			}
+			opts.count = count
+		case "TYPE":
+			if !allowType {
+				return opts, errSyntax
+			}
+			opts.typeName = strings.ToLower(args[i+1])
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+func (o scanOptions) matches(member string) bool {
+	return o.pattern == "" || o.pattern == "*" || stringMatch(o.pattern, member, false)
+}
+
+func parseCursor(arg string) (uint64, error) {
+	cursor, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// handleScan walks the keyspace one shard at a time. The cursor holds the shard
+// index in its upper 32 bits and the scan hash to resume from in the lower ones.
+func (s *server) handleScan(req *Request) ([]resp.Value, error) {
+	cursor, err := parseCursor(req.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseScanOptions(req.Args[1:], true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	examined := 0
+	shardIdx, position := int(cursor>>32), uint32(cursor&math.MaxUint32)
+	for shardIdx < shardCount && examined < opts.count {
+		page, n, next, done := s.InMemoryStore.scanShard(shardIdx, position, opts.count-examined, opts.typeName)
+		examined += n
+		for _, key := range page {
+			if opts.matches(key) {
+				keys = append(keys, key)
+			}
+		}
+		if done {
+			shardIdx, position = shardIdx+1, 0
+		} else {
+			position = next
+		}
+	}
+
+	nextCursor := uint64(0)
+	if shardIdx < shardCount {
+		nextCursor = uint64(shardIdx)<<32 | uint64(position)
+	}
+	return reply(resp.Array(
+		resp.BulkString(strconv.FormatUint(nextCursor, 10)),
+		resp.BulkStrings(keys),
+	))
+}
+
+// scanShard returns a page of the live keys of one shard, optionally restricted
+// to values of the given type, along with the number of keys examined.
+func (m *InMemoryStore) scanShard(idx int, position uint32, count int, typeFilter string) ([]string, int, uint32, bool) {
+	sh := m.shards[idx]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	page, next, done := sh.index.page(position, count)
+	now := time.Now()
+	live := page[:0]
+	for _, key := range page {
+		res := sh.items[key]
+		if m.expired(res, now) || (typeFilter != "" && typeName(res) != typeFilter) {
+			continue
+		}
+		live = append(live, key)
+	}
+	return live, len(page), next, done
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	"net"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync/atomic"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
 )
 
 // Ensures gofmt doesn't remove the "net" and "os" imports in stage 1 (feel free to remove this!)
@@ -16,41 +18,43 @@ var _ = os.Exit
 
 type server struct {
 	Listener      net.Listener
-	InMemoryStore InMemoryStore
+	InMemoryStore *InMemoryStore
 	Config        *Config
+
+	blocking         *blockingState
+	rdb              *rdbState
+	aof              *aofState
+	connectedClients atomic.Int64
 }
 
-func NewServer(listener net.Listener, store InMemoryStore, config *Config) (*server, error) {
-	// if dbfilename is valid, check if it should be parsed into inmemory store
-	if config.DbFilename != "" && config.Dir != "" {
-		// check if path exists
-		fullPath := filepath.Join(config.Dir, config.DbFilename)
-		if fileExists(fullPath) {
-			inMemoryStore, err := ReadRedisDBFile(fullPath)
-			if err != nil {
-				return nil, fmt.Errorf("cannot parse dump file %v", err)
-			}
-			store = inMemoryStore
-		}
-	}
-	return &server{
+func NewServer(listener net.Listener, store *InMemoryStore, config *Config) (*server, error) {
+	s := &server{
 		Listener:      listener,
 		InMemoryStore: store,
 		Config:        config,
-	}, nil
+		blocking:      newBlockingState(),
+		rdb:           newRDBState(),
+		aof:           newAOFState(),
+	}
+	store.onReclaim = s.feedAOFReclaimed
+	// fill the inmemory store from the append only file or the dump file
+	if err := s.loadData(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 func RunServer(config *Config) error {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	fmt.Println("Logs from your program will appear here!")
-	store := InMemoryStore{}
+	store := NewInMemoryStore()
 	// read config
 
 	// Uncomment this block to pass the first stage
 
 	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%s", config.Port))
 	if err != nil {
-		return fmt.Errorf("Failed to bind to port %d %v", config.Port, err)
+		return fmt.Errorf("Failed to bind to port %s %v", config.Port, err)
 	}
 	server, err := NewServer(l, store, config)
 	if config.ReplicaOf != nil {
@@ -65,6 +69,14 @@ func RunServer(config *Config) error {
 	}
 	defer l.Close()
 
+	go server.activeExpireLoop()
+	if len(config.SaveRules) > 0 {
+		go server.saveLoop()
+	}
+	if config.AppendOnly {
+		go server.aofLoop()
+	}
+
 	for {
 		conn, err := server.Listener.Accept()
 		if err != nil {
@@ -80,29 +92,56 @@ func (s *server) handleConnection(conn net.Conn) {
 
 	fmt.Println("Connected to client:", conn.RemoteAddr())
 
-	for {
+	c := newClient(conn)
+	s.connectedClients.Add(1)
+	defer s.connectedClients.Add(-1)
+	go c.readRequests()
+	defer func() {
+		// unblock the reader if we stopped first
+		conn.Close()
+		for range c.requests {
+		}
+	}()
+
+	writer := resp.NewWriter(conn)
+	for parsed := range c.requests {
 		// parse request
-		request, err := RequestParser(conn)
+		request, err := parsed.req, parsed.err
 		if err != nil {
-			if err == io.EOF {
+			if errors.Is(err, io.EOF) {
 				break
 			}
-			fmt.Printf("Cannot parse the request %v", err)
+			fmt.Printf("Cannot parse the request %v\n", err)
+			var protoErr *ProtocolError
+			if errors.As(err, &protoErr) {
+				// tell the client why before dropping it, the stream cannot be resynchronised
+				writer.WriteValue(resp.Error("ERR " + protoErr.Error()))
+				writer.Flush()
+			}
+			return
 		}
 		fmt.Println("parsed request ", request)
 		responses, err := s.parseResponses(request)
 		if err != nil {
-			fmt.Println("Error parsing response:", err)
-			return
+			// command errors are replied to the client and the connection stays open
+			responses = []resp.Value{resp.Error(err.Error())}
 		}
 		for _, response := range responses {
-			// Send the response back to the client
-			_, err = conn.Write([]byte(response))
+			err = writer.WriteValue(response)
 			if err != nil {
 				fmt.Println("Error sending response:", err)
 				return
 			}
 		}
+		// pipelined commands already received are answered in a single write
+		if len(c.requests) > 0 {
+			continue
+		}
+		// Send the responses back to the client
+		if err := writer.Flush(); err != nil {
+			fmt.Println("Error sending response:", err)
+			return
+		}
 	}
 
 }
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// set is the set value. Sets made only of integers are kept as a sorted slice,
+// like the intset encoding of redis, and converted to a map once a member that
+// is not an integer is added or they grow past set-max-intset-entries. A
+// converted set never goes back to the compact encoding.
+type set struct {
+	ints []int64
+	dict map[string]struct{}
+}
+
+func newSet() *set {
+	return &set{}
+}
+
+func (st *set) Len() int {
+	if st.dict != nil {
+		return len(st.dict)
+	}
+	return len(st.ints)
+}
+
+func (st *set) intset() bool {
+	return st.dict == nil
+}
+
+func (st *set) Contains(member string) bool {
+	if st.dict != nil {
+		_, ok := st.dict[member]
+		return ok
+	}
+	n, ok := parseCanonicalInt(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(st.ints, n)
+	return found
+}
+
+// Add adds member and reports whether it was missing.
+func (st *set) Add(member string) bool {
+	if st.dict == nil {
+		n, ok := parseCanonicalInt(member)
+		if ok {
+			i, found := slices.BinarySearch(st.ints, n)
+			if found {
+				return false
+			}
+			st.ints = slices.Insert(st.ints, i, n)
+			return true
+		}
+		st.convert()
+	}
+	if _, ok := st.dict[member]; ok {
+		return false
+	}
+	st.dict[member] = struct{}{}
+	return true
+}
+
+func (st *set) Remove(member string) bool {
+	if st.dict != nil {
+		_, ok := st.dict[member]
+		delete(st.dict, member)
+		return ok
+	}
+	n, ok := parseCanonicalInt(member)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(st.ints, n)
+	if found {
+		st.ints = slices.Delete(st.ints, i, i+1)
+	}
+	return found
+}
+
+// Members returns the members, integer sets in ascending order.
+func (st *set) Members() []string {
+	members := make([]string, 0, st.Len())
+	if st.dict != nil {
+		for member := range st.dict {
+			members = append(members, member)
+		}
+		return members
+	}
+	for _, n := range st.ints {
+		members = append(members, strconv.FormatInt(n, 10))
+	}
+	return members
+}
+
+// clone returns a copy of the set sharing no storage with it.
+func (st *set) clone() *set {
+	c := &set{ints: slices.Clone(st.ints)}
+	if st.dict != nil {
+		c.dict = maps.Clone(st.dict)
+	}
+	return c
+}
+
+func (st *set) convert() {
+	st.dict = make(map[string]struct{}, len(st.ints))
+	for _, n := range st.ints {
+		st.dict[strconv.FormatInt(n, 10)] = struct{}{}
+	}
+	st.ints = nil
+}
+
+// setAdd adds a member to st, converting it to a map when it grows past
+// set-max-intset-entries.
+func (s *server) setAdd(st *set, member string) bool {
+	added := st.Add(member)
+	if st.intset() && st.Len() > s.Config.SetMaxIntsetEntries {
+		st.convert()
+	}
+	return added
+}
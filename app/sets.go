@@ -0,0 +1,493 @@
+package app
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "SADD",
+			group:    "set",
+			summary:  "Adds one or more members to a set. Creates the key if it doesn't exist.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSAdd,
+		},
+		&commandSpec{
+			name:     "SREM",
+			group:    "set",
+			summary:  "Removes one or more members from a set. Deletes the set if the last member was removed.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSRem,
+		},
+		&commandSpec{
+			name:     "SISMEMBER",
+			group:    "set",
+			summary:  "Determines whether a member belongs to a set.",
+			arity:    3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSIsMember,
+		},
+		&commandSpec{
+			name:     "SMISMEMBER",
+			group:    "set",
+			summary:  "Determines whether multiple members belong to a set.",
+			arity:    -3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSIsMember,
+		},
+		&commandSpec{
+			name:     "SMEMBERS",
+			group:    "set",
+			summary:  "Returns all members of a set.",
+			arity:    2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSMembers,
+		},
+		&commandSpec{
+			name:     "SCARD",
+			group:    "set",
+			summary:  "Returns the number of members in a set.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSCard,
+		},
+		&commandSpec{
+			name:     "SPOP",
+			group:    "set",
+			summary:  "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSPop,
+		},
+		&commandSpec{
+			name:     "SRANDMEMBER",
+			group:    "set",
+			summary:  "Get one or multiple random members from a set",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSRandMember,
+		},
+		&commandSpec{
+			name:     "SMOVE",
+			group:    "set",
+			summary:  "Moves a member from one set to another.",
+			arity:    4,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleSMove,
+		},
+		&commandSpec{
+			name:     "SINTER",
+			group:    "set",
+			summary:  "Returns the intersect of multiple sets.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleSetOperation,
+		},
+		&commandSpec{
+			name:     "SUNION",
+			group:    "set",
+			summary:  "Returns the union of multiple sets.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleSetOperation,
+		},
+		&commandSpec{
+			name:     "SDIFF",
+			group:    "set",
+			summary:  "Returns the difference of multiple sets.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleSetOperation,
+		},
+		&commandSpec{
+			name:     "SINTERSTORE",
+			group:    "set",
+			summary:  "Stores the intersect of multiple sets in a key.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleSetOperation,
+		},
+		&commandSpec{
+			name:     "SUNIONSTORE",
+			group:    "set",
+			summary:  "Stores the union of multiple sets in a key.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleSetOperation,
+		},
+		&commandSpec{
+			name:     "SDIFFSTORE",
+			group:    "set",
+			summary:  "Stores the difference of multiple sets in a key.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleSetOperation,
+		},
+		&commandSpec{
+			name:     "SINTERCARD",
+			group:    "set",
+			summary:  "Returns the number of members of the intersect of multiple sets.",
+			arity:    -3,
+			flags:    flagReadonly,
+			keysFunc: numkeysKeys(0),
+			handler:  (*server).handleSInterCard,
+		},
+	)
+}
+
+// lookupSet returns the live set stored at key, it fails with WRONGTYPE when
+// the key holds another type.
+func (s *server) lookupSet(key string, now time.Time) (*set, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+	st, isSet := res.value.(*set)
+	if !isSet {
+		return nil, false, errWrongType
+	}
+	return st, true, nil
+}
+
+// lookupSets returns the sets stored at keys, missing keys are nil entries.
+func (s *server) lookupSets(keys []string, now time.Time) ([]*set, error) {
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		st, _, err := s.lookupSet(key, now)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = st
+	}
+	return sets, nil
+}
+
+func (s *server) handleSAdd(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	st, exists, err := s.lookupSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		st = newSet()
+		s.InMemoryStore.set(key, &Resource{value: st})
+	}
+	added := 0
+	for _, member := range req.Args[1:] {
+		if s.setAdd(st, member) {
+			added++
+		}
+	}
+	s.addDirty(added)
+	return reply(resp.Integer(int64(added)))
+}
+
+func (s *server) handleSRem(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	st, exists, err := s.lookupSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	removed := 0
+	for _, member := range req.Args[1:] {
+		if st.Remove(member) {
+			removed++
+		}
+	}
+	if st.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	}
+	s.addDirty(removed)
+	return reply(resp.Integer(int64(removed)))
+}
+
+// handleSIsMember serves SISMEMBER and SMISMEMBER.
+func (s *server) handleSIsMember(req *Request) ([]resp.Value, error) {
+	st, exists, err := s.lookupSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	results := make([]resp.Value, 0, len(req.Args)-1)
+	for _, member := range req.Args[1:] {
+		results = append(results, resp.Bool(exists && st.Contains(member)))
+	}
+	if strings.EqualFold(string(req.Command), "SISMEMBER") {
+		return reply(results[0])
+	}
+	return reply(resp.Array(results...))
+}
+
+func (s *server) handleSMembers(req *Request) ([]resp.Value, error) {
+	st, exists, err := s.lookupSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Array())
+	}
+	return reply(resp.BulkStrings(st.Members()))
+}
+
+func (s *server) handleSCard(req *Request) ([]resp.Value, error) {
+	st, exists, err := s.lookupSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(int64(st.Len())))
+}
+
+func (s *server) handleSPop(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	if len(req.Args) > 2 {
+		return nil, errSyntax
+	}
+	count, withCount := int64(1), len(req.Args) == 2
+	if withCount {
+		n, err := strconv.ParseInt(req.Args[1], 10, 64)
+		if err != nil || n < 0 {
+			return nil, errNotPositive
+		}
+		count = n
+	}
+	st, exists, err := s.lookupSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if withCount {
+			return reply(resp.Array())
+		}
+		return reply(resp.NullBulk())
+	}
+	popped := randomMembers(st.Members(), count)
+	for _, member := range popped {
+		st.Remove(member)
+	}
+	if st.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	}
+	s.addDirty(len(popped))
+	if !withCount {
+		return reply(resp.BulkString(popped[0]))
+	}
+	return reply(resp.BulkStrings(popped))
+}
+
+func (s *server) handleSRandMember(req *Request) ([]resp.Value, error) {
+	if len(req.Args) > 2 {
+		return nil, errSyntax
+	}
+	st, exists, err := s.lookupSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Args) == 1 {
+		if !exists {
+			return reply(resp.NullBulk())
+		}
+		members := st.Members()
+		return reply(resp.BulkString(members[rand.Intn(len(members))]))
+	}
+	count, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if !exists || count == 0 {
+		return reply(resp.Array())
+	}
+	return reply(resp.BulkStrings(randomMembers(st.Members(), count)))
+}
+
+func (s *server) handleSMove(req *Request) ([]resp.Value, error) {
+	src, dst, member := req.Args[0], req.Args[1], req.Args[2]
+	now := time.Now()
+	source, exists, err := s.lookupSet(src, now)
+	if err != nil {
+		return nil, err
+	}
+	destination, dstExists, err := s.lookupSet(dst, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists || !source.Contains(member) {
+		return reply(resp.Integer(0))
+	}
+	if src == dst {
+		return reply(resp.Integer(1))
+	}
+	source.Remove(member)
+	if source.Len() == 0 {
+		s.InMemoryStore.delete(src)
+	}
+	if !dstExists {
+		destination = newSet()
+		s.InMemoryStore.set(dst, &Resource{value: destination})
+	}
+	s.setAdd(destination, member)
+	s.addDirty(1)
+	return reply(resp.Integer(1))
+}
+
+// setOperation computes the intersection, union or difference of sets, nil
+// entries are empty sets.
+func setOperation(op string, sets []*set) []string {
+	switch op {
+	case "SINTER":
+		for _, st := range sets {
+			if st == nil {
+				return nil
+			}
+		}
+		// walk the smallest set and probe the others
+		smallest := sets[0]
+		for _, st := range sets[1:] {
+			if st.Len() < smallest.Len() {
+				smallest = st
+			}
+		}
+		result := []string{}
+		for _, member := range smallest.Members() {
+			inAll := true
+			for _, st := range sets {
+				if st != smallest && !st.Contains(member) {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result = append(result, member)
+			}
+		}
+		return result
+	case "SUNION":
+		seen := map[string]struct{}{}
+		result := []string{}
+		for _, st := range sets {
+			if st == nil {
+				continue
+			}
+			for _, member := range st.Members() {
+				if _, ok := seen[member]; !ok {
+					seen[member] = struct{}{}
+					result = append(result, member)
+				}
+			}
+		}
+		return result
+	default:
+		if sets[0] == nil {
+			return nil
+		}
+		result := []string{}
+		for _, member := range sets[0].Members() {
+			inOther := false
+			for _, st := range sets[1:] {
+				if st != nil && st.Contains(member) {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				result = append(result, member)
+			}
+		}
+		return result
+	}
+}
+
+// handleSetOperation serves SINTER, SUNION, SDIFF and their STORE variants.
+func (s *server) handleSetOperation(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	store := strings.HasSuffix(command, "STORE")
+	keys := req.Args
+	if store {
+		keys = req.Args[1:]
+	}
+	now := time.Now()
+	sets, err := s.lookupSets(keys, now)
+	if err != nil {
+		return nil, err
+	}
+	members := setOperation(strings.TrimSuffix(command, "STORE"), sets)
+	if !store {
+		return reply(resp.BulkStrings(members))
+	}
+
+	dst := req.Args[0]
+	// the destination is overwritten whatever it held, ttl included
+	if s.InMemoryStore.delete(dst) || len(members) > 0 {
+		s.addDirty(1)
+	}
+	if len(members) > 0 {
+		result := newSet()
+		for _, member := range members {
+			s.setAdd(result, member)
+		}
+		s.InMemoryStore.set(dst, &Resource{value: result})
+	}
+	return reply(resp.Integer(int64(len(members))))
+}
+
+func (s *server) handleSInterCard(req *Request) ([]resp.Value, error) {
+	numkeys, err := strconv.Atoi(req.Args[0])
+	if err != nil || numkeys <= 0 {
+		return nil, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numkeys > len(req.Args)-1 {
+		return nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys, rest := req.Args[1:1+numkeys], req.Args[1+numkeys:]
+	limit := 0
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.EqualFold(rest[0], "LIMIT"):
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil {
+			return nil, errNotInteger
+		}
+		if limit < 0 {
+			return nil, errors.New("ERR LIMIT can't be negative")
+		}
+	default:
+		return nil, errSyntax
+	}
+	sets, err := s.lookupSets(keys, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	cardinality := len(setOperation("SINTER", sets))
+	if limit > 0 && cardinality > limit {
+		cardinality = limit
+	}
+	return reply(resp.Integer(int64(cardinality)))
+}
@@ -0,0 +1,506 @@
+package app
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// streamID identifies a stream entry: the milliseconds time it was added at and
+// a sequence number for the entries added within the same millisecond.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) compare(other streamID) int {
+	if c := cmp.Compare(id.ms, other.ms); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.seq, other.seq)
+}
+
+func (id streamID) isZero() bool {
+	return id.ms == 0 && id.seq == 0
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// next returns the smallest ID after id, ok is false when id is the last
+// possible ID.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// prev returns the largest ID before id, ok is false for 0-0.
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// parseStreamID parses an ID given as ms-seq or as ms alone, in which case the
+// sequence number is missingSeq. "-" and "+" are the smallest and largest IDs.
+func parseStreamID(arg string, missingSeq uint64) (streamID, error) {
+	switch arg {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+type streamEntry struct {
+	id streamID
+	// fields holds the field value pairs of the entry
+	fields  []string
+	deleted bool
+}
+
+// streamNode is a run of consecutive entries. Deleted entries stay in the node
+// as tombstones until the whole node is empty, like in the listpacks of redis.
+type streamNode struct {
+	entries []streamEntry
+	live    int
+}
+
+// stream is the stream value. Entries are appended in increasing ID order to
+// nodes of at most stream-node-max-entries entries, indexed by the ID of their
+// first entry the way redis keys its radix tree of listpacks. Since IDs only
+// grow, the index is a sorted slice searched by bisection.
+type stream struct {
+	nodes  []*streamNode
+	length int
+	lastID streamID
+	// maxDeletedID is the largest ID removed by XDEL, entriesAdded counts every
+	// entry ever added, both serve to compute the lag of consumer groups
+	maxDeletedID streamID
+	entriesAdded int64
+	groups       map[string]*streamGroup
+}
+
+func newStream() *stream {
+	return &stream{groups: map[string]*streamGroup{}}
+}
+
+func (st *stream) Len() int {
+	return st.length
+}
+
+// Add appends an entry, id must be greater than the last ID of the stream.
+func (st *stream) Add(id streamID, fields []string, nodeMaxEntries int) {
+	var node *streamNode
+	if len(st.nodes) > 0 {
+		node = st.nodes[len(st.nodes)-1]
+	}
+	if node == nil || len(node.entries) >= max(nodeMaxEntries, 1) {
+		node = &streamNode{}
+		st.nodes = append(st.nodes, node)
+	}
+	node.entries = append(node.entries, streamEntry{id: id, fields: fields})
+	node.live++
+	st.length++
+	st.lastID = id
+	st.entriesAdded++
+}
+
+// seek returns the position of the first entry, deleted or not, whose ID is not
+// less than id.
+func (st *stream) seek(id streamID) (int, int) {
+	// the last node starting at or before id is the only one that can hold it
+	ni := sort.Search(len(st.nodes), func(i int) bool {
+		return st.nodes[i].entries[0].id.compare(id) > 0
+	}) - 1
+	if ni < 0 {
+		return 0, 0
+	}
+	entries := st.nodes[ni].entries
+	ei := sort.Search(len(entries), func(i int) bool { return entries[i].id.compare(id) >= 0 })
+	if ei == len(entries) {
+		return ni + 1, 0
+	}
+	return ni, ei
+}
+
+// Get returns the live entry with the given ID.
+func (st *stream) Get(id streamID) (streamEntry, bool) {
+	ni, ei := st.seek(id)
+	if ni == len(st.nodes) {
+		return streamEntry{}, false
+	}
+	e := st.nodes[ni].entries[ei]
+	if e.deleted || e.id != id {
+		return streamEntry{}, false
+	}
+	return e, true
+}
+
+// Range returns the live entries with an ID between start and end inclusive,
+// from the end when reverse is set. A count of zero or less returns them all.
+func (st *stream) Range(start, end streamID, count int, reverse bool) []streamEntry {
+	entries := []streamEntry{}
+	if start.compare(end) > 0 {
+		return entries
+	}
+	full := func() bool { return count > 0 && len(entries) >= count }
+	if !reverse {
+		for ni, ei := st.seek(start); ni < len(st.nodes); ni, ei = ni+1, 0 {
+			for _, e := range st.nodes[ni].entries[ei:] {
+				if e.id.compare(end) > 0 || full() {
+					return entries
+				}
+				if !e.deleted {
+					entries = append(entries, e)
+				}
+			}
+		}
+		return entries
+	}
+	ni, ei := len(st.nodes)-1, -1
+	if end != maxStreamID {
+		next, _ := end.next()
+		ni, ei = st.seek(next)
+		// step back to the entry before the first one past end
+		if ei == 0 {
+			ni--
+			ei = -1
+		} else {
+			ei--
+		}
+	}
+	for ; ni >= 0; ni, ei = ni-1, -1 {
+		node := st.nodes[ni]
+		if ei < 0 {
+			ei = len(node.entries) - 1
+		}
+		for ; ei >= 0; ei-- {
+			e := node.entries[ei]
+			if e.id.compare(start) < 0 || full() {
+				return entries
+			}
+			if !e.deleted {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries
+}
+
+// First returns the first live entry.
+func (st *stream) First() (streamEntry, bool) {
+	entries := st.Range(streamID{}, maxStreamID, 1, false)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// Last returns the last live entry.
+func (st *stream) Last() (streamEntry, bool) {
+	entries := st.Range(streamID{}, maxStreamID, 1, true)
+	if len(entries) == 0 {
+		return streamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// firstID is the ID of the first live entry, 0-0 for empty streams.
+func (st *stream) firstID() streamID {
+	e, _ := st.First()
+	return e.id
+}
+
+// Delete removes the entry with the given ID and reports whether it existed.
+func (st *stream) Delete(id streamID) bool {
+	ni, ei := st.seek(id)
+	if ni == len(st.nodes) {
+		return false
+	}
+	node := st.nodes[ni]
+	e := &node.entries[ei]
+	if e.deleted || e.id != id {
+		return false
+	}
+	e.deleted = true
+	e.fields = nil
+	node.live--
+	st.length--
+	if node.live == 0 {
+		st.nodes = slices.Delete(st.nodes, ni, ni+1)
+	}
+	if id.compare(st.maxDeletedID) > 0 {
+		st.maxDeletedID = id
+	}
+	return true
+}
+
+// streamTrim is the trimming strategy of XADD and XTRIM: down to maxLen
+// entries, or dropping the entries below minID. Approximate trimming only
+// removes whole nodes, at most limit entries of them unless limit is zero.
+type streamTrim struct {
+	byMinID bool
+	maxLen  int64
+	minID   streamID
+	approx  bool
+	limit   int64
+}
+
+// Trim removes the oldest entries according to t and returns how many were
+// removed.
+func (st *stream) Trim(t streamTrim) int64 {
+	var removed int64
+	for len(st.nodes) > 0 {
+		node := st.nodes[0]
+		last := node.entries[len(node.entries)-1].id
+		if (!t.byMinID && int64(st.length-node.live) >= t.maxLen) ||
+			(t.byMinID && last.compare(t.minID) < 0) {
+			if t.limit > 0 && removed+int64(node.live) > t.limit {
+				return removed
+			}
+			removed += int64(node.live)
+			st.length -= node.live
+			st.nodes = st.nodes[1:]
+			continue
+		}
+		if t.approx {
+			return removed
+		}
+		// the trimming ends within this node, cut its head
+		cut := 0
+		for cut < len(node.entries) {
+			e := node.entries[cut]
+			if (!t.byMinID && int64(st.length) <= t.maxLen) || (t.byMinID && e.id.compare(t.minID) >= 0) {
+				break
+			}
+			if !e.deleted {
+				node.live--
+				st.length--
+				removed++
+			}
+			cut++
+		}
+		node.entries = node.entries[cut:]
+		return removed
+	}
+	return removed
+}
+
+// hasTombstones reports whether entries after start may have been deleted, in
+// which case the position of an ID can't be derived from the counters.
+func (st *stream) hasTombstones(start streamID) bool {
+	if st.length == 0 || st.maxDeletedID.isZero() {
+		return false
+	}
+	return st.maxDeletedID.compare(start) >= 0
+}
+
+// entriesBefore estimates how many entries were ever added up to id included,
+// ok is false when it can't be told because of deleted entries.
+func (st *stream) entriesBefore(id streamID) (int64, bool) {
+	if st.entriesAdded == 0 {
+		return 0, true
+	}
+	if st.length == 0 && id.compare(st.lastID) <= 0 {
+		return st.entriesAdded, true
+	}
+	switch id.compare(st.lastID) {
+	case 0:
+		return st.entriesAdded, true
+	case 1:
+		return 0, false
+	}
+	first := st.firstID()
+	if st.maxDeletedID.isZero() || st.maxDeletedID.compare(first) < 0 {
+		// no entry was deleted from the middle of the stream
+		switch id.compare(first) {
+		case -1:
+			return st.entriesAdded - int64(st.length), true
+		case 0:
+			return st.entriesAdded - int64(st.length) + 1, true
+		}
+	}
+	return 0, false
+}
+
+// lag returns how many entries the group has yet to read, ok is false when it
+// can't be computed.
+func (st *stream) lag(g *streamGroup) (int64, bool) {
+	if st.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.entriesRead >= 0 && !st.hasTombstones(g.lastID) {
+		return st.entriesAdded - g.entriesRead, true
+	}
+	read, ok := st.entriesBefore(g.lastID)
+	if !ok {
+		return 0, false
+	}
+	return st.entriesAdded - read, true
+}
+
+// streamGroup is a consumer group. Entries delivered to its consumers stay in
+// the pending entries list until acknowledged.
+type streamGroup struct {
+	name   string
+	lastID streamID
+	// entriesRead is the number of entries the group read, -1 when unknown
+	entriesRead int64
+	pending     map[streamID]*pendingEntry
+	consumers   map[string]*streamConsumer
+}
+
+type pendingEntry struct {
+	consumer      *streamConsumer
+	deliveryTime  time.Time
+	deliveryCount int64
+}
+
+type streamConsumer struct {
+	name string
+	// seenTime is the last time the consumer attempted an interaction,
+	// activeTime the last time it read or claimed entries
+	seenTime   time.Time
+	activeTime time.Time
+	pending    map[streamID]*pendingEntry
+}
+
+func newStreamGroup(name string, lastID streamID, entriesRead int64) *streamGroup {
+	return &streamGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pending:     map[streamID]*pendingEntry{},
+		consumers:   map[string]*streamConsumer{},
+	}
+}
+
+// consumer returns the named consumer, creating it if needed.
+func (g *streamGroup) consumer(name string, now time.Time) *streamConsumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &streamConsumer{name: name, seenTime: now, pending: map[streamID]*pendingEntry{}}
+		g.consumers[name] = c
+	}
+	return c
+}
+
+// deleteConsumer removes a consumer along with its pending entries and returns
+// how many there were.
+func (g *streamGroup) deleteConsumer(name string) (int, bool) {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+	for id := range c.pending {
+		delete(g.pending, id)
+	}
+	delete(g.consumers, name)
+	return len(c.pending), true
+}
+
+// deliver records the delivery of id to c, moving it from the consumer it was
+// pending for if any.
+func (g *streamGroup) deliver(id streamID, c *streamConsumer, now time.Time) *pendingEntry {
+	pe, ok := g.pending[id]
+	if !ok {
+		pe = &pendingEntry{}
+		g.pending[id] = pe
+	} else if pe.consumer != c {
+		delete(pe.consumer.pending, id)
+	}
+	pe.consumer = c
+	pe.deliveryTime = now
+	c.pending[id] = pe
+	return pe
+}
+
+// Ack removes id from the pending entries and reports whether it was pending.
+func (g *streamGroup) Ack(id streamID) bool {
+	pe, ok := g.pending[id]
+	if !ok {
+		return false
+	}
+	delete(pe.consumer.pending, id)
+	delete(g.pending, id)
+	return true
+}
+
+// sortedIDs returns the IDs of a pending entries list in increasing order.
+func sortedIDs(pending map[streamID]*pendingEntry) []streamID {
+	ids := make([]streamID, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, streamID.compare)
+	return ids
+}
+
+// sortedGroups returns the groups of the stream ordered by name.
+func (st *stream) sortedGroups() []*streamGroup {
+	groups := make([]*streamGroup, 0, len(st.groups))
+	for _, g := range st.groups {
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b *streamGroup) int { return strings.Compare(a.name, b.name) })
+	return groups
+}
+
+// clone returns a copy of the stream sharing no mutable storage with it. The
+// fields of the entries are never modified once added, so they are shared.
+func (st *stream) clone() *stream {
+	c := *st
+	c.nodes = make([]*streamNode, len(st.nodes))
+	for i, node := range st.nodes {
+		c.nodes[i] = &streamNode{entries: slices.Clone(node.entries), live: node.live}
+	}
+	c.groups = make(map[string]*streamGroup, len(st.groups))
+	for name, g := range st.groups {
+		cg := newStreamGroup(name, g.lastID, g.entriesRead)
+		for _, consumer := range g.consumers {
+			cc := cg.consumer(consumer.name, consumer.seenTime)
+			cc.activeTime = consumer.activeTime
+			for id, pe := range consumer.pending {
+				cpe := &pendingEntry{consumer: cc, deliveryTime: pe.deliveryTime, deliveryCount: pe.deliveryCount}
+				cc.pending[id] = cpe
+				cg.pending[id] = cpe
+			}
+		}
+		c.groups[name] = cg
+	}
+	return &c
+}
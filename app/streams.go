@@ -0,0 +1,1655 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var (
+	errStreamIDTooSmall  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDZero      = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamExhausted   = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+	errStreamMaxLen      = errors.New("ERR The MAXLEN argument must be >= 0.")
+	errLimitNegative     = errors.New("ERR The LIMIT argument must be >= 0.")
+	errLimitWithoutTilde = errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	errInvalidStartID    = errors.New("ERR invalid start ID for the interval")
+	errInvalidEndID      = errors.New("ERR invalid end ID for the interval")
+	errBlockTimeout      = errors.New("ERR timeout is not an integer or out of range")
+	errBusyGroup         = errors.New("BUSYGROUP Consumer Group name already exists")
+	errXGroupNoKey       = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	errEntriesRead       = errors.New("ERR value for ENTRIESREAD must be positive or -1")
+	errAutoClaimCount    = errors.New("ERR COUNT must be > 0")
+	errSetIDTooSmall     = errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+	errSetIDMaxDeleted   = errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	errSetIDEntriesAdded = errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	errStreamDeleted     = errors.New("UNBLOCKED the stream key no longer exists")
+	errGroupDestroyed    = errors.New("NOGROUP the consumer group this client was blocked on no longer exists")
+)
+
+// streamNodeLimit caps the entries removed by approximate trimming when no
+// LIMIT is given, in nodes.
+const streamNodeLimit = 100
+
+// xautoclaimAttempts is how many pending entries XAUTOCLAIM scans per entry it
+// may claim.
+const xautoclaimAttempts = 10
+
+func errNoGroup(key, group string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+}
+
+func errNoKeyOrGroup(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "XADD",
+			group:    "stream",
+			summary:  "Appends a new message to a stream. Creates the key if it doesn't exist.",
+			arity:    -5,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXAdd,
+		},
+		&commandSpec{
+			name:     "XRANGE",
+			group:    "stream",
+			summary:  "Returns the messages from a stream within a range of IDs.",
+			arity:    -4,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXRange,
+		},
+		&commandSpec{
+			name:     "XREVRANGE",
+			group:    "stream",
+			summary:  "Returns the messages from a stream within a range of IDs in reverse order.",
+			arity:    -4,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXRange,
+		},
+		&commandSpec{
+			name:     "XLEN",
+			group:    "stream",
+			summary:  "Return the number of messages in a stream.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXLen,
+		},
+		&commandSpec{
+			name:     "XDEL",
+			group:    "stream",
+			summary:  "Returns the number of messages after removing them from a stream.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXDel,
+		},
+		&commandSpec{
+			name:     "XTRIM",
+			group:    "stream",
+			summary:  "Deletes messages from the beginning of a stream.",
+			arity:    -4,
+			flags:    flagWrite,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXTrim,
+		},
+		&commandSpec{
+			name:     "XSETID",
+			group:    "stream",
+			summary:  "An internal command for replicating stream values.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXSetID,
+		},
+		&commandSpec{
+			name:     "XREAD",
+			group:    "stream",
+			summary:  "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.",
+			arity:    -4,
+			flags:    flagReadonly | flagBlocking,
+			keysFunc: streamsKeys("XREAD"),
+			handler:  (*server).handleXRead,
+		},
+		&commandSpec{
+			name:     "XREADGROUP",
+			group:    "stream",
+			summary:  "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.",
+			arity:    -7,
+			flags:    flagWrite | flagBlocking,
+			keysFunc: streamsKeys("XREADGROUP"),
+			handler:  (*server).handleXRead,
+		},
+		&commandSpec{
+			name:    "XGROUP",
+			group:   "stream",
+			summary: "A container for consumer groups commands.",
+			arity:   -2,
+			subcommands: subcommands(
+				&commandSpec{
+					name:     "CREATE",
+					summary:  "Creates a consumer group.",
+					arity:    -5,
+					flags:    flagWrite | flagDenyOOM,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXGroupCreate,
+				},
+				&commandSpec{
+					name:     "SETID",
+					summary:  "Sets the last-delivered ID of a consumer group.",
+					arity:    -5,
+					flags:    flagWrite,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXGroupSetID,
+				},
+				&commandSpec{
+					name:     "DESTROY",
+					summary:  "Destroys a consumer group.",
+					arity:    4,
+					flags:    flagWrite,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXGroupDestroy,
+				},
+				&commandSpec{
+					name:     "CREATECONSUMER",
+					summary:  "Creates a consumer in a consumer group.",
+					arity:    5,
+					flags:    flagWrite | flagDenyOOM,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXGroupConsumer,
+				},
+				&commandSpec{
+					name:     "DELCONSUMER",
+					summary:  "Deletes a consumer from a consumer group.",
+					arity:    5,
+					flags:    flagWrite,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXGroupConsumer,
+				},
+			),
+		},
+		&commandSpec{
+			name:     "XACK",
+			group:    "stream",
+			summary:  "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.",
+			arity:    -4,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXAck,
+		},
+		&commandSpec{
+			name:     "XPENDING",
+			group:    "stream",
+			summary:  "Returns the information and entries from a stream consumer group's pending entries list.",
+			arity:    -3,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXPending,
+		},
+		&commandSpec{
+			name:     "XCLAIM",
+			group:    "stream",
+			summary:  "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.",
+			arity:    -6,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXClaim,
+		},
+		&commandSpec{
+			name:     "XAUTOCLAIM",
+			group:    "stream",
+			summary:  "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.",
+			arity:    -6,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleXAutoClaim,
+		},
+		&commandSpec{
+			name:    "XINFO",
+			group:   "stream",
+			summary: "A container for stream introspection commands.",
+			arity:   -2,
+			subcommands: subcommands(
+				&commandSpec{
+					name:     "STREAM",
+					summary:  "Returns information about a stream.",
+					arity:    -3,
+					flags:    flagReadonly,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXInfoStream,
+				},
+				&commandSpec{
+					name:     "GROUPS",
+					summary:  "Returns a list of the consumer groups of a stream.",
+					arity:    3,
+					flags:    flagReadonly,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXInfoGroups,
+				},
+				&commandSpec{
+					name:     "CONSUMERS",
+					summary:  "Returns a list of the consumers in a consumer group.",
+					arity:    4,
+					flags:    flagReadonly,
+					firstKey: 2, lastKey: 2, keyStep: 1,
+					handler: (*server).handleXInfoConsumers,
+				},
+			),
+		},
+	)
+}
+
+// streamsKeys extracts the keys of XREAD and XREADGROUP, the first half of the
+// arguments following STREAMS. The arguments are parsed like the handler does,
+// since the group, the consumer and the option values may be named STREAMS too.
+func streamsKeys(cmd string) func(args []string) []string {
+	return func(args []string) []string {
+		q, err := parseXRead(cmd, args)
+		if err != nil {
+			// the handler fails the same way before touching any key
+			return nil
+		}
+		return q.keys
+	}
+}
+
+// lookupStream returns the stream stored at key, it fails with WRONGTYPE when
+// the key holds another type.
+func (s *server) lookupStream(key string, now time.Time) (*stream, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+	st, isStream := res.value.(*stream)
+	if !isStream {
+		return nil, false, errWrongType
+	}
+	return st, true, nil
+}
+
+// lookupGroup returns the stream at key along with one of its consumer groups,
+// failing with NOGROUP when either is missing.
+func (s *server) lookupGroup(key, group string, now time.Time) (*stream, *streamGroup, error) {
+	st, exists, err := s.lookupStream(key, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, errNoKeyOrGroup(key, group)
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return nil, nil, errNoKeyOrGroup(key, group)
+	}
+	return st, g, nil
+}
+
+func entryReply(e streamEntry) resp.Value {
+	return resp.Array(resp.BulkString(e.id.String()), resp.BulkStrings(e.fields))
+}
+
+func entriesReply(entries []streamEntry) resp.Value {
+	values := make([]resp.Value, len(entries))
+	for i, e := range entries {
+		values[i] = entryReply(e)
+	}
+	return resp.Array(values...)
+}
+
+func idsReply(ids []streamID) resp.Value {
+	values := make([]resp.Value, len(ids))
+	for i, id := range ids {
+		values[i] = resp.BulkString(id.String())
+	}
+	return resp.Array(values...)
+}
+
+// parseStrictIDs parses the IDs given to XDEL and XACK.
+func parseStrictIDs(args []string) ([]streamID, error) {
+	ids := make([]streamID, len(args))
+	for i, arg := range args {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseRangeID parses a bound of XRANGE and friends, where a missing sequence
+// number is 0 for start and the largest one for end, and a leading "(" excludes
+// the bound.
+func parseRangeID(arg string, start bool) (streamID, error) {
+	missingSeq := uint64(math.MaxUint64)
+	if start {
+		missingSeq = 0
+	}
+	exclusive := len(arg) > 1 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	id, err := parseStreamID(arg, missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	if start {
+		if id, ok := id.next(); ok {
+			return id, nil
+		}
+		return id, errInvalidStartID
+	}
+	if id, ok := id.prev(); ok {
+		return id, nil
+	}
+	return id, errInvalidEndID
+}
+
+// parseTrimOption parses the MAXLEN or MINID strategy at args[i] and its
+// threshold, returning the index of the following argument.
+func parseTrimOption(args []string, i int, t *streamTrim) (int, error) {
+	t.byMinID = strings.EqualFold(args[i], "MINID")
+	i++
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		t.approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return i, errSyntax
+	}
+	if t.byMinID {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return i, err
+		}
+		t.minID = id
+		return i + 1, nil
+	}
+	n, err := strconv.ParseInt(args[i], 10, 64)
+	if err != nil {
+		return i, errNotInteger
+	}
+	if n < 0 {
+		return i, errStreamMaxLen
+	}
+	t.maxLen = n
+	return i + 1, nil
+}
+
+// parseLimitOption parses the count following LIMIT at args[i].
+func parseLimitOption(args []string, i int) (int64, error) {
+	if i >= len(args) {
+		return 0, errSyntax
+	}
+	n, err := strconv.ParseInt(args[i], 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n < 0 {
+		return 0, errLimitNegative
+	}
+	return n, nil
+}
+
+// finishTrim validates the LIMIT of a trimming strategy and applies the default
+// one of approximate trimming.
+func (s *server) finishTrim(t *streamTrim, limitGiven bool) error {
+	if limitGiven && !t.approx {
+		return errLimitWithoutTilde
+	}
+	if t.approx && !limitGiven {
+		t.limit = int64(streamNodeLimit * max(s.Config.StreamNodeMaxEntries, 1))
+	}
+	return nil
+}
+
+// nextStreamID resolves the ID of a new entry given last, the last ID of the
+// stream: "*" is generated from the clock and ms-* takes the next sequence
+// number of that millisecond.
+func nextStreamID(arg string, last streamID, now time.Time) (streamID, error) {
+	if arg == "*" {
+		ms := uint64(now.UnixMilli())
+		if ms > last.ms {
+			return streamID{ms, 0}, nil
+		}
+		id, ok := last.next()
+		if !ok {
+			return id, errStreamExhausted
+		}
+		return id, nil
+	}
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+		switch {
+		case ms < last.ms:
+			return streamID{}, errStreamIDTooSmall
+		case ms > last.ms:
+			return streamID{ms, 0}, nil
+		case last.seq == math.MaxUint64:
+			return streamID{}, errStreamIDTooSmall
+		}
+		return streamID{ms, last.seq + 1}, nil
+	}
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return id, err
+	}
+	if id.isZero() {
+		return id, errStreamIDZero
+	}
+	if id.compare(last) <= 0 {
+		return id, errStreamIDTooSmall
+	}
+	return id, nil
+}
+
+func (s *server) handleXAdd(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	args := req.Args
+	noMkStream := false
+	var trim *streamTrim
+	limitGiven := false
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			continue
+		case "MAXLEN", "MINID":
+			if trim == nil {
+				trim = &streamTrim{}
+			}
+			next, err := parseTrimOption(args, i, trim)
+			if err != nil {
+				return nil, err
+			}
+			i = next - 1
+			continue
+		case "LIMIT":
+			limit, err := parseLimitOption(args, i+1)
+			if err != nil {
+				return nil, err
+			}
+			if trim == nil {
+				trim = &streamTrim{}
+			}
+			trim.limit, limitGiven = limit, true
+			i++
+			continue
+		}
+		break
+	}
+	if trim != nil {
+		if err := s.finishTrim(trim, limitGiven); err != nil {
+			return nil, err
+		}
+	}
+	if i >= len(args) {
+		return nil, errSyntax
+	}
+	idArg, fields := args[i], args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, errWrongArgs("xadd")
+	}
+	now := time.Now()
+	st, exists, err := s.lookupStream(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if noMkStream {
+			return reply(resp.NullBulk())
+		}
+		st = newStream()
+	}
+	if st.lastID == maxStreamID {
+		return nil, errStreamExhausted
+	}
+	id, err := nextStreamID(idArg, st.lastID, now)
+	if err != nil {
+		return nil, err
+	}
+	st.Add(id, append([]string(nil), fields...), s.Config.StreamNodeMaxEntries)
+	if !exists {
+		s.InMemoryStore.set(key, &Resource{value: st})
+	}
+	if trim != nil {
+		st.Trim(*trim)
+	}
+	s.addDirty(1)
+	s.signalKeyReady(key)
+	return reply(resp.BulkString(id.String()))
+}
+
+// handleXRange serves XRANGE and XREVRANGE, which takes the end of the range
+// first.
+func (s *server) handleXRange(req *Request) ([]resp.Value, error) {
+	reverse := strings.EqualFold(string(req.Command), "XREVRANGE")
+	startArg, endArg := req.Args[1], req.Args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseRangeID(startArg, true)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseRangeID(endArg, false)
+	if err != nil {
+		return nil, err
+	}
+	count := -1
+	switch len(req.Args) {
+	case 3:
+	case 5:
+		if !strings.EqualFold(req.Args[3], "COUNT") {
+			return nil, errSyntax
+		}
+		n, err := strconv.ParseInt(req.Args[4], 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		count = int(max(min(n, math.MaxInt32), 0))
+	default:
+		return nil, errSyntax
+	}
+	st, exists, err := s.lookupStream(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists || count == 0 {
+		return reply(resp.Array())
+	}
+	return reply(entriesReply(st.Range(start, end, count, reverse)))
+}
+
+func (s *server) handleXLen(req *Request) ([]resp.Value, error) {
+	st, exists, err := s.lookupStream(req.Args[0], time.Now())
+	if err != nil || !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(int64(st.Len())))
+}
+
+func (s *server) handleXDel(req *Request) ([]resp.Value, error) {
+	ids, err := parseStrictIDs(req.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	st, exists, err := s.lookupStream(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	deleted := 0
+	for _, id := range ids {
+		if st.Delete(id) {
+			deleted++
+		}
+	}
+	s.addDirty(deleted)
+	return reply(resp.Integer(int64(deleted)))
+}
+
+// handleXSetID sets the last ID of a stream, along with the counters used to
+// compute the lag of its groups. The append only file relies on it to restore
+// streams whose last entries were deleted.
+func (s *server) handleXSetID(req *Request) ([]resp.Value, error) {
+	args := req.Args
+	lastID, err := parseStreamID(args[1], 0)
+	if err != nil {
+		return nil, err
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *streamID
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if n < 0 {
+				return nil, errors.New("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			id, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return nil, err
+			}
+			if lastID.compare(id) < 0 {
+				return nil, errSetIDMaxDeleted
+			}
+			maxDeletedID = &id
+		default:
+			return nil, errSyntax
+		}
+	}
+	st, exists, err := s.lookupStream(args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoSuchKey
+	}
+	if last, ok := st.Last(); ok && lastID.compare(last.id) < 0 {
+		return nil, errSetIDTooSmall
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(st.Len()) {
+		return nil, errSetIDEntriesAdded
+	}
+	st.lastID = lastID
+	if entriesAdded >= 0 {
+		st.entriesAdded = entriesAdded
+	}
+	if maxDeletedID != nil {
+		st.maxDeletedID = *maxDeletedID
+	}
+	s.addDirty(1)
+	return reply(resp.OK)
+}
+
+func (s *server) handleXTrim(req *Request) ([]resp.Value, error) {
+	args := req.Args
+	var trim *streamTrim
+	limitGiven := false
+	for i := 1; i < len(args); {
+		switch strings.ToUpper(args[i]) {
+		case "MAXLEN", "MINID":
+			trim = &streamTrim{}
+			next, err := parseTrimOption(args, i, trim)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+		case "LIMIT":
+			limit, err := parseLimitOption(args, i+1)
+			if err != nil {
+				return nil, err
+			}
+			if trim == nil {
+				return nil, errSyntax
+			}
+			trim.limit, limitGiven = limit, true
+			i += 2
+		default:
+			return nil, errSyntax
+		}
+	}
+	if trim == nil {
+		return nil, errSyntax
+	}
+	if err := s.finishTrim(trim, limitGiven); err != nil {
+		return nil, err
+	}
+	st, exists, err := s.lookupStream(args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	trimmed := st.Trim(*trim)
+	s.addDirty(int(trimmed))
+	return reply(resp.Integer(trimmed))
+}
+
+// xreadQuery holds the arguments of XREAD and XREADGROUP.
+type xreadQuery struct {
+	group, consumer string
+	count           int
+	block           bool
+	timeout         time.Duration
+	noAck           bool
+	keys, ids       []string
+}
+
+func parseXRead(cmd string, args []string) (xreadQuery, error) {
+	q := xreadQuery{}
+	isGroup := strings.EqualFold(cmd, "XREADGROUP")
+	i := 0
+	for ; i < len(args); i++ {
+		more := i+1 < len(args)
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if !more {
+				return q, errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return q, errNotInteger
+			}
+			q.count = int(max(min(n, math.MaxInt32), 0))
+			i++
+		case "BLOCK":
+			if !more {
+				return q, errSyntax
+			}
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+				return q, errBlockTimeout
+			}
+			if ms < 0 {
+				return q, errTimeoutNegative
+			}
+			q.block, q.timeout = true, time.Duration(ms)*time.Millisecond
+			i++
+		case "GROUP":
+			if !isGroup {
+				return q, errors.New("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			if i+2 >= len(args) {
+				return q, errSyntax
+			}
+			q.group, q.consumer = args[i+1], args[i+2]
+			i += 2
+		case "NOACK":
+			if !isGroup {
+				return q, errSyntax
+			}
+			q.noAck = true
+		case "STREAMS":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return q, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(cmd))
+			}
+			q.keys, q.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+			if isGroup && q.group == "" {
+				return q, errors.New("ERR Missing GROUP option for XREADGROUP")
+			}
+			return q, nil
+		default:
+			return q, errSyntax
+		}
+	}
+	return q, errSyntax
+}
+
+// handleXRead serves XREAD and XREADGROUP. Both reply with the entries of every
+// stream having some, and block until one of them gets new entries otherwise.
+func (s *server) handleXRead(req *Request) ([]resp.Value, error) {
+	q, err := parseXRead(string(req.Command), req.Args)
+	if err != nil {
+		return nil, err
+	}
+	if q.group != "" {
+		return s.xreadGroup(req, q)
+	}
+	now := time.Now()
+	// resolve the IDs first, so that "$" means the entries added while blocked
+	after := make(map[string]streamID, len(q.keys))
+	for i, key := range q.keys {
+		st, exists, err := s.lookupStream(key, now)
+		if err != nil {
+			return nil, err
+		}
+		var id streamID
+		switch q.ids[i] {
+		case "$":
+			if exists {
+				id = st.lastID
+			}
+		case "+":
+			// the last entry itself
+			if exists {
+				if e, ok := st.Last(); ok {
+					id, _ = e.id.prev()
+				}
+			}
+		default:
+			if id, err = parseStreamID(q.ids[i], 0); err != nil {
+				return nil, err
+			}
+		}
+		after[key] = id
+	}
+	read := func(key string) (resp.Value, bool, error) {
+		st, exists, err := s.lookupStream(key, time.Now())
+		if err != nil || !exists {
+			return resp.Value{}, false, err
+		}
+		start, ok := after[key].next()
+		if !ok {
+			return resp.Value{}, false, nil
+		}
+		entries := st.Range(start, maxStreamID, q.count, false)
+		if len(entries) == 0 {
+			return resp.Value{}, false, nil
+		}
+		return resp.Array(resp.BulkString(key), entriesReply(entries)), true, nil
+	}
+	replies := []resp.Value{}
+	for _, key := range q.keys {
+		value, ok, err := read(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			replies = append(replies, value)
+		}
+	}
+	if len(replies) > 0 {
+		return reply(resp.Array(replies...))
+	}
+	if !q.block {
+		return reply(resp.NullArray())
+	}
+	return s.block(req, &blockedClient{
+		valueType: "stream",
+		keys:      q.keys,
+		serve: func(key string) (resp.Value, bool, error) {
+			value, ok, err := read(key)
+			if !ok {
+				return value, ok, err
+			}
+			return resp.Array(value), true, nil
+		},
+		timeout:      q.timeout,
+		timeoutReply: resp.NullArray(),
+	})
+}
+
+// deliverNew hands the entries of st the group has not read yet to c, adding
+// them to the pending entries list unless noAck is set.
+func deliverNew(st *stream, g *streamGroup, c *streamConsumer, count int, noAck bool, now time.Time) []streamEntry {
+	start, ok := g.lastID.next()
+	if !ok {
+		return []streamEntry{}
+	}
+	entries := st.Range(start, maxStreamID, count, false)
+	for _, e := range entries {
+		if g.entriesRead >= 0 && !st.hasTombstones(g.lastID) {
+			g.entriesRead++
+		} else if read, ok := st.entriesBefore(e.id); ok {
+			g.entriesRead = read
+		} else {
+			g.entriesRead = -1
+		}
+		g.lastID = e.id
+		if !noAck {
+			g.deliver(e.id, c, now).deliveryCount = 1
+		}
+	}
+	if len(entries) > 0 {
+		c.activeTime = now
+	}
+	return entries
+}
+
+// deliverHistory returns the entries pending for c after the given ID, the ones
+// since deleted from the stream have no fields, and how many were delivered
+// again.
+func deliverHistory(st *stream, c *streamConsumer, after streamID, count int, now time.Time) (resp.Value, int) {
+	values := []resp.Value{}
+	delivered := 0
+	for _, id := range sortedIDs(c.pending) {
+		if id.compare(after) <= 0 {
+			continue
+		}
+		if count > 0 && len(values) >= count {
+			break
+		}
+		e, ok := st.Get(id)
+		if !ok {
+			values = append(values, resp.Array(resp.BulkString(id.String()), resp.NullArray()))
+			continue
+		}
+		pe := c.pending[id]
+		pe.deliveryTime = now
+		pe.deliveryCount++
+		delivered++
+		values = append(values, entryReply(e))
+	}
+	return resp.Array(values...), delivered
+}
+
+func (s *server) xreadGroup(req *Request, q xreadQuery) ([]resp.Value, error) {
+	now := time.Now()
+	history := make(map[string]streamID, len(q.keys))
+	for i, key := range q.keys {
+		if _, _, err := s.lookupGroup(key, q.group, now); err != nil {
+			if errors.Is(err, errWrongType) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, q.group)
+		}
+		switch q.ids[i] {
+		case ">":
+		case "$":
+			return nil, errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			id, err := parseStreamID(q.ids[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			history[key] = id
+		}
+	}
+	readNew := func(key string) (resp.Value, bool, error) {
+		now := time.Now()
+		st, g, err := s.lookupGroup(key, q.group, now)
+		if err != nil {
+			return resp.Value{}, false, err
+		}
+		c := g.consumer(q.consumer, now)
+		c.seenTime = now
+		entries := deliverNew(st, g, c, q.count, q.noAck, now)
+		if len(entries) == 0 {
+			return resp.Value{}, false, nil
+		}
+		s.addDirty(len(entries))
+		return resp.Array(resp.BulkString(key), entriesReply(entries)), true, nil
+	}
+	replies := []resp.Value{}
+	for _, key := range q.keys {
+		if after, ok := history[key]; ok {
+			st, g, _ := s.lookupGroup(key, q.group, now)
+			c := g.consumer(q.consumer, now)
+			c.seenTime = now
+			entries, delivered := deliverHistory(st, c, after, q.count, now)
+			s.addDirty(delivered)
+			replies = append(replies, resp.Array(resp.BulkString(key), entries))
+			continue
+		}
+		value, ok, err := readNew(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			replies = append(replies, value)
+		}
+	}
+	if len(replies) > 0 {
+		return reply(resp.Array(replies...))
+	}
+	if !q.block {
+		return reply(resp.NullArray())
+	}
+	return s.block(req, &blockedClient{
+		valueType: "stream",
+		keys:      q.keys,
+		serve: func(key string) (resp.Value, bool, error) {
+			value, ok, err := readNew(key)
+			if err != nil {
+				// the key holds a stream, the group is gone
+				return value, false, errGroupDestroyed
+			}
+			if !ok {
+				return value, false, nil
+			}
+			return resp.Array(value), true, nil
+		},
+		timeout:      q.timeout,
+		timeoutReply: resp.NullArray(),
+		deletedErr:   errStreamDeleted,
+	})
+}
+
+// parseEntriesRead parses the optional ENTRIESREAD argument of XGROUP CREATE
+// and SETID at args[i].
+func parseEntriesRead(args []string, i int) (int64, error) {
+	if i+1 >= len(args) || !strings.EqualFold(args[i], "ENTRIESREAD") {
+		return 0, errSyntax
+	}
+	n, err := strconv.ParseInt(args[i+1], 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n < -1 {
+		return 0, errEntriesRead
+	}
+	return n, nil
+}
+
+// groupLastID resolves the last delivered ID given to XGROUP CREATE and SETID,
+// "$" being the last ID of the stream.
+func groupLastID(st *stream, arg string) (streamID, error) {
+	if arg == "$" {
+		if st == nil {
+			return streamID{}, nil
+		}
+		return st.lastID, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+func (s *server) handleXGroupCreate(req *Request) ([]resp.Value, error) {
+	key, name := req.Args[1], req.Args[2]
+	mkStream := false
+	entriesRead := int64(-1)
+	for i := 4; i < len(req.Args); i++ {
+		if strings.EqualFold(req.Args[i], "MKSTREAM") {
+			mkStream = true
+			continue
+		}
+		n, err := parseEntriesRead(req.Args, i)
+		if err != nil {
+			return nil, err
+		}
+		entriesRead = n
+		i++
+	}
+	st, exists, err := s.lookupStream(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists && !mkStream {
+		return nil, errXGroupNoKey
+	}
+	lastID, err := groupLastID(st, req.Args[3])
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		st = newStream()
+		s.InMemoryStore.set(key, &Resource{value: st})
+	}
+	if _, ok := st.groups[name]; ok {
+		return nil, errBusyGroup
+	}
+	st.groups[name] = newStreamGroup(name, lastID, entriesRead)
+	s.addDirty(1)
+	return reply(resp.SimpleString("OK"))
+}
+
+func (s *server) handleXGroupSetID(req *Request) ([]resp.Value, error) {
+	key, name := req.Args[1], req.Args[2]
+	entriesRead := int64(-1)
+	if len(req.Args) > 4 {
+		if len(req.Args) != 6 {
+			return nil, errSyntax
+		}
+		n, err := parseEntriesRead(req.Args, 4)
+		if err != nil {
+			return nil, err
+		}
+		entriesRead = n
+	}
+	st, exists, err := s.lookupStream(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errXGroupNoKey
+	}
+	g, ok := st.groups[name]
+	if !ok {
+		return nil, errNoGroup(key, name)
+	}
+	lastID, err := groupLastID(st, req.Args[3])
+	if err != nil {
+		return nil, err
+	}
+	g.lastID, g.entriesRead = lastID, entriesRead
+	s.addDirty(1)
+	return reply(resp.SimpleString("OK"))
+}
+
+func (s *server) handleXGroupDestroy(req *Request) ([]resp.Value, error) {
+	key, name := req.Args[1], req.Args[2]
+	st, exists, err := s.lookupStream(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errXGroupNoKey
+	}
+	if _, ok := st.groups[name]; !ok {
+		return reply(resp.Integer(0))
+	}
+	delete(st.groups, name)
+	s.addDirty(1)
+	// clients blocked reading the group fail
+	s.signalKeyReady(key)
+	return reply(resp.Integer(1))
+}
+
+// handleXGroupConsumer serves XGROUP CREATECONSUMER and DELCONSUMER.
+func (s *server) handleXGroupConsumer(req *Request) ([]resp.Value, error) {
+	key, name, consumer := req.Args[1], req.Args[2], req.Args[3]
+	now := time.Now()
+	st, exists, err := s.lookupStream(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errXGroupNoKey
+	}
+	g, ok := st.groups[name]
+	if !ok {
+		return nil, errNoGroup(key, name)
+	}
+	if strings.EqualFold(req.Args[0], "DELCONSUMER") {
+		pending, deleted := g.deleteConsumer(consumer)
+		if deleted {
+			s.addDirty(1)
+		}
+		return reply(resp.Integer(int64(pending)))
+	}
+	if _, ok := g.consumers[consumer]; ok {
+		return reply(resp.Integer(0))
+	}
+	g.consumer(consumer, now)
+	s.addDirty(1)
+	return reply(resp.Integer(1))
+}
+
+func (s *server) handleXAck(req *Request) ([]resp.Value, error) {
+	ids, err := parseStrictIDs(req.Args[2:])
+	if err != nil {
+		return nil, err
+	}
+	st, exists, err := s.lookupStream(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	g, ok := st.groups[req.Args[1]]
+	if !ok {
+		return reply(resp.Integer(0))
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			acked++
+		}
+	}
+	s.addDirty(acked)
+	return reply(resp.Integer(int64(acked)))
+}
+
+func (s *server) handleXPending(req *Request) ([]resp.Value, error) {
+	key, name := req.Args[0], req.Args[1]
+	args := req.Args[2:]
+	var minIdle time.Duration
+	if len(args) > 0 && strings.EqualFold(args[0], "IDLE") {
+		if len(args) < 2 {
+			return nil, errSyntax
+		}
+		ms, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		minIdle = time.Duration(max(ms, 0)) * time.Millisecond
+		args = args[2:]
+		if len(args) == 0 {
+			return nil, errSyntax
+		}
+	}
+	if len(args) != 0 && len(args) != 3 && len(args) != 4 {
+		return nil, errSyntax
+	}
+	now := time.Now()
+	_, g, err := s.lookupGroup(key, name, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return reply(pendingSummary(g))
+	}
+	start, err := parseRangeID(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseRangeID(args[1], false)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	pending := g.pending
+	if len(args) == 4 {
+		c, ok := g.consumers[args[3]]
+		if !ok {
+			return reply(resp.Array())
+		}
+		pending = c.pending
+	}
+	values := []resp.Value{}
+	for _, id := range sortedIDs(pending) {
+		if int64(len(values)) >= count {
+			break
+		}
+		if id.compare(start) < 0 || id.compare(end) > 0 {
+			continue
+		}
+		pe := pending[id]
+		idle := now.Sub(pe.deliveryTime)
+		if idle < minIdle {
+			continue
+		}
+		values = append(values, resp.Array(
+			resp.BulkString(id.String()),
+			resp.BulkString(pe.consumer.name),
+			resp.Integer(max(idle.Milliseconds(), 0)),
+			resp.Integer(pe.deliveryCount),
+		))
+	}
+	return reply(resp.Array(values...))
+}
+
+// pendingSummary is the reply of XPENDING without a range: the number of
+// pending entries, the smallest and largest of their IDs and how many each
+// consumer has.
+func pendingSummary(g *streamGroup) resp.Value {
+	if len(g.pending) == 0 {
+		return resp.Array(resp.Integer(0), resp.NullBulk(), resp.NullBulk(), resp.NullArray())
+	}
+	ids := sortedIDs(g.pending)
+	consumers := []resp.Value{}
+	for _, c := range sortedConsumers(g) {
+		if len(c.pending) > 0 {
+			consumers = append(consumers, resp.BulkStrings([]string{c.name, strconv.Itoa(len(c.pending))}))
+		}
+	}
+	return resp.Array(
+		resp.Integer(int64(len(ids))),
+		resp.BulkString(ids[0].String()),
+		resp.BulkString(ids[len(ids)-1].String()),
+		resp.Array(consumers...),
+	)
+}
+
+func (s *server) handleXClaim(req *Request) ([]resp.Value, error) {
+	key, name, consumer := req.Args[0], req.Args[1], req.Args[2]
+	minIdleMs, err := strconv.ParseInt(req.Args[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	minIdle := time.Duration(max(minIdleMs, 0)) * time.Millisecond
+	// the IDs run until the first argument that isn't one
+	args := req.Args[4:]
+	ids := []streamID{}
+	for len(args) > 0 {
+		id, err := parseStreamID(args[0], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+		args = args[1:]
+	}
+	now := time.Now()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *streamID
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		more := i+1 < len(args)
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case option == "IDLE" && more, option == "TIME" && more, option == "RETRYCOUNT" && more:
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ERR Invalid %s option argument for XCLAIM", option)
+			}
+			switch option {
+			case "IDLE":
+				deliveryTime = now.Add(-time.Duration(n) * time.Millisecond)
+			case "TIME":
+				deliveryTime = time.UnixMilli(n)
+			default:
+				retryCount = n
+			}
+			i++
+		case option == "LASTID" && more:
+			id, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return nil, err
+			}
+			lastID = &id
+			i++
+		default:
+			return nil, fmt.Errorf("ERR Unrecognized XCLAIM option '%s'", args[i])
+		}
+	}
+	if deliveryTime.After(now) {
+		deliveryTime = now
+	}
+	st, g, err := s.lookupGroup(key, name, now)
+	if err != nil {
+		return nil, err
+	}
+	if lastID != nil && lastID.compare(g.lastID) > 0 {
+		g.lastID = *lastID
+		s.addDirty(1)
+	}
+	values := []resp.Value{}
+	for _, id := range ids {
+		e, inStream := st.Get(id)
+		pe, pending := g.pending[id]
+		if !inStream {
+			// deleted entries can't be claimed, drop them from the group
+			if pending {
+				g.Ack(id)
+				s.addDirty(1)
+			}
+			continue
+		}
+		if !pending {
+			if !force {
+				continue
+			}
+			pe = &pendingEntry{deliveryCount: 1}
+		} else if minIdle > 0 && now.Sub(pe.deliveryTime) < minIdle {
+			continue
+		}
+		c := g.consumer(consumer, now)
+		c.seenTime, c.activeTime = now, now
+		count := pe.deliveryCount
+		pe = g.deliver(id, c, deliveryTime)
+		switch {
+		case retryCount >= 0:
+			pe.deliveryCount = retryCount
+		case !justID:
+			pe.deliveryCount = count + 1
+		default:
+			pe.deliveryCount = count
+		}
+		s.addDirty(1)
+		if justID {
+			values = append(values, resp.BulkString(id.String()))
+		} else {
+			values = append(values, entryReply(e))
+		}
+	}
+	return reply(resp.Array(values...))
+}
+
+func (s *server) handleXAutoClaim(req *Request) ([]resp.Value, error) {
+	key, name, consumer := req.Args[0], req.Args[1], req.Args[2]
+	minIdleMs, err := strconv.ParseInt(req.Args[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	minIdle := time.Duration(max(minIdleMs, 0)) * time.Millisecond
+	start, err := parseRangeID(req.Args[4], true)
+	if err != nil {
+		return nil, err
+	}
+	count, justID := int64(100), false
+	args := req.Args[5:]
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "JUSTID":
+			justID = true
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if n < 1 || n > math.MaxInt64/xautoclaimAttempts {
+				return nil, errAutoClaimCount
+			}
+			count = n
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	now := time.Now()
+	st, g, err := s.lookupGroup(key, name, now)
+	if err != nil {
+		return nil, err
+	}
+	attempts := count * xautoclaimAttempts
+	claimed := []resp.Value{}
+	deleted := []streamID{}
+	next := streamID{}
+	ids := sortedIDs(g.pending)
+	for _, id := range ids {
+		if id.compare(start) < 0 {
+			continue
+		}
+		if attempts == 0 || int64(len(claimed)) >= count {
+			next = id
+			break
+		}
+		attempts--
+		e, inStream := st.Get(id)
+		if !inStream {
+			g.Ack(id)
+			deleted = append(deleted, id)
+			continue
+		}
+		pe := g.pending[id]
+		if minIdle > 0 && now.Sub(pe.deliveryTime) < minIdle {
+			continue
+		}
+		c := g.consumer(consumer, now)
+		c.seenTime, c.activeTime = now, now
+		count := pe.deliveryCount
+		pe = g.deliver(id, c, now)
+		pe.deliveryCount = count
+		if justID {
+			claimed = append(claimed, resp.BulkString(id.String()))
+		} else {
+			pe.deliveryCount++
+			claimed = append(claimed, entryReply(e))
+		}
+	}
+	s.addDirty(len(claimed) + len(deleted))
+	return reply(resp.Array(resp.BulkString(next.String()), resp.Array(claimed...), idsReply(deleted)))
+}
+
+// sortedConsumers returns the consumers of the group ordered by name.
+func sortedConsumers(g *streamGroup) []*streamConsumer {
+	consumers := make([]*streamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	slices.SortFunc(consumers, func(a, b *streamConsumer) int { return strings.Compare(a.name, b.name) })
+	return consumers
+}
+
+// optionalEntry replies with e, or nil when there is no such entry.
+func optionalEntry(e streamEntry, ok bool) resp.Value {
+	if !ok {
+		return resp.NullBulk()
+	}
+	return entryReply(e)
+}
+
+func entriesReadReply(g *streamGroup) resp.Value {
+	if g.entriesRead < 0 {
+		return resp.NullBulk()
+	}
+	return resp.Integer(g.entriesRead)
+}
+
+func lagReply(st *stream, g *streamGroup) resp.Value {
+	lag, ok := st.lag(g)
+	if !ok {
+		return resp.NullBulk()
+	}
+	return resp.Integer(lag)
+}
+
+func (s *server) handleXInfoStream(req *Request) ([]resp.Value, error) {
+	full, count := false, 10
+	switch len(req.Args) {
+	case 2:
+	case 3, 5:
+		if !strings.EqualFold(req.Args[2], "FULL") {
+			return nil, errSyntax
+		}
+		full = true
+		if len(req.Args) == 5 {
+			if !strings.EqualFold(req.Args[3], "COUNT") {
+				return nil, errSyntax
+			}
+			n, err := strconv.ParseInt(req.Args[4], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			count = int(max(min(n, math.MaxInt32), 0))
+		}
+	default:
+		return nil, errSyntax
+	}
+	now := time.Now()
+	st, exists, err := s.lookupStream(req.Args[1], now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoSuchKey
+	}
+	values := []resp.Value{
+		resp.BulkString("length"), resp.Integer(int64(st.Len())),
+		resp.BulkString("radix-tree-keys"), resp.Integer(int64(len(st.nodes))),
+		resp.BulkString("radix-tree-nodes"), resp.Integer(int64(len(st.nodes))),
+		resp.BulkString("last-generated-id"), resp.BulkString(st.lastID.String()),
+		resp.BulkString("max-deleted-entry-id"), resp.BulkString(st.maxDeletedID.String()),
+		resp.BulkString("entries-added"), resp.Integer(st.entriesAdded),
+		resp.BulkString("recorded-first-entry-id"), resp.BulkString(st.firstID().String()),
+	}
+	if !full {
+		first, hasFirst := st.First()
+		last, hasLast := st.Last()
+		values = append(values,
+			resp.BulkString("groups"), resp.Integer(int64(len(st.groups))),
+			resp.BulkString("first-entry"), optionalEntry(first, hasFirst),
+			resp.BulkString("last-entry"), optionalEntry(last, hasLast),
+		)
+		return reply(resp.Array(values...))
+	}
+	groups := []resp.Value{}
+	for _, g := range st.sortedGroups() {
+		pending := []resp.Value{}
+		for _, id := range sortedIDs(g.pending) {
+			if count > 0 && len(pending) >= count {
+				break
+			}
+			pe := g.pending[id]
+			pending = append(pending, resp.Array(
+				resp.BulkString(id.String()),
+				resp.BulkString(pe.consumer.name),
+				resp.Integer(pe.deliveryTime.UnixMilli()),
+				resp.Integer(pe.deliveryCount),
+			))
+		}
+		consumers := []resp.Value{}
+		for _, c := range sortedConsumers(g) {
+			cpending := []resp.Value{}
+			for _, id := range sortedIDs(c.pending) {
+				if count > 0 && len(cpending) >= count {
+					break
+				}
+				pe := c.pending[id]
+				cpending = append(cpending, resp.Array(
+					resp.BulkString(id.String()),
+					resp.Integer(pe.deliveryTime.UnixMilli()),
+					resp.Integer(pe.deliveryCount),
+				))
+			}
+			activeTime := int64(-1)
+			if !c.activeTime.IsZero() {
+				activeTime = c.activeTime.UnixMilli()
+			}
+			consumers = append(consumers, resp.Array(
+				resp.BulkString("name"), resp.BulkString(c.name),
+				resp.BulkString("seen-time"), resp.Integer(c.seenTime.UnixMilli()),
+				resp.BulkString("active-time"), resp.Integer(activeTime),
+				resp.BulkString("pel-count"), resp.Integer(int64(len(c.pending))),
+				resp.BulkString("pending"), resp.Array(cpending...),
+			))
+		}
+		groups = append(groups, resp.Array(
+			resp.BulkString("name"), resp.BulkString(g.name),
+			resp.BulkString("last-delivered-id"), resp.BulkString(g.lastID.String()),
+			resp.BulkString("entries-read"), entriesReadReply(g),
+			resp.BulkString("lag"), lagReply(st, g),
+			resp.BulkString("pel-count"), resp.Integer(int64(len(g.pending))),
+			resp.BulkString("pending"), resp.Array(pending...),
+			resp.BulkString("consumers"), resp.Array(consumers...),
+		))
+	}
+	values = append(values,
+		resp.BulkString("entries"), entriesReply(st.Range(streamID{}, maxStreamID, count, false)),
+		resp.BulkString("groups"), resp.Array(groups...),
+	)
+	return reply(resp.Array(values...))
+}
+
+func (s *server) handleXInfoGroups(req *Request) ([]resp.Value, error) {
+	st, exists, err := s.lookupStream(req.Args[1], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoSuchKey
+	}
+	values := []resp.Value{}
+	for _, g := range st.sortedGroups() {
+		values = append(values, resp.Array(
+			resp.BulkString("name"), resp.BulkString(g.name),
+			resp.BulkString("consumers"), resp.Integer(int64(len(g.consumers))),
+			resp.BulkString("pending"), resp.Integer(int64(len(g.pending))),
+			resp.BulkString("last-delivered-id"), resp.BulkString(g.lastID.String()),
+			resp.BulkString("entries-read"), entriesReadReply(g),
+			resp.BulkString("lag"), lagReply(st, g),
+		))
+	}
+	return reply(resp.Array(values...))
+}
+
+func (s *server) handleXInfoConsumers(req *Request) ([]resp.Value, error) {
+	key, name := req.Args[1], req.Args[2]
+	now := time.Now()
+	st, exists, err := s.lookupStream(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNoSuchKey
+	}
+	g, ok := st.groups[name]
+	if !ok {
+		return nil, errNoGroup(key, name)
+	}
+	values := []resp.Value{}
+	for _, c := range sortedConsumers(g) {
+		inactive := int64(-1)
+		if !c.activeTime.IsZero() {
+			inactive = max(now.Sub(c.activeTime).Milliseconds(), 0)
+		}
+		values = append(values, resp.Array(
+			resp.BulkString("name"), resp.BulkString(c.name),
+			resp.BulkString("pending"), resp.Integer(int64(len(c.pending))),
+			resp.BulkString("idle"), resp.Integer(max(now.Sub(c.seenTime).Milliseconds(), 0)),
+			resp.BulkString("inactive"), resp.Integer(inactive),
+		))
+	}
+	return reply(resp.Array(values...))
+}
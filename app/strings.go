@@ -0,0 +1,898 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+// string values are stored either as []byte or, when they are the canonical
+// representation of a 64 bit integer, as int64
+const maxStringSize = 512 * 1024 * 1024
+
+var (
+	errOverflow       = errors.New("ERR increment or decrement would overflow")
+	errNotFloat       = errors.New("ERR value is not a valid float")
+	errStringTooLarge = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	errOffsetRange    = errors.New("ERR offset is out of range")
+)
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "SET",
+			group:    "string",
+			summary:  "Sets the string value of a key, ignoring its type.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSet,
+		},
+		&commandSpec{
+			name:     "GET",
+			group:    "string",
+			summary:  "Returns the string value of a key.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGet,
+		},
+		&commandSpec{
+			name:     "INCR",
+			group:    "string",
+			summary:  "Increments the integer value of a key by one.",
+			arity:    2,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleIncr,
+		},
+		&commandSpec{
+			name:     "DECR",
+			group:    "string",
+			summary:  "Decrements the integer value of a key by one.",
+			arity:    2,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleIncr,
+		},
+		&commandSpec{
+			name:     "INCRBY",
+			group:    "string",
+			summary:  "Increments the integer value of a key by a number.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleIncr,
+		},
+		&commandSpec{
+			name:     "DECRBY",
+			group:    "string",
+			summary:  "Decrements a number from the integer value of a key.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleIncr,
+		},
+		&commandSpec{
+			name:     "INCRBYFLOAT",
+			group:    "string",
+			summary:  "Increment the floating point value of a key by a number.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleIncrByFloat,
+		},
+		&commandSpec{
+			name:     "APPEND",
+			group:    "string",
+			summary:  "Appends a string to the value of a key.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleAppend,
+		},
+		&commandSpec{
+			name:     "STRLEN",
+			group:    "string",
+			summary:  "Returns the length of a string value.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleStrlen,
+		},
+		&commandSpec{
+			name:     "GETRANGE",
+			group:    "string",
+			summary:  "Returns a substring of the string stored at a key.",
+			arity:    4,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGetRange,
+		},
+		&commandSpec{
+			name:     "SETRANGE",
+			group:    "string",
+			summary:  "Overwrites a part of a string value with another by an offset.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSetRange,
+		},
+		&commandSpec{
+			name:     "GETSET",
+			group:    "string",
+			summary:  "Returns the previous string value of a key after setting it to a new value.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGetSet,
+		},
+		&commandSpec{
+			name:     "GETDEL",
+			group:    "string",
+			summary:  "Returns the string value of a key after deleting the key.",
+			arity:    2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGetDel,
+		},
+		&commandSpec{
+			name:     "GETEX",
+			group:    "string",
+			summary:  "Returns the string value of a key after setting its expiration time.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleGetEx,
+		},
+		&commandSpec{
+			name:     "MSET",
+			group:    "string",
+			summary:  "Atomically creates or modifies the string values of one or more keys.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: -1, keyStep: 2,
+			handler: (*server).handleMSet,
+		},
+		&commandSpec{
+			name:     "MSETNX",
+			group:    "string",
+			summary:  "Atomically modifies the string values of one or more keys only when all keys don't exist.",
+			arity:    -3,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: -1, keyStep: 2,
+			handler: (*server).handleMSet,
+		},
+		&commandSpec{
+			name:     "MGET",
+			group:    "string",
+			summary:  "Atomically returns the string values of one or more keys.",
+			arity:    -2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: -1, keyStep: 1,
+			handler: (*server).handleMGet,
+		},
+		&commandSpec{
+			name:     "SETNX",
+			group:    "string",
+			summary:  "Set the string value of a key only when the key doesn't exist.",
+			arity:    3,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSetNX,
+		},
+		&commandSpec{
+			name:     "SETEX",
+			group:    "string",
+			summary:  "Sets the string value and expiration time of a key.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSetEx,
+		},
+		&commandSpec{
+			name:     "PSETEX",
+			group:    "string",
+			summary:  "Sets both string value and expiration time in milliseconds of a key.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleSetEx,
+		},
+		&commandSpec{
+			name:     "LCS",
+			group:    "string",
+			summary:  "Finds the longest common substring.",
+			arity:    -3,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleLcs,
+		},
+	)
+}
+
+// encodeString picks the encoding of a string value, integers are only stored as
+// int64 when formatting them back gives the exact same bytes.
+func encodeString(s string) interface{} {
+	if n, ok := parseCanonicalInt(s); ok {
+		return n
+	}
+	return []byte(s)
+}
+
+// parseCanonicalInt parses s as an integer only when it has no sign prefix,
+// leading zeros or spaces, as redis does for values stored in strings.
+func parseCanonicalInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// stringValue returns a copy of the value of a string resource.
+func stringValue(res *Resource) (string, error) {
+	switch v := res.value.(type) {
+	case []byte:
+		return string(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", errWrongType
+	}
+}
+
+// stringBytes returns the bytes of a string resource, converting integer encoded
+// values to raw ones so that callers can modify them in place.
+func stringBytes(res *Resource) ([]byte, error) {
+	switch v := res.value.(type) {
+	case []byte:
+		return v, nil
+	case int64:
+		b := strconv.AppendInt(nil, v, 10)
+		res.value = b
+		return b, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+// integerValue returns the value of a string resource parsed as an integer.
+func integerValue(res *Resource) (int64, error) {
+	switch v := res.value.(type) {
+	case int64:
+		return v, nil
+	case []byte:
+		n, ok := parseCanonicalInt(string(v))
+		if !ok {
+			return 0, errNotInteger
+		}
+		return n, nil
+	default:
+		return 0, errWrongType
+	}
+}
+
+// lookupString returns the live string resource stored at key, it fails with
+// WRONGTYPE when the key holds another type.
+func (s *server) lookupString(key string, now time.Time) (*Resource, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+	if typeName(res) != "string" {
+		return nil, false, errWrongType
+	}
+	return res, true, nil
+}
+
+type setCondition int
+
+const (
+	setAlways setCondition = iota
+	setIfMissing
+	setIfExists
+)
+
+type setOptions struct {
+	condition setCondition
+	get       bool
+	keepTTL   bool
+	expiry    *time.Time
+}
+
+// parseSetOptions parses the arguments following SET key value. Keywords are
+// matched case-insensitively and NX/XX as well as the expiry options are
+// mutually exclusive.
+func parseSetOptions(args []string, now time.Time) (setOptions, error) {
+	opts := setOptions{}
+	hasExpiry := false
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "NX", "XX":
+			if opts.condition != setAlways {
+				return opts, errSyntax
+			}
+			opts.condition = setIfMissing
+			if option == "XX" {
+				opts.condition = setIfExists
+			}
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if hasExpiry {
+				return opts, errSyntax
+			}
+			hasExpiry = true
+			opts.keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpiry || i+1 >= len(args) {
+				return opts, errSyntax
+			}
+			hasExpiry = true
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opts, errNotInteger
+			}
+			unit := time.Millisecond
+			if option == "EX" || option == "EXAT" {
+				unit = time.Second
+			}
+			deadline, ok := expiryTime(n, unit, strings.HasSuffix(option, "AT"), now)
+			if n <= 0 || !ok {
+				return opts, errInvalidExpire
+			}
+			opts.expiry = &deadline
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+func (s *server) handleSet(req *Request) ([]resp.Value, error) {
+	key, value := req.Args[0], req.Args[1]
+	now := time.Now()
+	opts, err := parseSetOptions(req.Args[2:], now)
+	if err != nil {
+		return nil, err
+	}
+	old, exists := s.InMemoryStore.lookup(key, now)
+	oldValue := resp.NullBulk()
+	if opts.get && exists {
+		str, err := stringValue(old)
+		if err != nil {
+			return nil, err
+		}
+		oldValue = resp.BulkString(str)
+	}
+	if (opts.condition == setIfMissing && exists) || (opts.condition == setIfExists && !exists) {
+		// nothing is written, GET still returns the current value
+		return reply(oldValue)
+	}
+	res := &Resource{
+		value:   encodeString(value),
+		expired: opts.expiry,
+	}
+	if opts.keepTTL && exists {
+		res.expired = old.expired
+	}
+	s.InMemoryStore.set(key, res)
+	s.addDirty(1)
+	if opts.get {
+		return reply(oldValue)
+	}
+	return reply(resp.OK)
+}
+
+func (s *server) handleGet(req *Request) ([]resp.Value, error) {
+	value, ok, err := s.getValue(req.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return reply(resp.BulkString(value))
+	}
+	return reply(resp.NullBulk())
+}
+
+func (s *server) getValue(key string) (string, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, time.Now())
+	if !ok {
+		return "", false, nil
+	}
+	valStr, err := stringValue(res)
+	if err != nil {
+		return "", false, err
+	}
+	return valStr, true, nil
+}
+
+func (s *server) handleIncr(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	incr := int64(1)
+	command := strings.ToUpper(string(req.Command))
+	if len(req.Args) > 1 {
+		n, err := strconv.ParseInt(req.Args[1], 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		incr = n
+	}
+	if strings.HasPrefix(command, "DECR") {
+		if incr == math.MinInt64 {
+			return nil, errors.New("ERR decrement would overflow")
+		}
+		incr = -incr
+	}
+
+	res, exists, err := s.lookupString(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	current := int64(0)
+	if exists {
+		current, err = integerValue(res)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+		return nil, errOverflow
+	}
+	current += incr
+	if exists {
+		// the key keeps its ttl
+		res.value = current
+	} else {
+		s.InMemoryStore.set(key, &Resource{value: current})
+	}
+	s.addDirty(1)
+	return reply(resp.Integer(current))
+}
+
+// formatFloat formats a float the way redis replies to INCRBYFLOAT, without
+// exponent and trailing zeros.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func parseFloat(arg string) (float64, error) {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+func (s *server) handleIncrByFloat(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	incr, err := parseFloat(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, exists, err := s.lookupString(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	current := float64(0)
+	if exists {
+		str, _ := stringValue(res)
+		current, err = parseFloat(str)
+		if err != nil {
+			return nil, err
+		}
+	}
+	current += incr
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return nil, errors.New("ERR increment would produce NaN or Infinity")
+	}
+	formatted := formatFloat(current)
+	if exists {
+		res.value = encodeString(formatted)
+	} else {
+		s.InMemoryStore.set(key, &Resource{value: encodeString(formatted)})
+	}
+	s.addDirty(1)
+	return reply(resp.BulkString(formatted))
+}
+
+func (s *server) handleAppend(req *Request) ([]resp.Value, error) {
+	key, suffix := req.Args[0], req.Args[1]
+	res, exists, err := s.lookupString(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		s.InMemoryStore.set(key, &Resource{value: encodeString(suffix)})
+		s.addDirty(1)
+		return reply(resp.Integer(int64(len(suffix))))
+	}
+	b, _ := stringBytes(res)
+	if len(b)+len(suffix) > maxStringSize {
+		return nil, errStringTooLarge
+	}
+	b = append(b, suffix...)
+	res.value = b
+	s.addDirty(1)
+	return reply(resp.Integer(int64(len(b))))
+}
+
+func (s *server) handleStrlen(req *Request) ([]resp.Value, error) {
+	res, exists, err := s.lookupString(req.Args[0], time.Now())
+	if err != nil || !exists {
+		return reply(resp.Integer(0))
+	}
+	str, _ := stringValue(res)
+	return reply(resp.Integer(int64(len(str))))
+}
+
+// normalizeRange converts inclusive start and end indexes that may count from the
+// end into positions within a sequence of length n. It reports false when the
+// range is empty.
+func normalizeRange(start, end int64, n int64) (int64, int64, bool) {
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = n + end
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end || start >= n {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func (s *server) handleGetRange(req *Request) ([]resp.Value, error) {
+	start, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	end, err := strconv.ParseInt(req.Args[2], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, exists, err := s.lookupString(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.BulkString(""))
+	}
+	str, _ := stringValue(res)
+	// both indexes negative and start after end is an empty range even once normalized
+	if start < 0 && end < 0 && start > end {
+		return reply(resp.BulkString(""))
+	}
+	from, to, ok := normalizeRange(start, end, int64(len(str)))
+	if !ok {
+		return reply(resp.BulkString(""))
+	}
+	return reply(resp.BulkString(str[from : to+1]))
+}
+
+func (s *server) handleSetRange(req *Request) ([]resp.Value, error) {
+	key, value := req.Args[0], req.Args[2]
+	offset, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if offset < 0 {
+		return nil, errOffsetRange
+	}
+	res, exists, err := s.lookupString(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > 0 && offset+int64(len(value)) > maxStringSize {
+		return nil, errStringTooLarge
+	}
+	var b []byte
+	if exists {
+		b, _ = stringBytes(res)
+	}
+	if len(value) == 0 {
+		// nothing to write, an empty key is not created
+		return reply(resp.Integer(int64(len(b))))
+	}
+	if end := int(offset) + len(value); end > len(b) {
+		// the gap is padded with zero bytes
+		b = append(b, make([]byte, end-len(b))...)
+	}
+	copy(b[offset:], value)
+	if exists {
+		res.value = b
+	} else {
+		s.InMemoryStore.set(key, &Resource{value: b})
+	}
+	s.addDirty(1)
+	return reply(resp.Integer(int64(len(b))))
+}
+
+func (s *server) handleGetSet(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	res, exists, err := s.lookupString(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	old := resp.NullBulk()
+	if exists {
+		str, _ := stringValue(res)
+		old = resp.BulkString(str)
+	}
+	// like SET the key loses its ttl
+	s.InMemoryStore.set(key, &Resource{value: encodeString(req.Args[1])})
+	s.addDirty(1)
+	return reply(old)
+}
+
+func (s *server) handleGetDel(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	res, exists, err := s.lookupString(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.NullBulk())
+	}
+	str, _ := stringValue(res)
+	s.InMemoryStore.delete(key)
+	s.addDirty(1)
+	return reply(resp.BulkString(str))
+}
+
+func (s *server) handleGetEx(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	now := time.Now()
+	var deadline *time.Time
+	persist := false
+	args := req.Args[1:]
+	if len(args) > 0 {
+		option := strings.ToUpper(args[0])
+		switch {
+		case option == "PERSIST" && len(args) == 1:
+			persist = true
+		case (option == "EX" || option == "PX" || option == "EXAT" || option == "PXAT") && len(args) == 2:
+			opts, err := parseSetOptions(args, now)
+			if err != nil {
+				if errors.Is(err, errInvalidExpire) {
+					return nil, errors.New("ERR invalid expire time in 'getex' command")
+				}
+				return nil, err
+			}
+			deadline = opts.expiry
+		default:
+			return nil, errSyntax
+		}
+	}
+	res, exists, err := s.lookupString(key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.NullBulk())
+	}
+	str, _ := stringValue(res)
+	switch {
+	case persist && res.expired != nil:
+		s.InMemoryStore.setExpiry(key, res, nil)
+		s.addDirty(1)
+	case deadline != nil && s.InMemoryStore.alreadyExpired(*deadline, now):
+		s.InMemoryStore.delete(key)
+		s.addDirty(1)
+	case deadline != nil:
+		s.InMemoryStore.setExpiry(key, res, deadline)
+		s.addDirty(1)
+	}
+	return reply(resp.BulkString(str))
+}
+
+func (s *server) handleMSet(req *Request) ([]resp.Value, error) {
+	onlyIfMissing := strings.EqualFold(string(req.Command), "MSETNX")
+	if len(req.Args)%2 != 0 {
+		return nil, errWrongArgs(string(req.Command))
+	}
+	now := time.Now()
+	if onlyIfMissing {
+		for i := 0; i < len(req.Args); i += 2 {
+			if _, exists := s.InMemoryStore.lookup(req.Args[i], now); exists {
+				return reply(resp.Integer(0))
+			}
+		}
+	}
+	for i := 0; i < len(req.Args); i += 2 {
+		s.InMemoryStore.set(req.Args[i], &Resource{value: encodeString(req.Args[i+1])})
+	}
+	s.addDirty(len(req.Args) / 2)
+	if onlyIfMissing {
+		return reply(resp.Integer(1))
+	}
+	return reply(resp.OK)
+}
+
+func (s *server) handleMGet(req *Request) ([]resp.Value, error) {
+	now := time.Now()
+	values := make([]resp.Value, len(req.Args))
+	for i, key := range req.Args {
+		values[i] = resp.NullBulk()
+		// keys holding other types are reported as missing
+		if res, exists, err := s.lookupString(key, now); err == nil && exists {
+			str, _ := stringValue(res)
+			values[i] = resp.BulkString(str)
+		}
+	}
+	return reply(resp.Array(values...))
+}
+
+func (s *server) handleSetNX(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	if _, exists := s.InMemoryStore.lookup(key, time.Now()); exists {
+		return reply(resp.Integer(0))
+	}
+	s.InMemoryStore.set(key, &Resource{value: encodeString(req.Args[1])})
+	s.addDirty(1)
+	return reply(resp.Integer(1))
+}
+
+func (s *server) handleSetEx(req *Request) ([]resp.Value, error) {
+	command := strings.ToLower(string(req.Command))
+	key, value := req.Args[0], req.Args[2]
+	n, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	unit := time.Second
+	if command == "psetex" {
+		unit = time.Millisecond
+	}
+	deadline, ok := expiryTime(n, unit, false, time.Now())
+	if n <= 0 || !ok {
+		return nil, errors.New("ERR invalid expire time in '" + command + "' command")
+	}
+	s.InMemoryStore.set(key, &Resource{
+		value:   encodeString(value),
+		expired: &deadline,
+	})
+	s.addDirty(1)
+	return reply(resp.OK)
+}
+
+// maxLcsCells bounds the dynamic programming table of LCS, which uses 4 bytes
+// per cell.
+const maxLcsCells = maxStringSize / 4
+
+func (s *server) handleLcs(req *Request) ([]resp.Value, error) {
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := int64(0)
+	args := req.Args[2:]
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	if getLen && getIdx {
+		return nil, errors.New("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	now := time.Now()
+	values := [2]string{}
+	for i, key := range req.Args[:2] {
+		res, exists, err := s.lookupString(key, now)
+		if err != nil {
+			return nil, errors.New("ERR The specified keys must contain string values")
+		}
+		if exists {
+			values[i], _ = stringValue(res)
+		}
+	}
+	a, b := values[0], values[1]
+	alen, blen := len(a), len(b)
+	if (alen+1)*(blen+1) > maxLcsCells {
+		return nil, errors.New("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+
+	// table[i][j] is the length of the LCS of a[:i] and b[:j]
+	table := make([]uint32, (alen+1)*(blen+1))
+	at := func(i, j int) uint32 { return table[j*(alen+1)+i] }
+	for i := 1; i <= alen; i++ {
+		for j := 1; j <= blen; j++ {
+			if a[i-1] == b[j-1] {
+				table[j*(alen+1)+i] = at(i-1, j-1) + 1
+			} else {
+				table[j*(alen+1)+i] = max(at(i-1, j), at(i, j-1))
+			}
+		}
+	}
+	idx := at(alen, blen)
+	if getLen {
+		return reply(resp.Integer(int64(idx)))
+	}
+
+	// walk the table back from the end, collecting the common string and the
+	// ranges of contiguous matches
+	result := make([]byte, idx)
+	matches := []resp.Value{}
+	i, j := alen, blen
+	aStart, aEnd, bStart, bEnd := alen, 0, 0, 0
+	for i > 0 && j > 0 {
+		emitRange := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == alen {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				// the range extends backward since it is contiguous
+				aStart--
+				bStart--
+			} else {
+				emitRange = true
+			}
+			if aStart == 0 || bStart == 0 {
+				emitRange = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if at(i-1, j) > at(i, j-1) {
+				i--
+			} else {
+				j--
+			}
+			if aStart != alen {
+				emitRange = true
+			}
+		}
+		if emitRange {
+			matchLen := int64(aEnd - aStart + 1)
+			if getIdx && (minMatchLen == 0 || matchLen >= minMatchLen) {
+				match := []resp.Value{
+					resp.Array(resp.Integer(int64(aStart)), resp.Integer(int64(aEnd))),
+					resp.Array(resp.Integer(int64(bStart)), resp.Integer(int64(bEnd))),
+				}
+				if withMatchLen {
+					match = append(match, resp.Integer(matchLen))
+				}
+				matches = append(matches, resp.Array(match...))
+			}
+			aStart = alen
+		}
+	}
+
+	if getIdx {
+		return reply(resp.Array(
+			resp.BulkString("matches"), resp.Array(matches...),
+			resp.BulkString("len"), resp.Integer(int64(at(alen, blen))),
+		))
+	}
+	return reply(resp.Bulk(result))
+}
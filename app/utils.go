@@ -1,21 +1,44 @@
 package app
 
 import (
-	"fmt"
+	"math"
 	"os"
-	"strings"
 	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
 )
 
 func expired(res *Resource, currentTime time.Time) bool {
 	if res.expired == nil {
 		return false
 	}
-	expiredTs := *res.expired
-	if expiredTs.Before(currentTime) {
-		return true
+	return deadlinePassed(*res.expired, currentTime)
+}
+
+// deadlinePassed is the expiry check shared by keys and hash fields.
+func deadlinePassed(deadline time.Time, currentTime time.Time) bool {
+	return deadline.Before(currentTime)
+}
+
+// expiryTime converts an expire argument to a deadline. Relative values are added
+// to now, absolute ones are unix timestamps. It reports false when the deadline
+// does not fit in an int64 of milliseconds.
+func expiryTime(n int64, unit time.Duration, absolute bool, now time.Time) (time.Time, bool) {
+	ms := n
+	if unit == time.Second {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return time.Time{}, false
+		}
+		ms = n * 1000
+	}
+	if !absolute {
+		base := now.UnixMilli()
+		if ms > math.MaxInt64-base || ms < math.MinInt64+base {
+			return time.Time{}, false
+		}
+		ms += base
 	}
-	return false
+	return time.UnixMilli(ms), true
 }
 
 func fileExists(filename string) bool {
@@ -27,72 +50,10 @@ func fileExists(filename string) bool {
 	return err == nil && !info.IsDir()
 }
 
-func formatMapKeys(m map[string]*Resource) string {
-	var builder strings.Builder
-
-	// Start with the number of keys
-	builder.WriteString(fmt.Sprintf("*%d\r\n", len(m)))
-
-	// Loop over the map to add each key
-	for key := range m {
-		keyLen := len(key)
-		builder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", keyLen, key))
-	}
-
-	return builder.String()
-}
-
-func formatBulkString(data []string) string {
-	// Join the slice into a single string separated by newlines
-	joined := strings.Join(data, "\n")
-
-	// Calculate the byte length of the joined string
-	length := len(joined)
-
-	var builder strings.Builder
-
-	// Start with the number of keys
-	builder.WriteString(fmt.Sprintf("$%d\r\n", length))
-	builder.WriteString(joined)
-	builder.WriteString("\r\n")
-
-	return builder.String()
-}
-
-func buildRespArray(req *Request) string {
+func buildRespArray(req *Request) resp.Value {
 	data := []string{string(req.Command)}
 
 	data = append(data, req.Args...)
 
-	var builder strings.Builder
-
-	// Start with the number of keys
-	builder.WriteString(fmt.Sprintf("*%d\r\n", len(data)))
-
-	// Loop over the map to add each key
-	for _, key := range data {
-		keyLen := len(key)
-		builder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", keyLen, key))
-	}
-
-	return builder.String()
-}
-
-func simpleRespString(data []string) string {
-	var builder strings.Builder
-
-	builder.WriteString(fmt.Sprintf("+%s", data[0]))
-	if len(data) > 1 {
-		for _, key := range data[1:] {
-			builder.WriteString(fmt.Sprintf(" %s", key))
-		}
-	}
-	builder.WriteString("\r\n")
-
-	return builder.String()
-}
-
-func rdbContentResp(rdbBytes []byte) string {
-	rdbString := string(rdbBytes)
-	return fmt.Sprintf("$%d\r\n%s", len(rdbString), rdbString)
+	return resp.BulkStrings(data)
 }
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+	errMinMaxNotLex   = errors.New("ERR min or max not valid string range item")
+)
+
+// zset is the sorted set value: the dict maps members to their score and the
+// skiplist keeps them ordered by score, then member.
+type zset struct {
+	dict map[string]float64
+	zsl  *zskiplist
+}
+
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+func newZSet() *zset {
+	return &zset{dict: map[string]float64{}, zsl: newZSkiplist()}
+}
+
+func (z *zset) Len() int {
+	return len(z.dict)
+}
+
+func (z *zset) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Set stores the score of member and reports whether the member is new.
+func (z *zset) Set(member string, score float64) bool {
+	current, exists := z.dict[member]
+	if !exists {
+		z.zsl.insert(score, member)
+		z.dict[member] = score
+		return true
+	}
+	if current != score {
+		z.zsl.updateScore(current, member, score)
+		z.dict[member] = score
+	}
+	return false
+}
+
+// clone returns a copy of the sorted set sharing no storage with it.
+func (z *zset) clone() *zset {
+	c := newZSet()
+	for _, e := range z.RangeByRank(0, z.Len()-1, false) {
+		c.Set(e.member, e.score)
+	}
+	return c
+}
+
+func (z *zset) Remove(member string) bool {
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// Rank returns the 0-based rank of member, counted from the highest score when
+// reverse is set.
+func (z *zset) Rank(member string, reverse bool) (int, bool) {
+	score, exists := z.dict[member]
+	if !exists {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// walk collects up to limit entries starting from node, forward or backward. A
+// negative limit collects everything up to the end of the list or until keep
+// returns false.
+func walk(node *zskiplistNode, reverse bool, limit int, keep func(*zskiplistNode) bool) []zsetEntry {
+	entries := []zsetEntry{}
+	for node != nil && limit != 0 && keep(node) {
+		entries = append(entries, zsetEntry{node.member, node.score})
+		limit--
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return entries
+}
+
+// skip moves offset nodes away from node.
+func skip(node *zskiplistNode, reverse bool, offset int) *zskiplistNode {
+	for ; node != nil && offset > 0; offset-- {
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return node
+}
+
+// RangeByRank returns the entries between the 0-based ranks start and end
+// inclusive, which must be in range.
+func (z *zset) RangeByRank(start, end int, reverse bool) []zsetEntry {
+	rank := start + 1
+	if reverse {
+		rank = z.Len() - start
+	}
+	all := func(*zskiplistNode) bool { return true }
+	return walk(z.zsl.byRank(rank), reverse, end-start+1, all)
+}
+
+// scoreRange is a score interval, with optionally excluded bounds.
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r scoreRange) aboveMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r scoreRange) belowMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+func parseScoreBound(arg string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, err := strconv.ParseFloat(arg, 64)
+	if (err != nil && !errors.Is(err, strconv.ErrRange)) || math.IsNaN(score) {
+		return 0, false, errMinMaxNotFloat
+	}
+	return score, exclusive, nil
+}
+
+func parseScoreRange(min, max string) (scoreRange, error) {
+	var r scoreRange
+	var err error
+	if r.min, r.minex, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.max, r.maxex, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// RangeByScore returns the entries whose score is in r, skipping offset of them
+// and returning at most limit when it isn't negative.
+func (z *zset) RangeByScore(r scoreRange, reverse bool, offset, limit int) []zsetEntry {
+	inRange := func(n *zskiplistNode) bool { return r.aboveMin(n.score) && r.belowMax(n.score) }
+	var first *zskiplistNode
+	if reverse {
+		first = z.zsl.lastInRange(func(n *zskiplistNode) bool { return !r.belowMax(n.score) }, inRange)
+	} else {
+		first = z.zsl.firstInRange(func(n *zskiplistNode) bool { return !r.aboveMin(n.score) }, inRange)
+	}
+	return walk(skip(first, reverse, offset), reverse, limit, inRange)
+}
+
+// CountInRange returns the number of members with a score in r, computed from
+// the ranks of the first and last of them.
+func (z *zset) CountInRange(r scoreRange) int {
+	inRange := func(n *zskiplistNode) bool { return r.aboveMin(n.score) && r.belowMax(n.score) }
+	first := z.zsl.firstInRange(func(n *zskiplistNode) bool { return !r.aboveMin(n.score) }, inRange)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInRange(func(n *zskiplistNode) bool { return !r.belowMax(n.score) }, inRange)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// lexBound is a bound of a lexicographic range, "-" and "+" are the infinite
+// bounds.
+type lexBound struct {
+	value     string
+	exclusive bool
+	// inf is -1 for "-", 1 for "+" and 0 otherwise
+	inf int
+}
+
+type lexRange struct {
+	min, max lexBound
+}
+
+func parseLexBound(arg string) (lexBound, error) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, nil
+	case arg == "+":
+		return lexBound{inf: 1}, nil
+	case strings.HasPrefix(arg, "("):
+		return lexBound{value: arg[1:], exclusive: true}, nil
+	case strings.HasPrefix(arg, "["):
+		return lexBound{value: arg[1:]}, nil
+	default:
+		return lexBound{}, errMinMaxNotLex
+	}
+}
+
+func parseLexRange(min, max string) (lexRange, error) {
+	var r lexRange
+	var err error
+	if r.min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	if r.max, err = parseLexBound(max); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func (r lexRange) aboveMin(member string) bool {
+	switch {
+	case r.min.inf != 0:
+		return r.min.inf < 0
+	case r.min.exclusive:
+		return member > r.min.value
+	default:
+		return member >= r.min.value
+	}
+}
+
+func (r lexRange) belowMax(member string) bool {
+	switch {
+	case r.max.inf != 0:
+		return r.max.inf > 0
+	case r.max.exclusive:
+		return member < r.max.value
+	default:
+		return member <= r.max.value
+	}
+}
+
+// RangeByLex is RangeByScore for lexicographic ranges, meant for sorted sets
+// whose members all have the same score.
+func (z *zset) RangeByLex(r lexRange, reverse bool, offset, limit int) []zsetEntry {
+	inRange := func(n *zskiplistNode) bool { return r.aboveMin(n.member) && r.belowMax(n.member) }
+	var first *zskiplistNode
+	if reverse {
+		first = z.zsl.lastInRange(func(n *zskiplistNode) bool { return !r.belowMax(n.member) }, inRange)
+	} else {
+		first = z.zsl.firstInRange(func(n *zskiplistNode) bool { return !r.aboveMin(n.member) }, inRange)
+	}
+	return walk(skip(first, reverse, offset), reverse, limit, inRange)
+}
+
+// formatScore formats a score the way redis replies doubles: integers and
+// common magnitudes in plain notation, others with an exponent.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	abs := math.Abs(score)
+	if abs != 0 && (abs < 1e-4 || abs >= 1e17) {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
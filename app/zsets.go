@@ -0,0 +1,916 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vergangenheit/codecrafters-redis-go/resp"
+)
+
+var errScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+func init() {
+	registerCommands(
+		&commandSpec{
+			name:     "ZADD",
+			group:    "sorted-set",
+			summary:  "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZAdd,
+		},
+		&commandSpec{
+			name:     "ZINCRBY",
+			group:    "sorted-set",
+			summary:  "Increments the score of a member in a sorted set.",
+			arity:    4,
+			flags:    flagWrite | flagDenyOOM | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZIncrBy,
+		},
+		&commandSpec{
+			name:     "ZREM",
+			group:    "sorted-set",
+			summary:  "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.",
+			arity:    -3,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZRem,
+		},
+		&commandSpec{
+			name:     "ZSCORE",
+			group:    "sorted-set",
+			summary:  "Returns the score of a member in a sorted set.",
+			arity:    3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZScore,
+		},
+		&commandSpec{
+			name:     "ZMSCORE",
+			group:    "sorted-set",
+			summary:  "Returns the score of one or more members in a sorted set.",
+			arity:    -3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZScore,
+		},
+		&commandSpec{
+			name:     "ZCARD",
+			group:    "sorted-set",
+			summary:  "Returns the number of members in a sorted set.",
+			arity:    2,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZCard,
+		},
+		&commandSpec{
+			name:     "ZCOUNT",
+			group:    "sorted-set",
+			summary:  "Returns the count of members in a sorted set that have scores within a range.",
+			arity:    4,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZCount,
+		},
+		&commandSpec{
+			name:     "ZRANK",
+			group:    "sorted-set",
+			summary:  "Returns the index of a member in a sorted set ordered by ascending scores.",
+			arity:    -3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZRank,
+		},
+		&commandSpec{
+			name:     "ZREVRANK",
+			group:    "sorted-set",
+			summary:  "Returns the index of a member in a sorted set ordered by descending scores.",
+			arity:    -3,
+			flags:    flagReadonly | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZRank,
+		},
+		&commandSpec{
+			name:     "ZRANGE",
+			group:    "sorted-set",
+			summary:  "Returns members in a sorted set within a range of indexes.",
+			arity:    -4,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZRange,
+		},
+		&commandSpec{
+			name:     "ZRANGESTORE",
+			group:    "sorted-set",
+			summary:  "Stores a range of members from sorted set in a key.",
+			arity:    -5,
+			flags:    flagWrite | flagDenyOOM,
+			firstKey: 1, lastKey: 2, keyStep: 1,
+			handler: (*server).handleZRange,
+		},
+		&commandSpec{
+			name:     "ZPOPMIN",
+			group:    "sorted-set",
+			summary:  "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZPop,
+		},
+		&commandSpec{
+			name:     "ZPOPMAX",
+			group:    "sorted-set",
+			summary:  "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+			arity:    -2,
+			flags:    flagWrite | flagFast,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZPop,
+		},
+		&commandSpec{
+			name:     "BZPOPMIN",
+			group:    "sorted-set",
+			summary:  "Removes and returns the member with the lowest score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.",
+			arity:    -3,
+			flags:    flagWrite | flagFast | flagBlocking,
+			firstKey: 1, lastKey: -2, keyStep: 1,
+			handler: (*server).handleBlockingZPop,
+		},
+		&commandSpec{
+			name:     "BZPOPMAX",
+			group:    "sorted-set",
+			summary:  "Removes and returns the member with the highest score from one or more sorted sets. Blocks until a member available otherwise.  Deletes the sorted set if the last element was popped.",
+			arity:    -3,
+			flags:    flagWrite | flagFast | flagBlocking,
+			firstKey: 1, lastKey: -2, keyStep: 1,
+			handler: (*server).handleBlockingZPop,
+		},
+		&commandSpec{
+			name:     "ZUNIONSTORE",
+			group:    "sorted-set",
+			summary:  "Stores the union of multiple sorted sets in a key.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM,
+			keysFunc: zstoreKeys,
+			handler:  (*server).handleZStore,
+		},
+		&commandSpec{
+			name:     "ZINTERSTORE",
+			group:    "sorted-set",
+			summary:  "Stores the intersect of multiple sorted sets in a key.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM,
+			keysFunc: zstoreKeys,
+			handler:  (*server).handleZStore,
+		},
+		&commandSpec{
+			name:     "ZDIFFSTORE",
+			group:    "sorted-set",
+			summary:  "Stores the difference of multiple sorted sets in a key.",
+			arity:    -4,
+			flags:    flagWrite | flagDenyOOM,
+			keysFunc: zstoreKeys,
+			handler:  (*server).handleZStore,
+		},
+		&commandSpec{
+			name:     "ZRANDMEMBER",
+			group:    "sorted-set",
+			summary:  "Returns one or more random members from a sorted set.",
+			arity:    -2,
+			flags:    flagReadonly,
+			firstKey: 1, lastKey: 1, keyStep: 1,
+			handler: (*server).handleZRandMember,
+		},
+	)
+}
+
+// zstoreKeys extracts the destination and the source keys of ZUNIONSTORE and
+// friends.
+func zstoreKeys(args []string) []string {
+	return append([]string{args[0]}, numkeysKeys(1)(args)...)
+}
+
+// lookupZSet returns the live sorted set stored at key, it fails with WRONGTYPE
+// when the key holds another type.
+func (s *server) lookupZSet(key string, now time.Time) (*zset, bool, error) {
+	res, ok := s.InMemoryStore.lookup(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+	z, isZSet := res.value.(*zset)
+	if !isZSet {
+		return nil, false, errWrongType
+	}
+	return z, true, nil
+}
+
+// storeZSet replaces whatever dst holds with z, or deletes it when z is empty.
+func (s *server) storeZSet(dst string, z *zset) {
+	if s.InMemoryStore.delete(dst) || z.Len() > 0 {
+		s.addDirty(1)
+	}
+	if z.Len() > 0 {
+		s.InMemoryStore.set(dst, &Resource{value: z})
+		s.signalKeyReady(dst)
+	}
+}
+
+// scoreReplies flattens entries to member score pairs, or members only.
+func scoreReplies(entries []zsetEntry, withScores bool) resp.Value {
+	items := make([]string, 0, len(entries)*2)
+	for _, e := range entries {
+		items = append(items, e.member)
+		if withScores {
+			items = append(items, formatScore(e.score))
+		}
+	}
+	return resp.BulkStrings(items)
+}
+
+func parseScore(arg string) (float64, error) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if (err != nil && !errors.Is(err, strconv.ErrRange)) || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// zaddOptions are the flags of ZADD, given before the score member pairs.
+type zaddOptions struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+func parseZAddOptions(args []string) (zaddOptions, []string, error) {
+	var opts zaddOptions
+	i := 0
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GT":
+			opts.gt = true
+		case "LT":
+			opts.lt = true
+		case "CH":
+			opts.ch = true
+		case "INCR":
+			opts.incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return opts, nil, errSyntax
+	}
+	if opts.nx && opts.xx {
+		return opts, nil, errors.New("ERR XX and NX options at the same time are not compatible")
+	}
+	if (opts.gt && opts.nx) || (opts.lt && opts.nx) || (opts.gt && opts.lt) {
+		return opts, nil, errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if opts.incr && len(pairs) > 2 {
+		return opts, nil, errors.New("ERR INCR option supports a single increment-element pair")
+	}
+	return opts, pairs, nil
+}
+
+// outcomes of a ZADD score member pair
+const (
+	zaddUnchanged = iota
+	zaddAdded
+	zaddUpdated
+	// refused by NX, XX, GT or LT
+	zaddRefused
+)
+
+// zadd applies one score member pair and returns the resulting score.
+func zadd(z *zset, member string, score float64, opts zaddOptions) (float64, int, error) {
+	current, exists := z.Score(member)
+	if !exists {
+		if opts.xx {
+			return 0, zaddRefused, nil
+		}
+		z.Set(member, score)
+		return score, zaddAdded, nil
+	}
+	if opts.nx {
+		return current, zaddRefused, nil
+	}
+	if opts.incr {
+		score += current
+		if math.IsNaN(score) {
+			return 0, zaddRefused, errScoreNaN
+		}
+	}
+	if (opts.gt && score <= current) || (opts.lt && score >= current) {
+		return current, zaddRefused, nil
+	}
+	if score == current {
+		return score, zaddUnchanged, nil
+	}
+	z.Set(member, score)
+	return score, zaddUpdated, nil
+}
+
+func (s *server) handleZAdd(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	opts, pairs, err := parseZAddOptions(req.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]float64, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseScore(pairs[i])
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+
+	z, exists, err := s.lookupZSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if opts.xx {
+			if opts.incr {
+				return reply(resp.NullBulk())
+			}
+			return reply(resp.Integer(0))
+		}
+		z = newZSet()
+		s.InMemoryStore.set(key, &Resource{value: z})
+	}
+	added, updated := 0, 0
+	score, outcome := 0.0, zaddUnchanged
+	for i, increment := range scores {
+		score, outcome, err = zadd(z, pairs[2*i+1], increment, opts)
+		if err != nil {
+			break
+		}
+		switch outcome {
+		case zaddAdded:
+			added++
+		case zaddUpdated:
+			updated++
+		}
+	}
+	if z.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	} else if added > 0 || updated > 0 {
+		s.signalKeyReady(key)
+	}
+	s.addDirty(added + updated)
+	switch {
+	case err != nil:
+		return nil, err
+	case opts.incr && outcome == zaddRefused:
+		return reply(resp.NullBulk())
+	case opts.incr:
+		return reply(resp.BulkString(formatScore(score)))
+	case opts.ch:
+		return reply(resp.Integer(int64(added + updated)))
+	default:
+		return reply(resp.Integer(int64(added)))
+	}
+}
+
+func (s *server) handleZIncrBy(req *Request) ([]resp.Value, error) {
+	key, member := req.Args[0], req.Args[2]
+	increment, err := parseScore(req.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	z, exists, err := s.lookupZSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	current := 0.0
+	if exists {
+		current, _ = z.Score(member)
+	}
+	score := current + increment
+	if math.IsNaN(score) {
+		return nil, errScoreNaN
+	}
+	if !exists {
+		z = newZSet()
+		s.InMemoryStore.set(key, &Resource{value: z})
+	}
+	z.Set(member, score)
+	s.signalKeyReady(key)
+	s.addDirty(1)
+	return reply(resp.BulkString(formatScore(score)))
+}
+
+func (s *server) handleZRem(req *Request) ([]resp.Value, error) {
+	key := req.Args[0]
+	z, exists, err := s.lookupZSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	removed := 0
+	for _, member := range req.Args[1:] {
+		if z.Remove(member) {
+			removed++
+		}
+	}
+	if z.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	}
+	s.addDirty(removed)
+	return reply(resp.Integer(int64(removed)))
+}
+
+// handleZScore serves ZSCORE and ZMSCORE.
+func (s *server) handleZScore(req *Request) ([]resp.Value, error) {
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]resp.Value, 0, len(req.Args)-1)
+	for _, member := range req.Args[1:] {
+		score, ok := 0.0, false
+		if exists {
+			score, ok = z.Score(member)
+		}
+		if ok {
+			scores = append(scores, resp.BulkString(formatScore(score)))
+		} else {
+			scores = append(scores, resp.NullBulk())
+		}
+	}
+	if strings.EqualFold(string(req.Command), "ZSCORE") {
+		return reply(scores[0])
+	}
+	return reply(resp.Array(scores...))
+}
+
+func (s *server) handleZCard(req *Request) ([]resp.Value, error) {
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(int64(z.Len())))
+}
+
+func (s *server) handleZCount(req *Request) ([]resp.Value, error) {
+	r, err := parseScoreRange(req.Args[1], req.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Integer(0))
+	}
+	return reply(resp.Integer(int64(z.CountInRange(r))))
+}
+
+// handleZRank serves ZRANK and ZREVRANK.
+func (s *server) handleZRank(req *Request) ([]resp.Value, error) {
+	reverse := strings.EqualFold(string(req.Command), "ZREVRANK")
+	if len(req.Args) > 3 || (len(req.Args) == 3 && !strings.EqualFold(req.Args[2], "WITHSCORE")) {
+		return nil, errSyntax
+	}
+	withScore := len(req.Args) == 3
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rank, found := 0, false
+	if exists {
+		rank, found = z.Rank(req.Args[1], reverse)
+	}
+	switch {
+	case !found && withScore:
+		return reply(resp.NullArray())
+	case !found:
+		return reply(resp.NullBulk())
+	case withScore:
+		score, _ := z.Score(req.Args[1])
+		return reply(resp.Array(resp.Integer(int64(rank)), resp.BulkString(formatScore(score))))
+	default:
+		return reply(resp.Integer(int64(rank)))
+	}
+}
+
+// zrangeQuery is the parsed form of the ZRANGE arguments.
+type zrangeQuery struct {
+	min, max   string
+	byScore    bool
+	byLex      bool
+	reverse    bool
+	withScores bool
+	offset     int
+	limit      int
+}
+
+// parseZRange parses min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+// [WITHSCORES], with REV the range bounds are given from max to min.
+func parseZRange(args []string, store bool) (zrangeQuery, error) {
+	q := zrangeQuery{min: args[0], max: args[1], limit: -1}
+	hasLimit := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			q.byScore = true
+		case "BYLEX":
+			q.byLex = true
+		case "REV":
+			q.reverse = true
+		case "WITHSCORES":
+			if store {
+				return q, errSyntax
+			}
+			q.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return q, errSyntax
+			}
+			offset, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return q, errNotInteger
+			}
+			limit, err := strconv.Atoi(args[i+2])
+			if err != nil {
+				return q, errNotInteger
+			}
+			q.offset, q.limit, hasLimit = offset, limit, true
+			i += 2
+		default:
+			return q, errSyntax
+		}
+	}
+	if q.byScore && q.byLex {
+		return q, errSyntax
+	}
+	if hasLimit && !q.byScore && !q.byLex {
+		return q, errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if q.withScores && q.byLex {
+		return q, errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if q.reverse && (q.byScore || q.byLex) {
+		q.min, q.max = q.max, q.min
+	}
+	return q, nil
+}
+
+// run returns the entries of z selected by the query.
+func (q zrangeQuery) run(z *zset) ([]zsetEntry, error) {
+	switch {
+	case q.byScore:
+		r, err := parseScoreRange(q.min, q.max)
+		if err != nil || z == nil || q.offset < 0 {
+			return nil, err
+		}
+		return z.RangeByScore(r, q.reverse, q.offset, q.limit), nil
+	case q.byLex:
+		r, err := parseLexRange(q.min, q.max)
+		if err != nil || z == nil || q.offset < 0 {
+			return nil, err
+		}
+		return z.RangeByLex(r, q.reverse, q.offset, q.limit), nil
+	default:
+		start, err := strconv.ParseInt(q.min, 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		end, err := strconv.ParseInt(q.max, 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+		if z == nil {
+			return nil, nil
+		}
+		from, to, ok := normalizeRange(start, end, int64(z.Len()))
+		if !ok {
+			return nil, nil
+		}
+		return z.RangeByRank(int(from), int(to), q.reverse), nil
+	}
+}
+
+// handleZRange serves ZRANGE and ZRANGESTORE.
+func (s *server) handleZRange(req *Request) ([]resp.Value, error) {
+	store := strings.EqualFold(string(req.Command), "ZRANGESTORE")
+	args := req.Args
+	if store {
+		args = args[1:]
+	}
+	q, err := parseZRange(args[1:], store)
+	if err != nil {
+		return nil, err
+	}
+	z, _, err := s.lookupZSet(args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	entries, err := q.run(z)
+	if err != nil {
+		return nil, err
+	}
+	if !store {
+		return reply(scoreReplies(entries, q.withScores))
+	}
+	result := newZSet()
+	for _, e := range entries {
+		result.Set(e.member, e.score)
+	}
+	s.storeZSet(req.Args[0], result)
+	return reply(resp.Integer(int64(result.Len())))
+}
+
+// popEntries removes up to count of the lowest or highest scoring members.
+func popEntries(z *zset, max bool, count int) []zsetEntry {
+	var entries []zsetEntry
+	if max {
+		entries = walk(z.zsl.tail, true, count, func(*zskiplistNode) bool { return true })
+	} else {
+		entries = walk(z.zsl.header.level[0].forward, false, count, func(*zskiplistNode) bool { return true })
+	}
+	for _, e := range entries {
+		z.Remove(e.member)
+	}
+	return entries
+}
+
+// handleZPop serves ZPOPMIN and ZPOPMAX.
+func (s *server) handleZPop(req *Request) ([]resp.Value, error) {
+	max := strings.EqualFold(string(req.Command), "ZPOPMAX")
+	key := req.Args[0]
+	if len(req.Args) > 2 {
+		return nil, errSyntax
+	}
+	count := 1
+	if len(req.Args) == 2 {
+		n, err := strconv.Atoi(req.Args[1])
+		if err != nil || n < 0 {
+			return nil, errNotPositive
+		}
+		count = n
+	}
+	z, exists, err := s.lookupZSet(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return reply(resp.Array())
+	}
+	entries := popEntries(z, max, count)
+	if z.Len() == 0 {
+		s.InMemoryStore.delete(key)
+	}
+	s.addDirty(len(entries))
+	return reply(scoreReplies(entries, true))
+}
+
+// handleBlockingZPop serves BZPOPMIN and BZPOPMAX.
+func (s *server) handleBlockingZPop(req *Request) ([]resp.Value, error) {
+	max := strings.EqualFold(string(req.Command), "BZPOPMAX")
+	keys := req.Args[:len(req.Args)-1]
+	timeout, err := parseTimeout(req.Args[len(req.Args)-1])
+	if err != nil {
+		return nil, err
+	}
+	serve := func(key string) (resp.Value, bool, error) {
+		z, exists, err := s.lookupZSet(key, time.Now())
+		if err != nil || !exists {
+			return resp.Value{}, false, err
+		}
+		e := popEntries(z, max, 1)[0]
+		if z.Len() == 0 {
+			s.InMemoryStore.delete(key)
+		}
+		s.addDirty(1)
+		return resp.BulkStrings([]string{key, e.member, formatScore(e.score)}), true, nil
+	}
+	for _, key := range keys {
+		value, ok, err := serve(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return reply(value)
+		}
+	}
+	return s.block(req, &blockedClient{
+		valueType:    "zset",
+		keys:         keys,
+		serve:        serve,
+		timeout:      timeout,
+		timeoutReply: resp.NullArray(),
+	})
+}
+
+// zstoreInput is a source of ZUNIONSTORE and friends, sets count as sorted sets
+// whose scores are all 1.
+type zstoreInput struct {
+	z  *zset
+	st *set
+}
+
+func (in zstoreInput) Len() int {
+	switch {
+	case in.z != nil:
+		return in.z.Len()
+	case in.st != nil:
+		return in.st.Len()
+	}
+	return 0
+}
+
+func (in zstoreInput) Score(member string) (float64, bool) {
+	switch {
+	case in.z != nil:
+		return in.z.Score(member)
+	case in.st != nil:
+		return 1, in.st.Contains(member)
+	}
+	return 0, false
+}
+
+func (in zstoreInput) forEach(fn func(member string, score float64)) {
+	switch {
+	case in.z != nil:
+		for node := in.z.zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+			fn(node.member, node.score)
+		}
+	case in.st != nil:
+		for _, member := range in.st.Members() {
+			fn(member, 1)
+		}
+	}
+}
+
+func aggregateScores(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "MIN":
+		return math.Min(a, b)
+	case "MAX":
+		return math.Max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		// inf + -inf
+		return 0
+	}
+	return sum
+}
+
+// handleZStore serves ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE.
+func (s *server) handleZStore(req *Request) ([]resp.Value, error) {
+	command := strings.ToUpper(string(req.Command))
+	dst := req.Args[0]
+	numkeys, err := strconv.Atoi(req.Args[1])
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if numkeys < 1 {
+		return nil, errors.New("ERR at least 1 input key is needed for '" + strings.ToLower(command) + "' command")
+	}
+	if numkeys > len(req.Args)-2 {
+		return nil, errSyntax
+	}
+	keys, rest := req.Args[2:2+numkeys], req.Args[2+numkeys:]
+	weights := make([]float64, numkeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "SUM"
+	for len(rest) > 0 {
+		switch option := strings.ToUpper(rest[0]); {
+		case option == "WEIGHTS" && command != "ZDIFFSTORE" && len(rest) > numkeys:
+			for i := range weights {
+				w, err := parseScore(rest[1+i])
+				if err != nil {
+					return nil, errors.New("ERR weight value is not a float")
+				}
+				weights[i] = w
+			}
+			rest = rest[1+numkeys:]
+		case option == "AGGREGATE" && command != "ZDIFFSTORE" && len(rest) > 1:
+			aggregate = strings.ToUpper(rest[1])
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return nil, errSyntax
+			}
+			rest = rest[2:]
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	now := time.Now()
+	inputs := make([]zstoreInput, numkeys)
+	for i, key := range keys {
+		res, ok := s.InMemoryStore.lookup(key, now)
+		if !ok {
+			continue
+		}
+		switch v := res.value.(type) {
+		case *zset:
+			inputs[i].z = v
+		case *set:
+			inputs[i].st = v
+		default:
+			return nil, errWrongType
+		}
+	}
+
+	weighted := func(score, weight float64) float64 {
+		w := score * weight
+		if math.IsNaN(w) {
+			// 0 * inf
+			return 0
+		}
+		return w
+	}
+	result := newZSet()
+	switch command {
+	case "ZUNIONSTORE":
+		for i, in := range inputs {
+			in.forEach(func(member string, score float64) {
+				score = weighted(score, weights[i])
+				if current, ok := result.Score(member); ok {
+					score = aggregateScores(aggregate, current, score)
+				}
+				result.Set(member, score)
+			})
+		}
+	case "ZINTERSTORE":
+		inputs[0].forEach(func(member string, score float64) {
+			score = weighted(score, weights[0])
+			for i, in := range inputs[1:] {
+				other, ok := in.Score(member)
+				if !ok {
+					return
+				}
+				score = aggregateScores(aggregate, score, weighted(other, weights[i+1]))
+			}
+			result.Set(member, score)
+		})
+	case "ZDIFFSTORE":
+		inputs[0].forEach(func(member string, score float64) {
+			for _, in := range inputs[1:] {
+				if _, ok := in.Score(member); ok {
+					return
+				}
+			}
+			result.Set(member, score)
+		})
+	}
+	s.storeZSet(dst, result)
+	return reply(resp.Integer(int64(result.Len())))
+}
+
+func (s *server) handleZRandMember(req *Request) ([]resp.Value, error) {
+	if len(req.Args) > 3 || (len(req.Args) == 3 && !strings.EqualFold(req.Args[2], "WITHSCORES")) {
+		return nil, errSyntax
+	}
+	z, exists, err := s.lookupZSet(req.Args[0], time.Now())
+	if err != nil {
+		return nil, err
+	}
+	members := []string{}
+	if exists {
+		for member := range z.dict {
+			members = append(members, member)
+		}
+	}
+	if len(req.Args) == 1 {
+		if !exists {
+			return reply(resp.NullBulk())
+		}
+		return reply(resp.BulkString(members[rand.Intn(len(members))]))
+	}
+	count, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if !exists || count == 0 {
+		return reply(resp.Array())
+	}
+	entries := []zsetEntry{}
+	for _, member := range randomMembers(members, count) {
+		entries = append(entries, zsetEntry{member, z.dict[member]})
+	}
+	return reply(scoreReplies(entries, len(req.Args) == 3))
+}
@@ -14,6 +14,26 @@ var (
 	dbfilename string
 	port       string
 	replicaof  string
+	hz         int
+
+	hashMaxListpackEntries int
+	hashMaxListpackValue   int
+	setMaxIntsetEntries    int
+	streamNodeMaxEntries   int
+	hllSparseMaxBytes      int
+
+	save                    string
+	stopWritesOnBgsaveError bool
+
+	appendonly       bool
+	appendfilename   string
+	appenddirname    string
+	appendfsync      string
+	aofLoadTruncated bool
+
+	aofUseRdbPreamble        bool
+	autoAofRewritePercentage int
+	autoAofRewriteMinSize    string
 )
 
 func init() {
@@ -22,12 +42,44 @@ func init() {
 	serverStartCmd.Flags().StringVar(&dbfilename, "dbfilename", "dump.rdb", "Database filename for the server")
 	serverStartCmd.Flags().StringVar(&port, "port", "6379", "port to run server from")
 	serverStartCmd.Flags().StringVar(&replicaof, "replicaof", "", "port to run server from")
+	serverStartCmd.Flags().IntVar(&hz, "hz", 10, "frequency of background tasks such as active key expiration")
+	serverStartCmd.Flags().IntVar(&hashMaxListpackEntries, "hash-max-listpack-entries", 128, "maximum number of fields of a hash kept in the compact encoding")
+	serverStartCmd.Flags().IntVar(&hashMaxListpackValue, "hash-max-listpack-value", 64, "maximum size of the fields and values of a hash kept in the compact encoding")
+	serverStartCmd.Flags().IntVar(&setMaxIntsetEntries, "set-max-intset-entries", 512, "maximum number of members of a set of integers kept in the compact encoding")
+	serverStartCmd.Flags().IntVar(&streamNodeMaxEntries, "stream-node-max-entries", 100, "maximum number of entries of a stream grouped in a single node")
+	serverStartCmd.Flags().IntVar(&hllSparseMaxBytes, "hll-sparse-max-bytes", 3000, "maximum size of a HyperLogLog kept in the sparse encoding, header included")
+	serverStartCmd.Flags().StringVar(&save, "save", "3600 1 300 100 60 10000", "pairs of seconds and changes triggering a background save, empty to disable")
+	serverStartCmd.Flags().BoolVar(&stopWritesOnBgsaveError, "stop-writes-on-bgsave-error", true, "reject writes while the last background save failed")
+	serverStartCmd.Flags().BoolVar(&appendonly, "appendonly", false, "log every write to the append only file and load it on startup")
+	serverStartCmd.Flags().StringVar(&appendfilename, "appendfilename", "appendonly.aof", "prefix of the names of the append only files")
+	serverStartCmd.Flags().StringVar(&appenddirname, "appenddirname", "appendonlydir", "directory of the append only files, inside dir")
+	serverStartCmd.Flags().StringVar(&appendfsync, "appendfsync", "everysec", "when the append only file is flushed to disk: always, everysec or no")
+	serverStartCmd.Flags().BoolVar(&aofLoadTruncated, "aof-load-truncated", true, "load an append only file whose last command is truncated")
+	serverStartCmd.Flags().BoolVar(&aofUseRdbPreamble, "aof-use-rdb-preamble", true, "write the base of the append only file in the RDB format")
+	serverStartCmd.Flags().IntVar(&autoAofRewritePercentage, "auto-aof-rewrite-percentage", 100, "growth of the append only file since the last rewrite triggering a new one, 0 to disable")
+	serverStartCmd.Flags().StringVar(&autoAofRewriteMinSize, "auto-aof-rewrite-min-size", "64mb", "minimum size of the append only file for an automatic rewrite")
 
 	// Bind flags to Viper
 	viper.BindPFlag("dir", serverStartCmd.Flags().Lookup("dir"))
 	viper.BindPFlag("dbfilename", serverStartCmd.Flags().Lookup("dbfilename"))
 	viper.BindPFlag("port", serverStartCmd.Flags().Lookup("port"))
 	viper.BindPFlag("replicaof", serverStartCmd.Flags().Lookup("replicaof"))
+	viper.BindPFlag("hz", serverStartCmd.Flags().Lookup("hz"))
+	viper.BindPFlag("hash-max-listpack-entries", serverStartCmd.Flags().Lookup("hash-max-listpack-entries"))
+	viper.BindPFlag("hash-max-listpack-value", serverStartCmd.Flags().Lookup("hash-max-listpack-value"))
+	viper.BindPFlag("set-max-intset-entries", serverStartCmd.Flags().Lookup("set-max-intset-entries"))
+	viper.BindPFlag("stream-node-max-entries", serverStartCmd.Flags().Lookup("stream-node-max-entries"))
+	viper.BindPFlag("hll-sparse-max-bytes", serverStartCmd.Flags().Lookup("hll-sparse-max-bytes"))
+	viper.BindPFlag("save", serverStartCmd.Flags().Lookup("save"))
+	viper.BindPFlag("stop-writes-on-bgsave-error", serverStartCmd.Flags().Lookup("stop-writes-on-bgsave-error"))
+	viper.BindPFlag("appendonly", serverStartCmd.Flags().Lookup("appendonly"))
+	viper.BindPFlag("appendfilename", serverStartCmd.Flags().Lookup("appendfilename"))
+	viper.BindPFlag("appenddirname", serverStartCmd.Flags().Lookup("appenddirname"))
+	viper.BindPFlag("appendfsync", serverStartCmd.Flags().Lookup("appendfsync"))
+	viper.BindPFlag("aof-load-truncated", serverStartCmd.Flags().Lookup("aof-load-truncated"))
+	viper.BindPFlag("aof-use-rdb-preamble", serverStartCmd.Flags().Lookup("aof-use-rdb-preamble"))
+	viper.BindPFlag("auto-aof-rewrite-percentage", serverStartCmd.Flags().Lookup("auto-aof-rewrite-percentage"))
+	viper.BindPFlag("auto-aof-rewrite-min-size", serverStartCmd.Flags().Lookup("auto-aof-rewrite-min-size"))
 }
 
 var serverStartCmd = &cobra.Command{
@@ -40,6 +92,31 @@ var serverStartCmd = &cobra.Command{
 		dbfilename := viper.GetString("dbfilename")
 		port := viper.GetString("port")
 		replicaOf := viper.GetString("replicaof")
+		hz := viper.GetInt("hz")
+		hashMaxListpackEntries := viper.GetInt("hash-max-listpack-entries")
+		hashMaxListpackValue := viper.GetInt("hash-max-listpack-value")
+		setMaxIntsetEntries := viper.GetInt("set-max-intset-entries")
+		streamNodeMaxEntries := viper.GetInt("stream-node-max-entries")
+		hllSparseMaxBytes := viper.GetInt("hll-sparse-max-bytes")
+		saveRules, err := app.ParseSaveRules(viper.GetString("save"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		stopWritesOnBgsaveError := viper.GetBool("stop-writes-on-bgsave-error")
+		appendonly := viper.GetBool("appendonly")
+		appendfilename := viper.GetString("appendfilename")
+		appenddirname := viper.GetString("appenddirname")
+		appendfsync, err := app.ParseAppendFsync(viper.GetString("appendfsync"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		aofLoadTruncated := viper.GetBool("aof-load-truncated")
+		aofUseRdbPreamble := viper.GetBool("aof-use-rdb-preamble")
+		autoAofRewritePercentage := viper.GetInt("auto-aof-rewrite-percentage")
+		autoAofRewriteMinSize, err := app.ParseMemory(viper.GetString("auto-aof-rewrite-min-size"))
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		fmt.Printf("Starting server on port %s...\n", port)
 		fmt.Printf("Using directory: %s\n", dir)
@@ -50,11 +127,31 @@ var serverStartCmd = &cobra.Command{
 			Dir:        dir,
 			DbFilename: dbfilename,
 			Port:       port,
+			Hz:         hz,
+
+			HashMaxListpackEntries: hashMaxListpackEntries,
+			HashMaxListpackValue:   hashMaxListpackValue,
+			SetMaxIntsetEntries:    setMaxIntsetEntries,
+			StreamNodeMaxEntries:   streamNodeMaxEntries,
+			HllSparseMaxBytes:      hllSparseMaxBytes,
+
+			SaveRules:               saveRules,
+			StopWritesOnBgsaveError: stopWritesOnBgsaveError,
+
+			AppendOnly:       appendonly,
+			AppendFilename:   appendfilename,
+			AppendDirname:    appenddirname,
+			AppendFsync:      appendfsync,
+			AofLoadTruncated: aofLoadTruncated,
+
+			AofUseRdbPreamble:        aofUseRdbPreamble,
+			AutoAofRewritePercentage: autoAofRewritePercentage,
+			AutoAofRewriteMinSize:    autoAofRewriteMinSize,
 		}
 		if replicaOf != "" {
 			config.ReplicaOf = &replicaOf
 		}
-		err := app.RunServer(config)
+		err = app.RunServer(config)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,119 @@
+package resp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Type identifies how a Value is encoded on the wire.
+type Type int
+
+const (
+	TypeSimpleString Type = iota
+	TypeError
+	TypeInteger
+	TypeBulkString
+	TypeArray
+	// TypeRDBFile is the bulk-like payload sent after FULLRESYNC, it has no trailing CRLF
+	TypeRDBFile
+)
+
+// Value is a single RESP2 reply. Null bulk strings and null arrays are represented
+// by setting Null on a value of the corresponding type.
+type Value struct {
+	Type  Type
+	Str   string
+	Int   int64
+	Array []Value
+	Null  bool
+}
+
+var OK = SimpleString("OK")
+
+func SimpleString(s string) Value {
+	return Value{Type: TypeSimpleString, Str: s}
+}
+
+// Error builds an error reply, msg must start with the error code (e.g. "ERR ...").
+func Error(msg string) Value {
+	return Value{Type: TypeError, Str: msg}
+}
+
+func Errorf(format string, args ...any) Value {
+	return Error(fmt.Sprintf(format, args...))
+}
+
+func Integer(n int64) Value {
+	return Value{Type: TypeInteger, Int: n}
+}
+
+func Bool(b bool) Value {
+	if b {
+		return Integer(1)
+	}
+	return Integer(0)
+}
+
+func Bulk(b []byte) Value {
+	return Value{Type: TypeBulkString, Str: string(b)}
+}
+
+func BulkString(s string) Value {
+	return Value{Type: TypeBulkString, Str: s}
+}
+
+func NullBulk() Value {
+	return Value{Type: TypeBulkString, Null: true}
+}
+
+func Array(values ...Value) Value {
+	if values == nil {
+		values = []Value{}
+	}
+	return Value{Type: TypeArray, Array: values}
+}
+
+func NullArray() Value {
+	return Value{Type: TypeArray, Null: true}
+}
+
+// BulkStrings builds an array of bulk strings.
+func BulkStrings(items []string) Value {
+	values := make([]Value, len(items))
+	for i, item := range items {
+		values[i] = BulkString(item)
+	}
+	return Array(values...)
+}
+
+func RDBFile(b []byte) Value {
+	return Value{Type: TypeRDBFile, Str: string(b)}
+}
+
+// IsError reports whether the value is an error reply.
+func (v Value) IsError() bool {
+	return v.Type == TypeError
+}
+
+func (v Value) String() string {
+	switch v.Type {
+	case TypeSimpleString:
+		return v.Str
+	case TypeError:
+		return "(error) " + v.Str
+	case TypeInteger:
+		return "(integer) " + strconv.FormatInt(v.Int, 10)
+	case TypeBulkString, TypeRDBFile:
+		if v.Null {
+			return "(nil)"
+		}
+		return strconv.Quote(v.Str)
+	case TypeArray:
+		if v.Null {
+			return "(nil)"
+		}
+		return fmt.Sprint(v.Array)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,94 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Writer encodes values into a buffered stream, callers decide when to Flush.
+type Writer struct {
+	w       *bufio.Writer
+	scratch []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) WriteValue(v Value) error {
+	switch v.Type {
+	case TypeSimpleString:
+		return w.writeLine('+', v.Str)
+	case TypeError:
+		return w.writeLine('-', v.Str)
+	case TypeInteger:
+		return w.writeNumber(':', v.Int)
+	case TypeBulkString:
+		if v.Null {
+			return w.writeNumber('$', -1)
+		}
+		if err := w.writeNumber('$', int64(len(v.Str))); err != nil {
+			return err
+		}
+		return w.writeLine(0, v.Str)
+	case TypeRDBFile:
+		if err := w.writeNumber('$', int64(len(v.Str))); err != nil {
+			return err
+		}
+		_, err := w.w.WriteString(v.Str)
+		return err
+	case TypeArray:
+		if v.Null {
+			return w.writeNumber('*', -1)
+		}
+		if err := w.writeNumber('*', int64(len(v.Array))); err != nil {
+			return err
+		}
+		for _, item := range v.Array {
+			if err := w.WriteValue(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown RESP type %d", v.Type)
+	}
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeNumber(prefix byte, n int64) error {
+	w.scratch = append(w.scratch[:0], prefix)
+	w.scratch = strconv.AppendInt(w.scratch, n, 10)
+	w.scratch = append(w.scratch, '\r', '\n')
+	_, err := w.w.Write(w.scratch)
+	return err
+}
+
+func (w *Writer) writeLine(prefix byte, s string) error {
+	if prefix != 0 {
+		if err := w.w.WriteByte(prefix); err != nil {
+			return err
+		}
+	}
+	if _, err := w.w.WriteString(s); err != nil {
+		return err
+	}
+	_, err := w.w.WriteString("\r\n")
+	return err
+}
+
+// Encode returns the wire representation of v.
+func Encode(v Value) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	// writes into a bytes.Buffer cannot fail
+	_ = w.WriteValue(v)
+	_ = w.Flush()
+	return buf.Bytes()
+}